@@ -0,0 +1,119 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// archiveFile is the append-only log of every HyperSignal the daemon received
+const archiveFile = "events.jsonl"
+
+// Sources recorded for signals read from signals.jsonl and from the import inbox
+const (
+	archiveSourceStored   = signalsFile
+	archiveSourceImported = signalsInbox
+)
+
+// Archive decisions
+const (
+	archiveAccepted = "accepted"
+	archiveRejected = "rejected"
+)
+
+// archiveRecord is one received HyperSignal with the decision taken on it
+type archiveRecord struct {
+	ReceivedAt time.Time   `json:"received_at"`
+	Source     string      `json:"source"`           // Relay URL, signals.jsonl or signals-inbox
+	Live       bool        `json:"live,omitempty"`   // Received after the relays' stored events were replayed
+	Decision   string      `json:"decision"`         // accepted or rejected
+	Reason     string      `json:"reason,omitempty"` // Rejection reason
+	Detail     string      `json:"detail,omitempty"` // Rejection detail
+	Event      nostr.Event `json:"event"`
+}
+
+// eventArchive appends archive records to events.jsonl, rotating it like manager.log
+type eventArchive struct {
+	w io.WriteCloser
+}
+
+// newEventArchive opens the archive in configDir. Rotated files are compressed and kept
+// until removed by hand, as they are the node's audit trail.
+func newEventArchive(configDir string) *eventArchive {
+	return &eventArchive{w: &lumberjack.Logger{
+		Filename: filepath.Join(configDir, archiveFile),
+		MaxSize:  10, // megabytes
+		Compress: true,
+	}}
+}
+
+// record appends the decision on ev; r is nil for an accepted event
+func (a *eventArchive) record(ev *nostr.Event, source string, live bool, receivedAt time.Time, r *rejection) {
+	rec := archiveRecord{
+		ReceivedAt: receivedAt.UTC(),
+		Source:     source,
+		Live:       live,
+		Decision:   archiveAccepted,
+		Event:      *ev,
+	}
+	if r != nil {
+		rec.Decision = archiveRejected
+		rec.Reason = r.Reason
+		rec.Detail = r.Detail
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		log.Printf("[WARN] Failed to archive event %s: %v", ev.ID, err)
+		return
+	}
+	// One write per record, so lines are never interleaved
+	if _, err := a.w.Write(append(data, '\n')); err != nil {
+		log.Printf("[WARN] Failed to archive event %s: %v", ev.ID, err)
+	}
+}
+
+// Close closes the current archive file
+func (a *eventArchive) Close() error {
+	return a.w.Close()
+}
+
+// readArchive reads archive records from path; rotated .gz files are decompressed
+func readArchive(path string) ([]archiveRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var records []archiveRecord
+	dec := json.NewDecoder(r)
+	for n := 1; ; n++ {
+		var rec archiveRecord
+		if err := dec.Decode(&rec); err == io.EOF {
+			return records, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("record %d: %w", n, err)
+		}
+		records = append(records, rec)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip46"
+)
+
+// testBunker is a stand-in NIP-46 remote signer listening on a test relay. Signing
+// requests are answered only once approve is closed, like a developer approving them.
+type testBunker struct {
+	pk      string
+	url     string
+	approve chan struct{}
+	pending atomic.Int32 // sign_event requests awaiting approval
+}
+
+// startTestBunker runs a bunker with a fresh key on relay until the test ends
+func startTestBunker(t *testing.T, relay *testRelay) *testBunker {
+	t.Helper()
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	b := &testBunker{pk: pk, url: "bunker://" + pk + "?relay=" + relay.URL, approve: make(chan struct{})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	conn, err := nostr.RelayConnect(ctx, relay.URL)
+	if err != nil {
+		t.Fatalf("bunker connect: %v", err)
+	}
+	sub, err := conn.Subscribe(ctx, nostr.Filters{{Kinds: []int{nostr.KindNostrConnect}, Tags: nostr.TagMap{"p": []string{pk}}}})
+	if err != nil {
+		t.Fatalf("bunker subscribe: %v", err)
+	}
+
+	signer := nip46.NewStaticKeySigner(sk)
+	go func() {
+		for ev := range sub.Events {
+			req, _, resp, err := signer.HandleRequest(ctx, ev)
+			if err != nil {
+				continue
+			}
+			go func() {
+				if req.Method == "sign_event" {
+					b.pending.Add(1)
+					select {
+					case <-b.approve:
+					case <-ctx.Done():
+						return
+					}
+					b.pending.Add(-1)
+				}
+				conn.Publish(ctx, resp)
+			}()
+		}
+	}()
+	return b
+}
+
+func TestDaemonSignsStatusThroughBunker(t *testing.T) {
+	relay := newTestRelay(t, false)
+	bunker := startTestBunker(t, relay)
+	devs := newTestDevs(4)
+	dir, _ := newTestConfigDir(t, relay, devs, 3)
+	editTestConfig(t, dir, func(cfg *Config) { cfg.Bunker = bunker.url })
+
+	startDaemon(t, dir)
+	waitFor(t, 10*time.Second, "daemon subscription", func() bool { return relay.subscriptions() > 1 })
+
+	now := nostr.Now()
+	for _, d := range devs[:3] {
+		relay.publish(d.signal(t, "1.1.0", "hqz", now))
+	}
+	waitFor(t, 5*time.Second, "signing request", func() bool { return bunker.pending.Load() == 1 })
+
+	// While the developer has not approved, the daemon keeps ingesting signals
+	relay.publish(devs[3].signal(t, "2.0.0", "hqz", now))
+	store := newSignalStore(dir)
+	waitFor(t, 5*time.Second, "signal ingested during signing", func() bool {
+		stored, _ := store.load()
+		return len(stored) == 4
+	})
+	if historyHas(dir, "upgrade:1.1.0") {
+		t.Fatal("action recorded before its status event was signed")
+	}
+
+	close(bunker.approve)
+	waitFor(t, 10*time.Second, "status event signed by the bunker", func() bool {
+		return len(statusEvents(relay, bunker.pk, "1.1.0")) == 1
+	})
+	if !historyHas(dir, "upgrade:1.1.0") {
+		t.Error("action missing from history after signing")
+	}
+}
+
+func TestDaemonAuthenticatesWithNodeKeyUnderBunker(t *testing.T) {
+	bunkerRelay := newTestRelay(t, false)
+	bunker := startTestBunker(t, bunkerRelay)
+	relay := newTestRelay(t, true)
+	devs := newTestDevs(3)
+	dir, node := newTestConfigDir(t, relay, devs, 2)
+	editTestConfig(t, dir, func(cfg *Config) { cfg.Bunker = bunker.url })
+
+	// AUTH is answered with the node key without asking the bunker, so the daemon
+	// reads from the relay while the developer has approved nothing
+	startDaemon(t, dir)
+	waitFor(t, 10*time.Second, "authentication as the node key", func() bool { return relay.authenticated(node) })
+	waitFor(t, 5*time.Second, "daemon subscription", func() bool { return relay.subscriptions() > 0 })
+	if relay.authenticated(bunker.pk) {
+		t.Error("authenticated as the bunker key")
+	}
+	if n := bunker.pending.Load(); n != 0 {
+		t.Errorf("%d signing requests sent to the bunker, want none for AUTH", n)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock that only moves when advanced
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+	timers  []*fakeTimer
+}
+
+// fakeTicker fires when its clock is advanced past the next tick
+type fakeTicker struct {
+	c      chan time.Time
+	period time.Duration
+	next   time.Time
+	clock  *fakeClock
+}
+
+// fakeTimer fires once when its clock is advanced to its deadline
+type fakeTimer struct {
+	c     chan time.Time
+	at    time.Time
+	clock *fakeClock
+}
+
+// newFakeClock returns a clock stopped at now
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) ticker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTicker{c: make(chan time.Time, 1), period: d, next: c.now.Add(d), clock: c}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{c: make(chan time.Time, 1), clock: c}
+	c.armLocked(t, d)
+	return t
+}
+
+// armLocked schedules t to fire d from now; c.mu must be held
+func (c *fakeClock) armLocked(t *fakeTimer, d time.Duration) {
+	t.at = c.now.Add(d)
+	c.timers = append(c.timers, t)
+	if d <= 0 {
+		c.fireTimersLocked()
+	}
+}
+
+// stopLocked unschedules t, reporting whether it was pending; c.mu must be held
+func (c *fakeClock) stopLocked(t *fakeTimer) bool {
+	for i, other := range c.timers {
+		if other == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// fireTimersLocked fires and unschedules the timers that came due; c.mu must be held
+func (c *fakeClock) fireTimersLocked() {
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			pending = append(pending, t)
+			continue
+		}
+		select {
+		case t.c <- c.now:
+		default:
+		}
+	}
+	c.timers = pending
+}
+
+// Advance moves the clock forward by d, firing the tickers and timers that came due.
+// Like time.Ticker, a tick is dropped if the previous one was not received yet.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	c.fireTimersLocked()
+	for _, t := range c.tickers {
+		if t.next.After(c.now) {
+			continue
+		}
+		select {
+		case t.c <- c.now:
+		default:
+		}
+		for !t.next.After(c.now) {
+			t.next = t.next.Add(t.period)
+		}
+	}
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.c }
+
+func (t *fakeTicker) Stop() {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, other := range c.tickers {
+		if other == t {
+			c.tickers = append(c.tickers[:i], c.tickers[i+1:]...)
+			return
+		}
+	}
+}
+
+// Timers reports how many timers are waiting to fire, so a test can advance the clock
+// once the code under test is waiting
+func (c *fakeClock) Timers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+
+// Stop and Reset drop a fire that was not received, as time.Timer does since Go 1.23
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.drain()
+	return t.clock.stopLocked(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.drain()
+	active := t.clock.stopLocked(t)
+	t.clock.armLocked(t, d)
+	return active
+}
+
+func (t *fakeTimer) drain() {
+	select {
+	case <-t.c:
+	default:
+	}
+}
+
+func TestFakeClockTicker(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := newFakeClock(start)
+	tk := clk.NewTicker(time.Minute)
+
+	clk.Advance(59 * time.Second)
+	select {
+	case <-tk.C():
+		t.Fatal("ticked before the period elapsed")
+	default:
+	}
+
+	clk.Advance(time.Second)
+	select {
+	case now := <-tk.C():
+		if !now.Equal(start.Add(time.Minute)) {
+			t.Fatalf("tick at %s, want %s", now, start.Add(time.Minute))
+		}
+	default:
+		t.Fatal("no tick after the period elapsed")
+	}
+
+	tk.Stop()
+	clk.Advance(time.Hour)
+	select {
+	case <-tk.C():
+		t.Fatal("stopped ticker ticked")
+	default:
+	}
+}
+
+func TestFakeClockTimer(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := newFakeClock(start)
+	tm := clk.NewTimer(time.Minute)
+
+	clk.Advance(30 * time.Second)
+	if !tm.Reset(time.Minute) {
+		t.Fatal("Reset of a pending timer reported it inactive")
+	}
+	clk.Advance(59 * time.Second)
+	select {
+	case <-tm.C():
+		t.Fatal("fired before the reset duration elapsed")
+	default:
+	}
+
+	clk.Advance(time.Second)
+	select {
+	case <-tm.C():
+	default:
+		t.Fatal("did not fire after the reset duration")
+	}
+	clk.Advance(time.Hour)
+	select {
+	case <-tm.C():
+		t.Fatal("fired twice")
+	default:
+	}
+	if tm.Stop() {
+		t.Error("Stop of a fired timer reported it pending")
+	}
+	if clk.Timers() != 0 {
+		t.Errorf("%d timers pending, want 0", clk.Timers())
+	}
+}
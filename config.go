@@ -7,6 +7,7 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/nbd-wtf/go-nostr/nip19"
 	"gopkg.in/yaml.v3"
@@ -14,12 +15,73 @@ import (
 
 // Config holds application settings loaded from YAML config file
 type Config struct {
-	Relays     []string `yaml:"relays"`  // List of relay URLs to connect to
-	Follows    []string `yaml:"follows"` // List of Nostr npubs to follow
-	Quorum     int      `yaml:"quorum"`  // Number of follows needed to trigger action
-	Network    string   `yaml:"network"` // Network identifier (e.g., "hqz", "testnet")
-	NodeID     string   `yaml:"node_id"` // Unique node identifier
-	ConfigPath string   `yaml:"-"`       // Path to config directory (not in YAML)
+	Relays     []string      `yaml:"relays"`  // List of relay URLs to connect to
+	Follows    []FollowEntry `yaml:"follows"` // Nostr npubs and/or NIP-05 identifiers to follow
+	Quorum     int           `yaml:"quorum"`  // Number of follows needed to trigger action
+	Network    string        `yaml:"network"` // Network identifier (e.g., "hqz", "testnet")
+	NodeID     string        `yaml:"node_id"` // Unique node identifier
+	ConfigPath string        `yaml:"-"`       // Path to config directory (not in YAML)
+
+	// NIP05Strict drops a follow whose npub no longer matches its NIP-05 identifier
+	// instead of only warning about it
+	NIP05Strict bool `yaml:"nip05_strict,omitempty"`
+
+	// DiscoverRelays adds the write relays from the follows' NIP-65 relay lists
+	// (kind 10002) to the configured relays
+	DiscoverRelays bool `yaml:"discover_relays,omitempty"`
+
+	// RelayAllow and RelayDeny are host patterns (e.g. "*.zenon.info") restricting which
+	// discovered relays are used; an empty allow list accepts any host not denied
+	RelayAllow []string `yaml:"relay_allow,omitempty"`
+	RelayDeny  []string `yaml:"relay_deny,omitempty"`
+
+	// MaxDiscoveredRelays caps the number of discovered relays (default: 5)
+	MaxDiscoveredRelays int `yaml:"max_discovered_relays,omitempty"`
+
+	// CheckInterval is how often quorum is re-checked; a check also runs whenever a
+	// vote arrives (default: 60s)
+	CheckInterval time.Duration `yaml:"check_interval,omitempty"`
+
+	// StreamTimeout is how long a relay subscription may go without events before the
+	// watchdog renews it (default: 30m)
+	StreamTimeout time.Duration `yaml:"stream_timeout,omitempty"`
+
+	// RelayAuth sets the NIP-42 authentication mode per relay URL: "off", "on-demand"
+	// (default: authenticate when a relay answers auth-required) or "always"
+	// (authenticate as soon as connected). The key "*" sets the default for other relays.
+	RelayAuth map[string]string `yaml:"relay_auth,omitempty"`
+
+	// NIP05Interval is how often NIP-05 identifiers of follows are re-resolved (default: 1h)
+	NIP05Interval time.Duration `yaml:"nip05_interval,omitempty"`
+
+	// ReleaseKeys lists npubs trusted to sign release binary hashes. These are separate
+	// from follows: when set, an action is only executed if its hash carries a valid
+	// release_sig from one of these keys.
+	ReleaseKeys []string `yaml:"release_keys,omitempty"`
+
+	// MaxClockSkew is how far ahead of the local clock an event's created_at may be
+	// before it is rejected (e.g. "10m"). A skewed dev clock would otherwise win every
+	// newer-signal comparison.
+	MaxClockSkew time.Duration `yaml:"max_clock_skew,omitempty"`
+
+	// Bunker is an optional NIP-46 remote signer URL (bunker://<pubkey>?relay=...).
+	// When set, HyperSignals and kind=3333 status events are signed remotely and
+	// keys.json only holds the client session key.
+	Bunker string `yaml:"bunker,omitempty"`
+
+	// RotationQuorum is the number of other follows that must endorse an announced
+	// key rotation before the new key replaces the old one (0 = the old key's
+	// announcement, countersigned by the new key, is sufficient)
+	RotationQuorum int `yaml:"rotation_quorum,omitempty"`
+
+	// FollowListAnchor is an optional npub whose signed follow list (kind 30000,
+	// d=hyperqube-follows) adds trusted developers on top of Follows. As the anchor's
+	// list is trusted on its own, a quorum must include at least one of Follows.
+	FollowListAnchor string `yaml:"follow_list_anchor,omitempty"`
+
+	// FollowListQuorum, when no anchor is set, trusts a pubkey once this many current
+	// follows list it in their own signed follow lists (0 = follow lists disabled)
+	FollowListQuorum int `yaml:"follow_list_quorum,omitempty"`
 }
 
 // generateNodeID creates a random UUID-like identifier for the node
@@ -108,17 +170,114 @@ node_id: ""
 		}
 	}
 
+	if cfg.MaxClockSkew <= 0 {
+		cfg.MaxClockSkew = defaultMaxClockSkew
+	}
+	if cfg.MaxDiscoveredRelays <= 0 {
+		cfg.MaxDiscoveredRelays = defaultMaxDiscoveredRelays
+	}
+	if cfg.CheckInterval <= 0 {
+		cfg.CheckInterval = defaultCheckInterval
+	}
+	if cfg.StreamTimeout <= 0 {
+		cfg.StreamTimeout = defaultStreamTimeout
+	}
+	if cfg.NIP05Interval <= 0 {
+		cfg.NIP05Interval = defaultNIP05Interval
+	}
+
 	log.Printf("[INFO] Loaded config: %d relay(s), %d follow(s), quorum=%d, network=%s, node_id=%s",
 		len(cfg.Relays), len(cfg.Follows), cfg.Quorum, cfg.Network, cfg.NodeID)
 
-	// Validate npubs
-	for _, npub := range cfg.Follows {
-		kind, _, err := nip19.Decode(npub)
-		if err != nil {
-			log.Fatalf("[ERROR] Invalid npub in config: %v", err)
+	// Validate follows
+	for _, f := range cfg.Follows {
+		if f.Npub == "" && f.NIP05 == "" {
+			log.Fatal("[ERROR] Empty follow entry in config: needs an npub and/or a nip05 identifier")
+		}
+		if f.Npub != "" {
+			kind, _, err := nip19.Decode(f.Npub)
+			if err != nil {
+				log.Fatalf("[ERROR] Invalid npub in config: %v", err)
+			}
+			if kind != "npub" {
+				log.Fatalf("[ERROR] Expected npub but got %s in config: %s", kind, f.Npub)
+			}
+		}
+		if f.NIP05 != "" {
+			if _, _, err := nip05URL(f.NIP05); err != nil {
+				log.Fatalf("[ERROR] Invalid NIP-05 identifier in config (%s): %v", f.NIP05, err)
+			}
+		}
+	}
+
+	// Validate release keys
+	releaseKeys, err := decodeNpubs(cfg.ReleaseKeys)
+	if err != nil {
+		log.Fatalf("[ERROR] Invalid release key in config: %v", err)
+	}
+	for i, pk := range releaseKeys {
+		for _, f := range cfg.Follows {
+			if f.Npub == "" {
+				continue
+			}
+			if _, followPk, _ := nip19.Decode(f.Npub); followPk == pk {
+				log.Fatalf("[ERROR] Release key %s is also a follow; release keys must be separate from voting keys", cfg.ReleaseKeys[i])
+			}
+		}
+	}
+	if len(cfg.ReleaseKeys) > 0 {
+		log.Printf("[INFO] Release signature verification enabled with %d trusted key(s)", len(cfg.ReleaseKeys))
+	}
+
+	// Validate remote signer URL
+	if cfg.Bunker != "" {
+		if u, err := url.Parse(cfg.Bunker); err != nil || u.Scheme != "bunker" {
+			log.Fatalf("[ERROR] Invalid bunker URL in config (must be bunker://...): %s", cfg.Bunker)
+		}
+	}
+
+	// Validate key rotation quorum
+	if cfg.RotationQuorum < 0 || cfg.RotationQuorum >= len(cfg.Follows) && cfg.RotationQuorum > 0 {
+		log.Fatalf("[ERROR] rotation_quorum must be between 0 and %d (other follows), got %d", len(cfg.Follows)-1, cfg.RotationQuorum)
+	}
+
+	// Validate signed follow list source
+	if cfg.FollowListAnchor != "" {
+		if _, err := decodeNpubs([]string{cfg.FollowListAnchor}); err != nil {
+			log.Fatalf("[ERROR] Invalid follow_list_anchor in config: %v", err)
+		}
+		if cfg.FollowListQuorum > 0 {
+			log.Fatal("[ERROR] Set either follow_list_anchor or follow_list_quorum, not both")
+		}
+		// The anchor's list needs no quorum, so every action needs a config follow's vote
+		if len(cfg.Follows) == 0 {
+			log.Fatal("[ERROR] follow_list_anchor needs at least one follow in config, as every quorum must include a config follow")
+		}
+		log.Printf("[WARN] follow_list_anchor %s is trusted to add voters on its own; every quorum must still include a config follow",
+			cfg.FollowListAnchor)
+	}
+	if cfg.FollowListQuorum < 0 {
+		log.Fatalf("[ERROR] follow_list_quorum must not be negative, got %d", cfg.FollowListQuorum)
+	}
+	// Below quorum, fewer follows than an action needs could list sock puppets that reach it
+	if cfg.FollowListQuorum > 0 && cfg.FollowListQuorum < cfg.Quorum {
+		log.Fatalf("[ERROR] follow_list_quorum must be at least quorum (%d), got %d", cfg.Quorum, cfg.FollowListQuorum)
+	}
+
+	// Validate relay discovery host patterns
+	for _, p := range append(cfg.RelayAllow, cfg.RelayDeny...) {
+		if !validHostPattern(p) {
+			log.Fatalf("[ERROR] Invalid relay host pattern in config: %q", p)
+		}
+	}
+
+	// Validate relay authentication modes
+	for relay, mode := range cfg.RelayAuth {
+		if !validRelayAuthMode(mode) {
+			log.Fatalf("[ERROR] Invalid relay_auth mode %q for %s (use off, on-demand or always)", mode, relay)
 		}
-		if kind != "npub" {
-			log.Fatalf("[ERROR] Expected npub but got %s in config: %s", kind, npub)
+		if _, err := url.ParseRequestURI(relay); relay != "*" && err != nil {
+			log.Fatalf("[ERROR] Invalid relay URL in relay_auth: %s", relay)
 		}
 	}
 
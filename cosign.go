@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"golang.org/x/term"
+)
+
+// fetchSignal fetches the kind 33321 HyperSignal referenced by a NIP-19 nevent, naddr or
+// note from relays and any relays named in the reference
+func fetchSignal(ctx context.Context, relays []string, ref string) (*nostr.Event, error) {
+	prefix, value, err := nip19.Decode(ref)
+	if err != nil {
+		return nil, fmt.Errorf("invalid event reference: %w", err)
+	}
+
+	var filter nostr.Filter
+	urls := slices.Clone(relays)
+	switch ptr := value.(type) {
+	case nostr.EventPointer:
+		filter.IDs = []string{ptr.ID}
+		urls = append(urls, ptr.Relays...)
+	case nostr.EntityPointer:
+		if ptr.Kind != 33321 || ptr.Identifier != "hyperqube" {
+			return nil, fmt.Errorf("%s refers to kind %d %q, not a HyperSignal", prefix, ptr.Kind, ptr.Identifier)
+		}
+		filter.Authors = []string{ptr.PublicKey}
+		filter.Kinds = []int{33321}
+		filter.Tags = nostr.TagMap{"d": []string{"hyperqube"}}
+		urls = append(urls, ptr.Relays...)
+	case string:
+		if prefix != "note" {
+			return nil, fmt.Errorf("%s is not an event reference (use nevent, naddr or note)", prefix)
+		}
+		filter.IDs = []string{ptr}
+	default:
+		return nil, fmt.Errorf("%s is not an event reference (use nevent, naddr or note)", prefix)
+	}
+	for i, u := range urls {
+		urls[i] = nostr.NormalizeURL(u)
+	}
+	slices.Sort(urls)
+	urls = slices.Compact(urls)
+
+	ctx, cancel := context.WithTimeout(ctx, signalFetchTimeout)
+	defer cancel()
+	pool := nostr.NewSimplePool(ctx)
+
+	// Relays are not trusted: keep the newest validly signed match
+	var found *nostr.Event
+	for re := range pool.FetchMany(ctx, urls, filter) {
+		ev := re.Event
+		if ok, _ := ev.CheckSignature(); !ok || ev.GetID() != ev.ID || !filter.Matches(ev) {
+			continue
+		}
+		if found == nil || ev.CreatedAt > found.CreatedAt {
+			found = ev
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("not found on %d relay(s)", len(urls))
+	}
+	if found.Kind != 33321 || getTagValue(found, "d") != "hyperqube" {
+		return nil, fmt.Errorf("event %s is kind %d, not a HyperSignal", found.ID, found.Kind)
+	}
+	return found, nil
+}
+
+// signalProposal returns the proposal of a HyperSignal after the checks send-message
+// applies to a new one
+func signalProposal(ev *nostr.Event) (proposal, error) {
+	p := proposal{
+		Action:  getTagValue(ev, "action"),
+		Version: getTagValue(ev, "version"),
+		Network: getTagValue(ev, "network"),
+		Genesis: getTagValue(ev, "genesis_url"),
+	}
+	if p.Action != "upgrade" && p.Action != "reboot" {
+		return p, fmt.Errorf("invalid action type '%s'", p.Action)
+	}
+	if _, err := semver.NewVersion(p.Version); err != nil {
+		return p, fmt.Errorf("invalid semantic version '%s': %v", p.Version, err)
+	}
+	if getTagValue(ev, "hash") == "" || p.Network == "" {
+		return p, fmt.Errorf("missing hash or network tag")
+	}
+	if err := validateHash(getTagValue(ev, "hash")); err != nil {
+		return p, fmt.Errorf("invalid hash tag: %w", err)
+	}
+	if p.Action == "reboot" && p.Genesis == "" {
+		return p, fmt.Errorf("reboot signal without genesis_url tag")
+	}
+	return p, nil
+}
+
+// confirm asks a yes/no question on the terminal; anything but y or yes declines
+func confirm(question string) bool {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		log.Fatal("[ERROR] Cannot ask for confirmation: stdin is not a terminal (use -yes)")
+	}
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// cosignCLI votes for an existing proposal by republishing the referenced HyperSignal's
+// tags and content under the local key, so the vote counts for the identical action key
+func cosignCLI(configDir, passphraseFile, ref, bunkerURL string, yes, dryRun, watch bool) {
+	cfg := loadConfig(configDir)
+
+	orig, err := fetchSignal(context.Background(), cfg.Relays, ref)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to fetch %s: %v", ref, err)
+	}
+	p, err := signalProposal(orig)
+	if err != nil {
+		log.Fatalf("[ERROR] Refusing to co-sign event %s: %v", orig.ID, err)
+	}
+
+	author, _ := nip19.EncodePublicKey(orig.PubKey)
+	fmt.Printf("HyperSignal %s by %s at %s:\n", orig.ID, author, orig.CreatedAt.Time().UTC().Format(time.RFC3339))
+	for _, tag := range orig.Tags {
+		if len(tag) > 1 && tag[0] != "d" {
+			fmt.Printf("  %-12s %s\n", tag[0]+":", strings.Join(tag[1:], " "))
+		}
+	}
+	fmt.Printf("  %-12s %s\n", "content:", orig.Content)
+
+	// A copy, so signing does not touch the fetched event
+	tags := make(nostr.Tags, len(orig.Tags))
+	for i, tag := range orig.Tags {
+		tags[i] = slices.Clone(tag)
+	}
+
+	if dryRun {
+		log.Println("[DRY RUN] Prepared co-signed HyperSignal event (kind 33321), not sending")
+		return
+	}
+	if !yes && !confirm("Publish this signal under your key? It replaces your current vote.") {
+		log.Println("[INFO] Not co-signing")
+		return
+	}
+
+	publishSignal(configDir, passphraseFile, bunkerURL, &cfg, tags, orig.Content, p, watch)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+func TestCosignFetchSignal(t *testing.T) {
+	relay := newTestRelay(t, false)
+	dev := newTestDevs(1)[0]
+	older := dev.signal(t, "1.1.0", "hqz", nostr.Now()-60)
+	latest := dev.signal(t, "1.2.0", "hqz", nostr.Now())
+	relay.publish(older)
+	relay.publish(latest)
+
+	nevent, _ := nip19.EncodeEvent(older.ID, []string{relay.URL}, dev.pk)
+	naddr, _ := nip19.EncodeEntity(dev.pk, 33321, "hyperqube", nil)
+	note, _ := nip19.EncodeNote(older.ID)
+
+	for ref, want := range map[string]nostr.Event{nevent: older, naddr: latest, note: older} {
+		// The nevent names its relay itself; the others rely on the configured relays
+		relays := []string{relay.URL}
+		if ref == nevent {
+			relays = nil
+		}
+		ev, err := fetchSignal(context.Background(), relays, ref)
+		if err != nil {
+			t.Fatalf("fetch %s: %v", ref[:6], err)
+		}
+		if ev.ID != want.ID {
+			t.Errorf("fetch %s = %s, want %s", ref[:6], ev.ID, want.ID)
+		}
+	}
+
+	p, err := signalProposal(&latest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.key() != "upgrade:1.2.0" || p.Network != "hqz" {
+		t.Errorf("proposal = %+v, want upgrade 1.2.0 on hqz", p)
+	}
+
+	other, _ := nip19.EncodeEntity(dev.pk, kindFollowList, followListDTag, nil)
+	if _, err := fetchSignal(context.Background(), []string{relay.URL}, other); err == nil {
+		t.Error("fetched a follow list as a HyperSignal")
+	}
+}
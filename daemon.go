@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// defaultCheckInterval is how often the daemon checks whether an action reached quorum,
+// in addition to checking whenever a vote arrives
+const defaultCheckInterval = 60 * time.Second
+
+// daemonOptions are the command-line settings the daemon runs with
+type daemonOptions struct {
+	configDir string
+	passFile  string
+	dryRun    bool
+	verbose   bool
+	clock     clock // Source of time; nil uses the wall clock
+}
+
+// runDaemon runs the pillar daemon from the config directory until ctx is cancelled:
+// it follows HyperSignals on the relays, tallies votes and acts once quorum is reached
+func runDaemon(ctx context.Context, opts daemonOptions) {
+	clk := opts.clock
+	if clk == nil {
+		clk = realClock{}
+	}
+
+	// The daemon runs unattended, so an encrypted key must be unlocked via
+	// QUBE_MANAGER_PASSPHRASE or --passphrase-file
+	log.Println("[INFO] Loading or creating keypair")
+	keypair, secretKey := loadSecretKey(opts.configDir, opts.passFile, false)
+	log.Printf("[INFO] Node identity: %s", keypair.Npub)
+
+	// Load configuration and history from files
+	config := loadConfig(opts.configDir)
+	history := loadHistory(opts.configDir, clk)
+
+	// Signer for kind=3333 status events: local key or remote NIP-46 bunker
+	signer, err := newSigner(context.Background(), secretKey, config.Bunker)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to set up signer: %v", err)
+	}
+
+	log.Printf("[INFO] Loaded config: %d relays, %d follows, quorum=%d",
+		len(config.Relays), len(config.Follows), config.Quorum)
+
+	// Every HyperSignal received is archived with the decision taken on it, for audit and replay
+	archive := newEventArchive(opts.configDir)
+	defer archive.Close()
+
+	// Background goroutines stop with the daemon and are waited for, so the config
+	// directory can be reused as soon as runDaemon returns
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+
+	// Vote tally built from accepted HyperSignal events
+	state := newSignalState()
+
+	// Counters for accepted and rejected events
+	stats := newIngestStats()
+
+	// One managed connection per relay, answering NIP-42 AUTH challenges with the
+	// node key as configured in relay_auth
+	authSigner, err := newAuthSigner(secretKey)
+	if err != nil {
+		log.Fatalf("[ERROR] Invalid node key: %v", err)
+	}
+	relays := newRelayManager(ctx, config.Relays, config.StreamTimeout, config.MaxClockSkew, clk)
+	relays.setAuth(authSigner, config.RelayAuth)
+
+	// Status events are kept in the outbox until each relay acknowledges them
+	outbox := loadOutbox(opts.configDir, history, relays, clk)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		outbox.run(ctx)
+	}()
+
+	// Resolve follows to hex pubkeys, checking NIP-05 identifiers
+	verifier := newNIP05Verifier(config.Follows, config.NIP05Strict)
+	hexFollows := verifier.resolve(ctx)
+	log.Printf("[INFO] Resolved %d of %d follows", len(hexFollows), len(config.Follows))
+
+	// Effective follows: static config follows with applied key rotations
+	follows := newFollowSet(hexFollows)
+	rotations := newRotationTracker(loadRotations(opts.configDir), follows, config.RotationQuorum)
+
+	// Optional signed follow list, applied on top of the static follows
+	var anchor string
+	if config.FollowListAnchor != "" {
+		keys, _ := decodeNpubs([]string{config.FollowListAnchor})
+		anchor = keys[0]
+	}
+	followLists := newFollowListTracker(follows, anchor, config.FollowListQuorum)
+
+	// Optional NIP-65 discovery of the follows' write relays
+	var discovery *relayDiscovery
+	if config.DiscoverRelays {
+		discovery = newRelayDiscovery(&config, follows, relays)
+		log.Printf("[INFO] Relay discovery enabled (up to %d extra relays)", config.MaxDiscoveredRelays)
+	}
+
+	// Periodically re-resolve NIP-05 identifiers so changes apply without a restart
+	if verifier.hasIdentifiers() {
+		log.Printf("[INFO] Re-resolving NIP-05 follows every %s", config.NIP05Interval)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			verifier.run(ctx, follows, config.NIP05Interval, clk)
+		}()
+	}
+
+	// Set once every relay has replayed its stored events
+	var live atomic.Bool
+
+	// Signals counted before a restart are ingested from signals.jsonl, and signals
+	// imported offline with import-signals from the inbox, at start and on every tick
+	store := newSignalStore(opts.configDir)
+	ingestEvents := func(events []nostr.Event, source string) int {
+		accepted := 0
+		for i := range events {
+			now := clk.Now()
+			r := state.ingest(&events[i], &config, follows.Snapshot(), now)
+			archive.record(&events[i], source, live.Load(), now, r)
+			if r != nil {
+				if r.Warn {
+					log.Printf("[WARN] Rejected signal %s from %s: %s", events[i].ID, source, r)
+				}
+				continue
+			}
+			accepted++
+		}
+		return accepted
+	}
+	ingestStored := func() {
+		events, err := store.load()
+		if err != nil {
+			log.Printf("[WARN] Failed to read stored signals: %v", err)
+			return
+		}
+		if accepted := ingestEvents(events, archiveSourceStored); accepted > 0 {
+			log.Printf("[INFO] Ingested %d stored signal(s) from %s", accepted, store.path)
+			saveSignals(store, state)
+		}
+	}
+	ingestInbox := func() {
+		events, files, err := store.loadInbox()
+		if err != nil {
+			log.Printf("[WARN] Failed to read imported signals: %v", err)
+			return
+		}
+		if len(files) == 0 {
+			return
+		}
+		sortEvents(events)
+		accepted := ingestEvents(events, archiveSourceImported)
+		log.Printf("[INFO] Ingested %d of %d imported signal(s) from %s", accepted, len(events), store.inbox)
+		// Keep the files until their signals are saved, so a failed save is retried
+		if accepted > 0 {
+			if err := store.save(state); err != nil {
+				log.Printf("[WARN] Failed to save signals: %v", err)
+				return
+			}
+		}
+		store.clearInbox(files)
+	}
+	ingestStored()
+	ingestInbox()
+
+	// Check quorum periodically, and as soon as a vote arrives so the decisive vote
+	// is acted on without waiting for the next tick. Votes replayed from the relays'
+	// stored events only trigger one check once the replay is complete, so a vote
+	// superseded later in the backlog is never acted on.
+	ticker := clk.NewTicker(config.CheckInterval)
+	defer ticker.Stop()
+	voted := make(chan struct{}, 1)
+	checkSoon := func() {
+		select {
+		case voted <- struct{}{}:
+		default:
+		}
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-ticker.C():
+				log.Printf("[DEBUG] Running periodic quorum check... (events %s)", stats.summary())
+				log.Printf("[INFO] Relays: %s (outbox: %d pending)", relays.summary(), outbox.pending())
+				if err := relays.writeStatus(opts.configDir); err != nil {
+					log.Printf("[WARN] Failed to write relay status: %v", err)
+				}
+				ingestInbox()
+				history.Reload()
+				checkAndExecuteQuorum(state, &config, history, follows, outbox, signer, clk, opts.dryRun)
+			case <-voted:
+				checkAndExecuteQuorum(state, &config, history, follows, outbox, signer, clk, opts.dryRun)
+			case <-ctx.Done():
+				log.Printf("[INFO] Quorum checker goroutine shutting down")
+				return
+			}
+		}
+	}()
+
+	log.Printf("[INFO] Started quorum check ticker (interval: %s)", config.CheckInterval)
+
+	// Subscribe to HyperSignal and key rotation events from the effective follows,
+	// resubscribing whenever the set changes. The daemon only stops when asked to: if the
+	// stream ends, it resubscribes from just before the newest accepted event.
+	var newest, resumeFrom nostr.Timestamp
+	for ctx.Err() == nil {
+		changed := follows.Changed()
+		authors := follows.Pubkeys()
+		filters := nostr.Filters{
+			{
+				Authors: authors,
+				Kinds:   []int{33321},
+				Tags:    nostr.TagMap{"d": []string{"hyperqube"}},
+			},
+			{
+				Authors: authors,
+				Kinds:   []int{kindKeyRotation},
+			},
+		}
+		if discovery != nil {
+			discovery.refresh()
+			filters = append(filters, nostr.Filter{
+				Authors: authors,
+				Kinds:   []int{kindRelayList},
+			})
+		}
+		if followLists.enabled() {
+			filters = append(filters, nostr.Filter{
+				Authors: followLists.authors(),
+				Kinds:   []int{kindFollowList},
+				Tags:    nostr.TagMap{"d": []string{followListDTag}},
+			})
+		}
+
+		subCtx, subCancel := context.WithCancel(ctx)
+		log.Printf("[INFO] Subscribing to %d relay(s) for kind=33321 and kind=%d events from %d follow(s)",
+			len(config.Relays), kindKeyRotation, len(authors))
+		events := relays.subscribe(subCtx, filtersSince(filters, resumeFrom), func() {
+			if !live.Swap(true) {
+				log.Printf("[INFO] Caught up on stored events (events %s)", stats.summary())
+				checkSoon()
+			}
+		})
+
+		reason := consumeEvents(ctx, events, changed, func(relayEvent nostr.RelayEvent) {
+			// Pool output is not trusted: re-check signature, author and timestamp before counting
+			var r *rejection
+			switch relayEvent.Event.Kind {
+			case kindKeyRotation:
+				r = rotations.handle(relayEvent.Event, &config, clk.Now())
+			case kindFollowList:
+				r = followLists.handle(relayEvent.Event, &config, clk.Now())
+			case kindRelayList:
+				if discovery != nil {
+					r = discovery.handle(relayEvent.Event, &config, clk.Now())
+				}
+			default:
+				now := clk.Now()
+				r = state.ingest(relayEvent.Event, &config, follows.Snapshot(), now)
+				archive.record(relayEvent.Event, relayEvent.Relay.URL, live.Load(), now, r)
+				if r == nil {
+					saveSignals(store, state)
+					if live.Load() {
+						checkSoon()
+					}
+				}
+			}
+			if r != nil {
+				logRejection(relayEvent.Event, relayEvent.Relay.URL, r, stats.reject(r.Reason), opts.verbose)
+				return
+			}
+			stats.accept()
+			newest = advanceCursor(newest, relayEvent.Event.CreatedAt, clk.Now(), config.MaxClockSkew)
+		})
+		subCancel()
+
+		switch reason {
+		case "":
+		case streamFollowsChanged:
+			// New authors need their full history, so no since filter
+			log.Printf("[INFO] Follow set changed, resubscribing")
+			resumeFrom = 0
+		default:
+			log.Printf("[WARN] %s unexpectedly (events %s), resubscribing", reason, stats.summary())
+			resumeFrom = newest
+			wait := clk.NewTimer(relayBackoffMin)
+			select {
+			case <-wait.C():
+			case <-ctx.Done():
+			}
+			wait.Stop()
+		}
+	}
+
+	log.Printf("[INFO] Event processing stopped (events %s)", stats.summary())
+	if err := relays.writeStatus(opts.configDir); err != nil {
+		log.Printf("[WARN] Failed to write relay status: %v", err)
+	}
+}
+
+// saveSignals persists the signals currently counted in state
+func saveSignals(store *signalStore, state *signalState) {
+	if err := store.save(state); err != nil {
+		log.Printf("[WARN] Failed to save signals: %v", err)
+	}
+}
@@ -0,0 +1,510 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"gopkg.in/yaml.v3"
+)
+
+// testSettle is how long a test waits before asserting that something did not happen
+const testSettle = 500 * time.Millisecond
+
+// testDev is a follow that publishes HyperSignals in a test
+type testDev struct {
+	sk, pk, npub string
+}
+
+// newTestDevs generates n developer keys
+func newTestDevs(n int) []testDev {
+	devs := make([]testDev, n)
+	for i := range devs {
+		sk := nostr.GeneratePrivateKey()
+		pk, _ := nostr.GetPublicKey(sk)
+		npub, _ := nip19.EncodePublicKey(pk)
+		devs[i] = testDev{sk, pk, npub}
+	}
+	return devs
+}
+
+// signal returns a signed upgrade HyperSignal for version on network
+func (d testDev) signal(t *testing.T, version, network string, createdAt nostr.Timestamp) nostr.Event {
+	t.Helper()
+	hash := sha256.Sum256([]byte("qube-manager " + version))
+	ev := nostr.Event{
+		Kind:      33321,
+		CreatedAt: createdAt,
+		Tags: nostr.Tags{
+			{"d", "hyperqube"},
+			{"version", version},
+			{"hash", hex.EncodeToString(hash[:])},
+			{"network", network},
+			{"action", "upgrade"},
+		},
+		Content: "upgrade to " + version,
+	}
+	if err := ev.Sign(d.sk); err != nil {
+		t.Fatalf("sign signal: %v", err)
+	}
+	return ev
+}
+
+// newTestConfigDir writes a config.yaml and keypair for a node following devs on relay,
+// returning the directory and the node's hex pubkey
+func newTestConfigDir(t *testing.T, relay *testRelay, devs []testDev, quorum int) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	kp := generateKeypair()
+	if err := saveKeypair(dir, kp); err != nil {
+		t.Fatalf("save keypair: %v", err)
+	}
+	_, pk, err := nip19.Decode(kp.Npub)
+	if err != nil {
+		t.Fatalf("decode node npub: %v", err)
+	}
+
+	cfg := Config{
+		Relays:  []string{relay.URL},
+		Quorum:  quorum,
+		Network: "hqz",
+		NodeID:  "test-node",
+	}
+	for _, d := range devs {
+		cfg.Follows = append(cfg.Follows, FollowEntry{Npub: d.npub})
+	}
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		t.Fatalf("marshal config: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config.yaml"), data, 0644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	return dir, pk.(string)
+}
+
+// editTestConfig rewrites config.yaml in dir after applying edit
+func editTestConfig(t *testing.T, dir string, edit func(*Config)) {
+	t.Helper()
+	path := filepath.Join(dir, "config.yaml")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	edit(&cfg)
+	if data, err = yaml.Marshal(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testDaemon is a daemon running in the test process. Its clock only moves when the
+// test advances it, so quorum is only checked when votes arrive unless a test ticks.
+type testDaemon struct {
+	clock  *fakeClock
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// skipUnderRace skips tests that connect go-nostr to a relay when the race detector is on:
+// go-nostr writes and reads Relay.Connection unguarded when a connection closes. newTestRelay
+// and startDaemon call it, so any test using them is skipped whatever its name, and runs in
+// the second pass of `make test` without -race.
+func skipUnderRace(t *testing.T) {
+	t.Helper()
+	if raceEnabled {
+		t.Skip("relay tests run without -race (see `make test`)")
+	}
+}
+
+// startDaemon runs the daemon on configDir until stop is called or the test ends
+func startDaemon(t *testing.T, configDir string) *testDaemon {
+	t.Helper()
+	skipUnderRace(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &testDaemon{clock: newFakeClock(time.Now()), cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(d.done)
+		runDaemon(ctx, daemonOptions{configDir: configDir, clock: d.clock})
+	}()
+	t.Cleanup(d.stop)
+	return d
+}
+
+// stop shuts the daemon down and waits for it to exit
+func (d *testDaemon) stop() {
+	d.cancel()
+	<-d.done
+}
+
+// waitFor polls cond until it holds, failing the test after timeout
+func waitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out after %s waiting for %s", timeout, what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// statusEvents returns the kind 3333 events the node published for version
+func statusEvents(relay *testRelay, pubkey, version string) []nostr.Event {
+	return relay.query(nostr.Filter{
+		Kinds:   []int{3333},
+		Authors: []string{pubkey},
+		Tags:    nostr.TagMap{"version": []string{version}},
+	})
+}
+
+// historyHas reports whether the history in configDir records key
+func historyHas(configDir, key string) bool {
+	h, err := readHistory(configDir)
+	return err == nil && h.Has(key)
+}
+
+func TestDaemonQuorumReached(t *testing.T) {
+	relay := newTestRelay(t, false)
+	devs := newTestDevs(5)
+	dir, node := newTestConfigDir(t, relay, devs, 3)
+	startDaemon(t, dir)
+
+	now := nostr.Now()
+	for _, d := range devs[:2] {
+		relay.publish(d.signal(t, "1.1.0", "hqz", now))
+	}
+	waitFor(t, 5*time.Second, "daemon subscription", func() bool { return relay.subscriptions() > 0 })
+	time.Sleep(testSettle)
+	if historyHas(dir, "upgrade:1.1.0") {
+		t.Fatal("action executed with 2 of 3 required votes")
+	}
+
+	// The decisive vote is acted on at once, without waiting for a periodic check
+	relay.publish(devs[2].signal(t, "1.1.0", "hqz", now))
+	waitFor(t, 5*time.Second, "status event", func() bool { return len(statusEvents(relay, node, "1.1.0")) == 1 })
+	if !historyHas(dir, "upgrade:1.1.0") {
+		t.Fatal("action missing from history after quorum")
+	}
+
+	ev := statusEvents(relay, node, "1.1.0")[0]
+	if got := getTagValue(&ev, "node_id"); got != "test-node" {
+		t.Errorf("status event node_id = %q, want test-node", got)
+	}
+	if got := getTagValue(&ev, "network"); got != "hqz" {
+		t.Errorf("status event network = %q, want hqz", got)
+	}
+	waitFor(t, 5*time.Second, "relay acknowledgement in history", func() bool {
+		entry := loadHistory(dir, realClock{}).Entries["upgrade:1.1.0"]
+		return len(entry.AckedBy) == 1
+	})
+}
+
+func TestDaemonNewerSignalSupersedesVote(t *testing.T) {
+	relay := newTestRelay(t, false)
+	devs := newTestDevs(5)
+	dir, node := newTestConfigDir(t, relay, devs, 3)
+
+	// devs[2] first votes for 1.2.0, then switches to 1.1.0. Counting the stale vote
+	// would give 1.2.0 three votes, and the higher version would win.
+	now := nostr.Now()
+	relay.publish(devs[0].signal(t, "1.2.0", "hqz", now-60))
+	relay.publish(devs[1].signal(t, "1.2.0", "hqz", now-60))
+	relay.publish(devs[2].signal(t, "1.2.0", "hqz", now-60))
+	relay.publish(devs[2].signal(t, "1.1.0", "hqz", now))
+	relay.publish(devs[3].signal(t, "1.1.0", "hqz", now))
+	relay.publish(devs[4].signal(t, "1.1.0", "hqz", now))
+
+	startDaemon(t, dir)
+	waitFor(t, 5*time.Second, "status event for 1.1.0", func() bool { return len(statusEvents(relay, node, "1.1.0")) == 1 })
+	time.Sleep(testSettle)
+	if historyHas(dir, "upgrade:1.2.0") || len(statusEvents(relay, node, "1.2.0")) > 0 {
+		t.Fatal("superseded vote counted towards 1.2.0")
+	}
+}
+
+func TestDaemonIgnoresOtherNetwork(t *testing.T) {
+	relay := newTestRelay(t, false)
+	devs := newTestDevs(5)
+	dir, node := newTestConfigDir(t, relay, devs, 3)
+
+	now := nostr.Now()
+	for _, d := range devs[:3] {
+		relay.publish(d.signal(t, "2.0.0", "testnet", now))
+	}
+	startDaemon(t, dir)
+	waitFor(t, 5*time.Second, "daemon subscription", func() bool { return relay.subscriptions() > 0 })
+
+	// Votes on our network afterwards act as the marker that the others were processed
+	for _, d := range devs[2:] {
+		relay.publish(d.signal(t, "1.1.0", "hqz", now+1))
+	}
+	waitFor(t, 5*time.Second, "status event for 1.1.0", func() bool { return len(statusEvents(relay, node, "1.1.0")) == 1 })
+	if historyHas(dir, "upgrade:2.0.0") || len(statusEvents(relay, node, "2.0.0")) > 0 {
+		t.Fatal("acted on a signal for another network")
+	}
+}
+
+func TestDaemonRestartPreservesState(t *testing.T) {
+	relay := newTestRelay(t, false)
+	devs := newTestDevs(5)
+	dir, node := newTestConfigDir(t, relay, devs, 3)
+
+	// Restart mid-voting: the votes cast before are restored from the relays
+	now := nostr.Now()
+	for _, d := range devs[:2] {
+		relay.publish(d.signal(t, "1.1.0", "hqz", now))
+	}
+	daemon := startDaemon(t, dir)
+	waitFor(t, 5*time.Second, "daemon subscription", func() bool { return relay.subscriptions() > 0 })
+	daemon.stop()
+
+	before := relay.subscriptions()
+	daemon = startDaemon(t, dir)
+	waitFor(t, 5*time.Second, "resubscription", func() bool { return relay.subscriptions() > before })
+	relay.publish(devs[2].signal(t, "1.1.0", "hqz", now))
+	waitFor(t, 5*time.Second, "status event", func() bool { return len(statusEvents(relay, node, "1.1.0")) == 1 })
+	daemon.stop()
+
+	// Restart after acting: history prevents executing the action again
+	before = relay.subscriptions()
+	startDaemon(t, dir)
+	waitFor(t, 5*time.Second, "resubscription", func() bool { return relay.subscriptions() > before })
+	time.Sleep(testSettle)
+
+	if n := len(statusEvents(relay, node, "1.1.0")); n != 1 {
+		t.Fatalf("got %d status events after restart, want 1 (action repeated)", n)
+	}
+	if !historyHas(dir, "upgrade:1.1.0") {
+		t.Fatal("history lost across restart")
+	}
+}
+
+func TestDaemonAuthenticatesToRelay(t *testing.T) {
+	relay := newTestRelay(t, true)
+	devs := newTestDevs(3)
+	dir, node := newTestConfigDir(t, relay, devs, 2)
+
+	now := nostr.Now()
+	for _, d := range devs[:2] {
+		relay.publish(d.signal(t, "1.1.0", "hqz", now))
+	}
+	startDaemon(t, dir)
+	waitFor(t, 10*time.Second, "status event", func() bool { return len(statusEvents(relay, node, "1.1.0")) == 1 })
+	if !relay.authenticated(node) {
+		t.Fatal("node published without authenticating")
+	}
+}
+
+func TestDaemonRecoversTruncatedHistory(t *testing.T) {
+	relay := newTestRelay(t, false)
+	devs := newTestDevs(3)
+	dir, node := newTestConfigDir(t, relay, devs, 2)
+
+	// 1.1.0 was executed before the history file was cut short
+	h := loadHistory(dir, realClock{})
+	h.Add("upgrade:1.1.0", HistoryEntry{ExecutedAt: time.Now(), Type: "upgrade", Version: "1.1.0", Status: "success"})
+	if err := h.Save(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "history.yaml")
+	data, _ := os.ReadFile(path)
+	os.WriteFile(path, truncateHistory(data), 0644)
+
+	// The daemon starts from the backup and does not execute 1.1.0 again
+	startDaemon(t, dir)
+	now := nostr.Now()
+	for _, d := range devs[:2] {
+		relay.publish(d.signal(t, "1.1.0", "hqz", now))
+	}
+	waitFor(t, 5*time.Second, "daemon subscription", func() bool { return relay.subscriptions() > 0 })
+	time.Sleep(testSettle)
+	if n := len(statusEvents(relay, node, "1.1.0")); n != 0 {
+		t.Errorf("recovered action executed again: %d status events", n)
+	}
+	if !historyHas(dir, "upgrade:1.1.0") {
+		t.Error("history file not recovered from the backup")
+	}
+	if corrupt, _ := filepath.Glob(path + ".corrupt-*"); len(corrupt) != 1 {
+		t.Errorf("corrupt files kept = %v, want 1", corrupt)
+	}
+}
+
+func TestDaemonImportsOfflineSignals(t *testing.T) {
+	relay := newTestRelay(t, false)
+	devs := newTestDevs(5)
+	dir, node := newTestConfigDir(t, relay, devs, 3)
+	daemon := startDaemon(t, dir)
+	waitFor(t, 5*time.Second, "daemon subscription", func() bool { return relay.subscriptions() > 0 })
+
+	// Signals carried over from a connected machine, plus one from an unknown key
+	now := nostr.Now()
+	var events []nostr.Event
+	for _, d := range devs[:3] {
+		events = append(events, d.signal(t, "1.1.0", "hqz", now))
+	}
+	events = append(events, newTestDevs(1)[0].signal(t, "9.9.9", "hqz", now))
+	file := filepath.Join(t.TempDir(), "signals.jsonl")
+	if err := writeEventsFile(file, events); err != nil {
+		t.Fatal(err)
+	}
+	importSignalsCLI(dir, []string{file})
+
+	// A relay signal makes the daemon save signals.jsonl before it reads the import,
+	// which must not drop the queued signals
+	relay.publish(devs[4].signal(t, "2.0.0", "hqz", now))
+	store := newSignalStore(dir)
+	waitFor(t, 5*time.Second, "daemon save", func() bool {
+		stored, _ := store.load()
+		return len(stored) == 1
+	})
+
+	// The running daemon picks up the import at its next periodic check
+	daemon.clock.Advance(defaultCheckInterval)
+	waitFor(t, 5*time.Second, "status event", func() bool { return len(statusEvents(relay, node, "1.1.0")) == 1 })
+	if _, files, _ := store.loadInbox(); len(files) != 0 {
+		t.Errorf("inbox still holds %v after ingestion", files)
+	}
+
+	exported := filepath.Join(t.TempDir(), "export.jsonl")
+	exportSignalsCLI(dir, []string{"-o", exported})
+	got, err := readEventsFile(exported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 4 {
+		t.Fatalf("exported %d signals, want the 3 imported and 1 relay signal", len(got))
+	}
+}
+
+func TestDaemonArchivesAndReplaysEvents(t *testing.T) {
+	relay := newTestRelay(t, false)
+	devs := newTestDevs(3)
+	dir, node := newTestConfigDir(t, relay, devs, 3)
+
+	now := nostr.Now()
+	relay.publish(devs[0].signal(t, "1.1.0", "testnet", now))
+	relay.publish(devs[0].signal(t, "1.1.0", "hqz", now))
+	relay.publish(devs[1].signal(t, "1.1.0", "hqz", now))
+
+	daemon := startDaemon(t, dir)
+	waitFor(t, 5*time.Second, "daemon subscription", func() bool { return relay.subscriptions() > 0 })
+	time.Sleep(testSettle)
+	relay.publish(devs[2].signal(t, "1.1.0", "hqz", now))
+	waitFor(t, 5*time.Second, "status event", func() bool { return len(statusEvents(relay, node, "1.1.0")) == 1 })
+	daemon.stop()
+
+	records, err := readArchive(filepath.Join(dir, archiveFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 4 {
+		t.Fatalf("archived %d events, want 4", len(records))
+	}
+	for i, rec := range records {
+		if rec.Source != relay.URL {
+			t.Errorf("record %d source = %q, want %s", i, rec.Source, relay.URL)
+		}
+		if live := i == 3; rec.Live != live {
+			t.Errorf("record %d live = %v, want %v", i, rec.Live, live)
+		}
+	}
+	// go-nostr delivers stored events concurrently, so the backlog is archived in any order
+	rejected := 0
+	for _, rec := range records {
+		if rec.Decision != archiveRejected {
+			continue
+		}
+		rejected++
+		if rec.Reason != rejectWrongNetwork || getTagValue(&rec.Event, "network") != "testnet" {
+			t.Errorf("rejected %s (%s), want only the testnet signal rejected as %s",
+				rec.Event.ID, rec.Reason, rejectWrongNetwork)
+		}
+	}
+	if rejected != 1 {
+		t.Errorf("%d record(s) rejected, want the testnet signal", rejected)
+	}
+
+	cfg := loadConfig(dir)
+	follows := newFollowSet([]string{devs[0].pk, devs[1].pk, devs[2].pk})
+	history := loadHistory(dir, realClock{})
+	var out strings.Builder
+	executed := replayEvents(&out, records, &cfg, follows, history)
+	if len(executed) != 1 || executed[0] != "upgrade:1.1.0" {
+		t.Fatalf("replay executed %v, want [upgrade:1.1.0]", executed)
+	}
+	if !strings.Contains(out.String(), "history.yaml: executed") {
+		t.Errorf("replay does not show the recorded execution:\n%s", out.String())
+	}
+
+	// Replaying with a stricter quorum shows the upgrade would not have happened
+	cfg.Quorum = 4
+	if executed := replayEvents(io.Discard, records, &cfg, follows, history); len(executed) != 0 {
+		t.Errorf("replay with quorum 4 executed %v", executed)
+	}
+}
+
+func TestDaemonProposalStatus(t *testing.T) {
+	relay := newTestRelay(t, false)
+	devs := newTestDevs(4)
+	dir, node := newTestConfigDir(t, relay, devs, 3)
+	startDaemon(t, dir)
+	waitFor(t, 5*time.Second, "daemon subscription", func() bool { return relay.subscriptions() > 0 })
+
+	now := nostr.Now()
+	relay.publish(devs[3].signal(t, "1.0.9", "hqz", now))
+	for _, d := range devs[:3] {
+		relay.publish(d.signal(t, "1.1.0", "hqz", now))
+	}
+	waitFor(t, 5*time.Second, "status event", func() bool { return len(statusEvents(relay, node, "1.1.0")) == 1 })
+
+	// Another node that failed, referring to a different dev's signal
+	other := nostr.GeneratePrivateKey()
+	failed := nostr.Event{
+		Kind:      3333,
+		CreatedAt: now,
+		Tags: nostr.Tags{
+			{"a", "33321:" + devs[1].pk + ":hyperqube"},
+			{"version", "1.1.0"},
+			{"network", "hqz"},
+			{"action", "upgrade"},
+			{"status", "failure"},
+			{"node_id", "other-node"},
+		},
+	}
+	if err := failed.Sign(other); err != nil {
+		t.Fatal(err)
+	}
+	relay.publish(failed)
+
+	cfg := loadConfig(dir)
+	follows := newFollowSet([]string{devs[0].pk, devs[1].pk, devs[2].pk, devs[3].pk})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var out strings.Builder
+	tracker := watchProposal(ctx, &cfg, follows, nil, proposal{"upgrade", "1.1.0", "hqz", ""}, &out, true)
+
+	if len(tracker.voters) != 3 {
+		t.Errorf("tracked %d votes, want 3:\n%s", len(tracker.voters), out.String())
+	}
+	if r := tracker.nodes[node]; r.Status != "success" || r.NodeID != "test-node" {
+		t.Errorf("node report = %+v, want success from test-node", r)
+	}
+	if !strings.Contains(out.String(), "Nodes: 2 reported (1 success, 1 failure)") {
+		t.Errorf("summary does not count both nodes:\n%s", out.String())
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"path"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// kindRelayList is the NIP-65 relay list metadata kind
+const kindRelayList = 10002
+
+// defaultMaxDiscoveredRelays caps how many relays discovery adds to the configured ones
+const defaultMaxDiscoveredRelays = 5
+
+// relayList is the latest NIP-65 relay list of one follow
+type relayList struct {
+	Write     []string
+	CreatedAt nostr.Timestamp
+}
+
+// relayDiscovery adds the write relays from the follows' NIP-65 relay lists to the
+// relay manager, subject to the allow/deny policy and a cap, so signals are still
+// heard if developers move to new relays
+type relayDiscovery struct {
+	mu         sync.Mutex
+	follows    *followSet
+	relays     *relayManager
+	configured []string // Normalized configured relays, never counted as discovered
+	allow      []string // Host patterns a discovered relay must match (empty = any)
+	deny       []string // Host patterns a discovered relay must not match
+	max        int
+	lists      map[string]relayList // follow hex pubkey -> latest relay list
+}
+
+// newRelayDiscovery creates discovery from the config policy
+func newRelayDiscovery(config *Config, follows *followSet, relays *relayManager) *relayDiscovery {
+	d := &relayDiscovery{
+		follows: follows,
+		relays:  relays,
+		allow:   config.RelayAllow,
+		deny:    config.RelayDeny,
+		max:     config.MaxDiscoveredRelays,
+		lists:   make(map[string]relayList),
+	}
+	for _, r := range config.Relays {
+		d.configured = append(d.configured, nostr.NormalizeURL(r))
+	}
+	return d
+}
+
+// validHostPattern reports whether p is a usable relay_allow/relay_deny pattern
+func validHostPattern(p string) bool {
+	_, err := path.Match(p, "")
+	return p != "" && err == nil
+}
+
+// allowed reports whether a relay URL from a relay list may be used. Only wss is
+// accepted, except ws on loopback for testing.
+func (d *relayDiscovery) allowed(relayURL string) bool {
+	u, err := url.Parse(relayURL)
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+	host := u.Hostname()
+	switch u.Scheme {
+	case "wss":
+	case "ws":
+		if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			return false
+		}
+	default:
+		return false
+	}
+
+	for _, p := range d.deny {
+		if ok, _ := path.Match(p, host); ok {
+			return false
+		}
+	}
+	if len(d.allow) == 0 {
+		return true
+	}
+	for _, p := range d.allow {
+		if ok, _ := path.Match(p, host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// handle processes a kind=10002 event. Returns nil if the list was recorded, or a rejection.
+func (d *relayDiscovery) handle(ev *nostr.Event, config *Config, now time.Time) *rejection {
+	if ev.Kind != kindRelayList {
+		return &rejection{rejectWrongKind, fmt.Sprintf("kind %d", ev.Kind), false}
+	}
+	if r := validateFollowEvent(ev, d.follows.Snapshot(), now, config.MaxClockSkew); r != nil {
+		return r
+	}
+
+	// ["r", url] is read+write, ["r", url, "write"] write only, ["r", url, "read"] read only
+	var write []string
+	for _, tag := range ev.Tags {
+		if len(tag) < 2 || tag[0] != "r" || (len(tag) > 2 && tag[2] == "read") {
+			continue
+		}
+		if u := nostr.NormalizeURL(tag[1]); u != "" && !slices.Contains(write, u) {
+			write = append(write, u)
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if prev, ok := d.lists[ev.PubKey]; ok && prev.CreatedAt >= ev.CreatedAt {
+		return &rejection{rejectStaleSignal, "older relay list", false}
+	}
+	d.lists[ev.PubKey] = relayList{Write: write, CreatedAt: ev.CreatedAt}
+	log.Printf("[DEBUG] Relay list from %s with %d write relay(s)", shortKey(ev.PubKey), len(write))
+
+	d.applyLocked()
+	return nil
+}
+
+// refresh recomputes the discovered relays, e.g. after the follow set changed
+func (d *relayDiscovery) refresh() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.applyLocked()
+}
+
+// applyLocked picks the relays written to by the most current follows, within policy
+// and the cap, and hands them to the relay manager; d.mu must be held
+func (d *relayDiscovery) applyLocked() {
+	follows := d.follows.Snapshot()
+	users := make(map[string]int)
+	for author, l := range d.lists {
+		if !follows[author] {
+			continue
+		}
+		for _, u := range l.Write {
+			if !slices.Contains(d.configured, u) && d.allowed(u) {
+				users[u]++
+			}
+		}
+	}
+
+	candidates := make([]string, 0, len(users))
+	for u := range users {
+		candidates = append(candidates, u)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if users[candidates[i]] != users[candidates[j]] {
+			return users[candidates[i]] > users[candidates[j]]
+		}
+		return candidates[i] < candidates[j]
+	})
+	if len(candidates) > d.max {
+		log.Printf("[DEBUG] Relay discovery found %d relay(s), using the %d most used", len(candidates), d.max)
+		candidates = candidates[:d.max]
+	}
+
+	d.relays.setDiscovered(candidates)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// relayList returns a signed kind 10002 relay list of d with the given r tags
+func (d testDev) relayList(t *testing.T, createdAt nostr.Timestamp, tags ...nostr.Tag) nostr.Event {
+	t.Helper()
+	ev := nostr.Event{Kind: kindRelayList, CreatedAt: createdAt, Tags: tags}
+	if err := ev.Sign(d.sk); err != nil {
+		t.Fatalf("sign relay list: %v", err)
+	}
+	return ev
+}
+
+// discoveredRelays returns the sorted relays m manages that were added by discovery
+func discoveredRelays(m *relayManager) []string {
+	var urls []string
+	for _, s := range m.statuses() {
+		if s.Discovered {
+			urls = append(urls, s.URL)
+		}
+	}
+	slices.Sort(urls)
+	return urls
+}
+
+func TestRelayDiscoveryAllowed(t *testing.T) {
+	tests := []struct {
+		name  string
+		allow []string
+		deny  []string
+		url   string
+		want  bool
+	}{
+		{"wss", nil, nil, "wss://relay.example.com", true},
+		{"ws to a remote host", nil, nil, "ws://relay.example.com", false},
+		{"ws to localhost", nil, nil, "ws://localhost:7777", true},
+		{"ws to IPv4 loopback", nil, nil, "ws://127.0.0.1:7777", true},
+		{"ws to IPv6 loopback", nil, nil, "ws://[::1]:7777", true},
+		{"ws to a private address", nil, nil, "ws://192.168.1.10", false},
+		{"https", nil, nil, "https://relay.example.com", false},
+		{"no host", nil, nil, "wss://", false},
+		{"unparseable", nil, nil, "wss://%zz", false},
+		{"denied host", nil, []string{"*.evil.example"}, "wss://relay.evil.example", false},
+		{"host not denied", nil, []string{"*.evil.example"}, "wss://relay.example.com", true},
+		{"allowed host", []string{"*.example.com"}, nil, "wss://relay.example.com", true},
+		{"host not allowed", []string{"*.example.com"}, nil, "wss://relay.example.org", false},
+		{"deny overrides allow", []string{"*.example.com"}, []string{"bad.example.com"}, "wss://bad.example.com", false},
+		{"pattern matches the host, not the port", []string{"relay.example.com"}, nil, "wss://relay.example.com:4443", true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &relayDiscovery{allow: tc.allow, deny: tc.deny}
+			if got := d.allowed(tc.url); got != tc.want {
+				t.Errorf("allowed(%s) = %v, want %v", tc.url, got, tc.want)
+			}
+		})
+	}
+
+	for p, want := range map[string]bool{"*.example.com": true, "relay.example.com": true, "": false, "[a-": false} {
+		if got := validHostPattern(p); got != want {
+			t.Errorf("validHostPattern(%q) = %v, want %v", p, got, want)
+		}
+	}
+}
+
+func TestRelayDiscoveryPolicy(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	at := nostr.Timestamp(now.Unix())
+	devs := newTestDevs(4)
+	stranger := devs[3]
+	follows := newFollowSet([]string{devs[0].pk, devs[1].pk, devs[2].pk})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	config := Config{
+		Relays:              []string{"wss://configured.example.com"},
+		RelayDeny:           []string{"*.evil.example"},
+		MaxDiscoveredRelays: 2,
+		MaxClockSkew:        defaultMaxClockSkew,
+	}
+	relays := newRelayManager(ctx, config.Relays, time.Minute, defaultMaxClockSkew, newFakeClock(now))
+	d := newRelayDiscovery(&config, follows, relays)
+
+	handle := func(ev nostr.Event) *rejection { return d.handle(&ev, &config, now) }
+	for _, ev := range []nostr.Event{
+		devs[0].relayList(t, at, nostr.Tag{"r", "wss://one.example.com"}, nostr.Tag{"r", "wss://two.example.com"},
+			nostr.Tag{"r", "wss://three.example.com"}),
+		devs[1].relayList(t, at, nostr.Tag{"r", "wss://two.example.com", "write"}, nostr.Tag{"r", "wss://three.example.com"},
+			nostr.Tag{"r", "wss://read-only.example.com", "read"}, nostr.Tag{"r", "wss://configured.example.com"}),
+		devs[2].relayList(t, at, nostr.Tag{"r", "wss://three.example.com"}, nostr.Tag{"r", "ws://plain.example.com"},
+			nostr.Tag{"r", "wss://relay.evil.example"}, nostr.Tag{"r", "wss://relay.evil.example"}),
+	} {
+		if r := handle(ev); r != nil {
+			t.Fatalf("relay list rejected: %v", r)
+		}
+	}
+
+	// Capped at the two relays most follows write to. Configured, read-only, plain ws
+	// and denied relays are never discovered, however many follows list them.
+	want := []string{"wss://three.example.com", "wss://two.example.com"}
+	if got := discoveredRelays(relays); !slices.Equal(got, want) {
+		t.Errorf("discovered = %v, want %v", got, want)
+	}
+
+	// A non-follow's list is rejected and does not count towards the cap
+	spam := stranger.relayList(t, at, nostr.Tag{"r", "wss://one.example.com"}, nostr.Tag{"r", "wss://spam.example.com"})
+	if r := handle(spam); r == nil || r.Reason != rejectUnknownAuthor {
+		t.Errorf("non-follow relay list: rejection = %v, want %s", r, rejectUnknownAuthor)
+	}
+	if got := discoveredRelays(relays); !slices.Equal(got, want) {
+		t.Errorf("discovered after a non-follow's list = %v, want %v", got, want)
+	}
+
+	// An older list is ignored; a newer one replaces the follow's previous list
+	if r := handle(devs[0].relayList(t, at-1, nostr.Tag{"r", "wss://one.example.com"})); r == nil || r.Reason != rejectStaleSignal {
+		t.Errorf("older relay list: rejection = %v, want %s", r, rejectStaleSignal)
+	}
+	if r := handle(devs[1].relayList(t, at+1, nostr.Tag{"r", "wss://one.example.com"})); r != nil {
+		t.Fatalf("newer relay list rejected: %v", r)
+	}
+	want = []string{"wss://one.example.com", "wss://three.example.com"}
+	if got := discoveredRelays(relays); !slices.Equal(got, want) {
+		t.Errorf("discovered after a newer list = %v, want %v", got, want)
+	}
+
+	// Unfollowing drops the relays only that follow wrote to
+	follows.SetStatic([]string{devs[2].pk})
+	d.refresh()
+	want = []string{"wss://three.example.com"}
+	if got := discoveredRelays(relays); !slices.Equal(got, want) {
+		t.Errorf("discovered after unfollowing = %v, want %v", got, want)
+	}
+	if !relays.has("wss://configured.example.com") {
+		t.Error("configured relay removed by discovery")
+	}
+}
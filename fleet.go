@@ -0,0 +1,426 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// fleetRefresh is how long the HTTP page reuses fetched status events
+const fleetRefresh = time.Minute
+
+// fleetReport summarizes the kind=3333 status events of all nodes, per network
+type fleetReport struct {
+	GeneratedAt time.Time      `json:"generated_at"`
+	Events      int            `json:"events"`
+	Networks    []fleetNetwork `json:"networks"`
+}
+
+// fleetNetwork is the adoption state of one network
+type fleetNetwork struct {
+	Network  string         `json:"network"`
+	Nodes    int            `json:"nodes"` // Nodes that ever reported on this network
+	Versions []fleetVersion `json:"versions"`
+	Failures []fleetFailure `json:"failures,omitempty"`
+	Silent   []fleetNode    `json:"silent,omitempty"`
+}
+
+// fleetVersion is how far a version has propagated. Time to adopt is measured from the
+// first node that reported the version.
+type fleetVersion struct {
+	Version string        `json:"version"`
+	Reached int           `json:"reached"` // Nodes at this version or higher
+	Share   float64       `json:"share"`   // Reached / known nodes
+	P50     time.Duration `json:"p50_adopt_ns"`
+	P90     time.Duration `json:"p90_adopt_ns"`
+	Max     time.Duration `json:"max_adopt_ns"`
+}
+
+// fleetNode is a node and the highest version it reported successfully
+type fleetNode struct {
+	Pubkey     string    `json:"pubkey"`
+	NodeID     string    `json:"node_id"`
+	Version    string    `json:"version,omitempty"`
+	LastReport time.Time `json:"last_report"`
+}
+
+// fleetFailure is a status event reporting anything but success
+type fleetFailure struct {
+	Pubkey  string    `json:"pubkey"`
+	NodeID  string    `json:"node_id"`
+	Action  string    `json:"action"`
+	Version string    `json:"version"`
+	Status  string    `json:"status"`
+	Error   string    `json:"error,omitempty"`
+	At      time.Time `json:"at"`
+}
+
+// fleetNodeState is what buildFleetReport collects per node and network
+type fleetNodeState struct {
+	nodeID  string
+	current *semver.Version
+	last    time.Time
+}
+
+// buildFleetReport aggregates validly signed status events by network, version and node.
+// Only events referring to a HyperSignal of one of follows count: anyone can publish kind
+// 3333, and relays are not trusted to have filtered them. A node that has not reported for
+// silentAfter and is not on the newest version is silent.
+func buildFleetReport(events []nostr.Event, follows []string, now time.Time, silentAfter time.Duration) fleetReport {
+	report := fleetReport{GeneratedAt: now.UTC()}
+
+	addresses := make(map[string]bool, len(follows))
+	for _, a := range signalAddresses(follows) {
+		addresses[a] = true
+	}
+
+	type versionKey struct{ network, version string }
+	nodes := make(map[string]map[string]*fleetNodeState) // network -> node pubkey -> state
+	adopted := make(map[versionKey]map[string]time.Time) // first success per node
+	versions := make(map[string]map[string]*semver.Version)
+	failures := make(map[string][]fleetFailure)
+
+	seen := make(map[string]bool)
+	for i := range events {
+		ev := &events[i]
+		if ev.Kind != 3333 || seen[ev.ID] || !refersToSignal(ev, addresses) {
+			continue
+		}
+		if ok, _ := ev.CheckSignature(); !ok {
+			continue
+		}
+		seen[ev.ID] = true
+		report.Events++
+
+		network := getTagValue(ev, "network")
+		v, err := semver.NewVersion(getTagValue(ev, "version"))
+		if network == "" || err != nil {
+			continue
+		}
+		at := ev.CreatedAt.Time()
+		if ts, err := strconv.ParseInt(getTagValue(ev, "action_at"), 10, 64); err == nil {
+			at = time.Unix(ts, 0)
+		}
+
+		if nodes[network] == nil {
+			nodes[network] = make(map[string]*fleetNodeState)
+			versions[network] = make(map[string]*semver.Version)
+		}
+		node := nodes[network][ev.PubKey]
+		if node == nil {
+			node = &fleetNodeState{}
+			nodes[network][ev.PubKey] = node
+		}
+		if ev.CreatedAt.Time().After(node.last) {
+			node.last = ev.CreatedAt.Time()
+			node.nodeID = getTagValue(ev, "node_id")
+		}
+
+		status := getTagValue(ev, "status")
+		if status != "success" {
+			failures[network] = append(failures[network], fleetFailure{
+				Pubkey:  ev.PubKey,
+				NodeID:  getTagValue(ev, "node_id"),
+				Action:  getTagValue(ev, "action"),
+				Version: v.Original(),
+				Status:  status,
+				Error:   getTagValue(ev, "error"),
+				At:      at.UTC(),
+			})
+			continue
+		}
+
+		versions[network][v.Original()] = v
+		if node.current == nil || v.GreaterThan(node.current) {
+			node.current = v
+		}
+		key := versionKey{network, v.Original()}
+		if adopted[key] == nil {
+			adopted[key] = make(map[string]time.Time)
+		}
+		if prev, ok := adopted[key][ev.PubKey]; !ok || at.Before(prev) {
+			adopted[key][ev.PubKey] = at
+		}
+	}
+
+	for network, states := range nodes {
+		n := fleetNetwork{Network: network, Nodes: len(states), Failures: failures[network]}
+		sort.Slice(n.Failures, func(i, j int) bool { return n.Failures[i].At.Before(n.Failures[j].At) })
+
+		// Highest version first
+		sorted := make([]*semver.Version, 0, len(versions[network]))
+		for _, v := range versions[network] {
+			sorted = append(sorted, v)
+		}
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i].GreaterThan(sorted[j]) })
+
+		for _, v := range sorted {
+			fv := fleetVersion{Version: v.Original()}
+			for _, s := range states {
+				if s.current != nil && !s.current.LessThan(v) {
+					fv.Reached++
+				}
+			}
+			fv.Share = float64(fv.Reached) / float64(n.Nodes)
+			fv.P50, fv.P90, fv.Max = adoptionTimes(adopted[versionKey{network, v.Original()}])
+			n.Versions = append(n.Versions, fv)
+		}
+
+		for pk, s := range states {
+			if now.Sub(s.last) < silentAfter || (len(sorted) > 0 && s.current != nil && s.current.Equal(sorted[0])) {
+				continue
+			}
+			node := fleetNode{Pubkey: pk, NodeID: s.nodeID, LastReport: s.last.UTC()}
+			if s.current != nil {
+				node.Version = s.current.Original()
+			}
+			n.Silent = append(n.Silent, node)
+		}
+		sort.Slice(n.Silent, func(i, j int) bool { return n.Silent[i].LastReport.Before(n.Silent[j].LastReport) })
+
+		report.Networks = append(report.Networks, n)
+	}
+	sort.Slice(report.Networks, func(i, j int) bool { return report.Networks[i].Network < report.Networks[j].Network })
+	return report
+}
+
+// refersToSignal reports whether a status event has an "a" tag among addresses
+func refersToSignal(ev *nostr.Event, addresses map[string]bool) bool {
+	for _, tag := range ev.Tags {
+		if len(tag) >= 2 && tag[0] == "a" && addresses[tag[1]] {
+			return true
+		}
+	}
+	return false
+}
+
+// adoptionTimes returns the median, 90th percentile and maximum time after the first
+// node that the nodes adopted a version
+func adoptionTimes(at map[string]time.Time) (p50, p90, longest time.Duration) {
+	if len(at) == 0 {
+		return 0, 0, 0
+	}
+	times := make([]time.Time, 0, len(at))
+	for _, t := range at {
+		times = append(times, t)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	delays := make([]time.Duration, len(times))
+	for i, t := range times {
+		delays[i] = t.Sub(times[0])
+	}
+	// Nearest-rank percentiles
+	rank := func(p int) time.Duration { return delays[(len(delays)*p+99)/100-1] }
+	return rank(50), rank(90), delays[len(delays)-1]
+}
+
+// fetchStatusEvents fetches the kind=3333 status events referring to a HyperSignal of one
+// of follows from the relays, optionally only those created since a time
+func fetchStatusEvents(ctx context.Context, relays, follows []string, since time.Time) []nostr.Event {
+	ctx, cancel := context.WithTimeout(ctx, signalFetchTimeout)
+	defer cancel()
+
+	filter := nostr.Filter{
+		Kinds: []int{3333},
+		Tags:  nostr.TagMap{"a": signalAddresses(follows)},
+	}
+	if !since.IsZero() {
+		ts := nostr.Timestamp(since.Unix())
+		filter.Since = &ts
+	}
+
+	pool := nostr.NewSimplePool(ctx)
+	var events []nostr.Event
+	for re := range pool.FetchMany(ctx, relays, filter) {
+		events = append(events, *re.Event)
+	}
+	return events
+}
+
+// writeFleetTable prints the report as aligned tables per network
+func writeFleetTable(out io.Writer, report fleetReport) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	if len(report.Networks) == 0 {
+		fmt.Fprintln(w, "No status events found")
+	}
+	for _, n := range report.Networks {
+		fmt.Fprintf(w, "Network %s: %d node(s)\n", n.Network, n.Nodes)
+		fmt.Fprintln(w, "VERSION\tREACHED\tSHARE\tP50 ADOPT\tP90 ADOPT\tMAX ADOPT")
+		for _, v := range n.Versions {
+			fmt.Fprintf(w, "%s\t%d/%d\t%.0f%%\t%s\t%s\t%s\n", v.Version, v.Reached, n.Nodes, v.Share*100, v.P50, v.P90, v.Max)
+		}
+		if len(n.Failures) > 0 {
+			fmt.Fprintln(w, "\nFAILED AT\tNODE\tACTION\tVERSION\tSTATUS\tERROR")
+			for _, f := range n.Failures {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", f.At.Format(time.RFC3339), fleetNodeName(f.NodeID, f.Pubkey),
+					f.Action, f.Version, f.Status, f.Error)
+			}
+		}
+		if len(n.Silent) > 0 {
+			fmt.Fprintln(w, "\nSILENT SINCE\tNODE\tVERSION")
+			for _, s := range n.Silent {
+				fmt.Fprintf(w, "%s\t%s\t%s\n", s.LastReport.Format(time.RFC3339), fleetNodeName(s.NodeID, s.Pubkey), s.Version)
+			}
+		}
+		fmt.Fprintln(w)
+	}
+	return w.Flush()
+}
+
+// fleetNodeName shows a node by its node_id and abbreviated pubkey
+func fleetNodeName(nodeID, pubkey string) string {
+	if nodeID == "" {
+		return shortKey(pubkey)
+	}
+	return nodeID + " (" + shortKey(pubkey) + ")"
+}
+
+// fleetPage renders the report for the HTTP dashboard
+var fleetPage = template.Must(template.New("fleet").Funcs(template.FuncMap{
+	"percent": func(f float64) string { return fmt.Sprintf("%.0f%%", f*100) },
+	"when":    func(t time.Time) string { return t.Format(time.RFC3339) },
+	"node":    fleetNodeName,
+}).Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta http-equiv="refresh" content="60">
+<title>qube-manager fleet</title>
+<style>body{font-family:sans-serif}table{border-collapse:collapse;margin-bottom:1em}td,th{border:1px solid #ccc;padding:2px 8px;text-align:left}</style>
+</head><body>
+<h1>Fleet</h1>
+<p>{{.Events}} status event(s), generated {{when .GeneratedAt}}</p>
+{{range .Networks}}
+<h2>Network {{.Network}}: {{.Nodes}} node(s)</h2>
+<table><tr><th>Version</th><th>Reached</th><th>Share</th><th>P50 adopt</th><th>P90 adopt</th><th>Max adopt</th></tr>
+{{$nodes := .Nodes}}{{range .Versions}}<tr><td>{{.Version}}</td><td>{{.Reached}}/{{$nodes}}</td><td>{{percent .Share}}</td><td>{{.P50}}</td><td>{{.P90}}</td><td>{{.Max}}</td></tr>
+{{end}}</table>
+{{if .Failures}}<h3>Failures</h3>
+<table><tr><th>At</th><th>Node</th><th>Action</th><th>Version</th><th>Status</th><th>Error</th></tr>
+{{range .Failures}}<tr><td>{{when .At}}</td><td>{{node .NodeID .Pubkey}}</td><td>{{.Action}}</td><td>{{.Version}}</td><td>{{.Status}}</td><td>{{.Error}}</td></tr>
+{{end}}</table>{{end}}
+{{if .Silent}}<h3>Silent</h3>
+<table><tr><th>Last report</th><th>Node</th><th>Version</th></tr>
+{{range .Silent}}<tr><td>{{when .LastReport}}</td><td>{{node .NodeID .Pubkey}}</td><td>{{.Version}}</td></tr>
+{{end}}</table>{{end}}
+{{else}}<p>No status events found</p>{{end}}
+</body></html>
+`))
+
+// fleetCLI shows which share of the known nodes reached each version, how long adoption
+// took, failed actions and silent nodes, from the kind=3333 status events on the relays
+func fleetCLI(configDir string, args []string) {
+	var (
+		network  string
+		since    time.Duration
+		silent   time.Duration
+		format   string
+		httpAddr string
+	)
+
+	flagSet := flag.NewFlagSet("fleet", flag.ExitOnError)
+	flagSet.StringVar(&network, "network", "", "Only show this network (default: all)")
+	flagSet.DurationVar(&since, "since", 0, "Only use status events from this long ago (e.g. 720h; default: all)")
+	flagSet.DurationVar(&silent, "silent", 7*24*time.Hour, "Report nodes behind the newest version that have not reported for this long")
+	flagSet.StringVar(&format, "format", "table", "Output format: 'table' or 'json'")
+	flagSet.StringVar(&httpAddr, "http", "", "Serve the report as a web page on this address (e.g. :8080) instead of printing it")
+	flagSet.Parse(args)
+
+	cfg := loadConfig(configDir)
+	if len(cfg.Relays) == 0 {
+		log.Fatal("[ERROR] No relays configured")
+	}
+
+	// Nodes act on the follows' signals, so only status events referring to those count
+	follows := offlineFollows(context.Background(), configDir, &cfg)
+	if len(follows.Pubkeys()) == 0 {
+		log.Fatal("[ERROR] No follows configured")
+	}
+
+	build := func(ctx context.Context) fleetReport {
+		var from time.Time
+		if since > 0 {
+			from = time.Now().Add(-since)
+		}
+		events := fetchStatusEvents(ctx, cfg.Relays, follows.Pubkeys(), from)
+
+		// Relays only index single-letter tags, so the network is filtered here
+		if network != "" {
+			events = slices.DeleteFunc(events, func(ev nostr.Event) bool { return getTagValue(&ev, "network") != network })
+		}
+		return buildFleetReport(events, follows.Pubkeys(), time.Now(), silent)
+	}
+
+	if httpAddr != "" {
+		serveFleet(httpAddr, build)
+		return
+	}
+
+	report := build(context.Background())
+	var err error
+	switch format {
+	case "table":
+		err = writeFleetTable(os.Stdout, report)
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+	default:
+		log.Fatalf("[ERROR] Invalid format '%s'. Must be 'table' or 'json'.", format)
+	}
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to write fleet report: %v", err)
+	}
+}
+
+// serveFleet serves the fleet report as HTML on / and JSON on /fleet.json, fetching the
+// status events again at most every fleetRefresh
+func serveFleet(addr string, build func(context.Context) fleetReport) {
+	var (
+		mu      sync.Mutex
+		report  fleetReport
+		fetched time.Time
+	)
+	current := func() fleetReport {
+		mu.Lock()
+		defer mu.Unlock()
+		if time.Since(fetched) >= fleetRefresh {
+			report = build(context.Background())
+			fetched = time.Now()
+		}
+		return report
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := fleetPage.Execute(w, current()); err != nil {
+			log.Printf("[WARN] Failed to render fleet page: %v", err)
+		}
+	})
+	mux.HandleFunc("/fleet.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(current())
+	})
+
+	log.Printf("[INFO] Serving fleet report on http://%s/", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Fatalf("[ERROR] Fleet web server failed: %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestBuildFleetReport(t *testing.T) {
+	start := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	nodes := map[string]string{}
+	for _, name := range []string{"a", "b", "c", "d"} {
+		nodes[name] = nostr.GeneratePrivateKey()
+	}
+
+	devs := newTestDevs(2)
+	follow, stranger := devs[0], devs[1]
+
+	var events []nostr.Event
+	reportOn := func(dev testDev, node, network, version, status string, at time.Time, extra ...nostr.Tag) nostr.Event {
+		ev := nostr.Event{
+			Kind:      3333,
+			CreatedAt: nostr.Timestamp(at.Unix()),
+			Tags: append(nostr.Tags{
+				{"a", fmt.Sprintf("33321:%s:hyperqube", dev.pk)},
+				{"version", version},
+				{"network", network},
+				{"action", "upgrade"},
+				{"status", status},
+				{"node_id", "node-" + node},
+				{"action_at", fmt.Sprint(at.Unix())},
+			}, extra...),
+		}
+		if err := ev.Sign(nodes[node]); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, ev)
+		return ev
+	}
+	report := func(node, network, version, status string, at time.Time, extra ...nostr.Tag) nostr.Event {
+		return reportOn(follow, node, network, version, status, at, extra...)
+	}
+
+	for _, n := range []string{"a", "b", "c"} {
+		report(n, "hqz", "1.0.0", "success", start)
+	}
+	report("a", "hqz", "1.1.0", "success", start.Add(24*time.Hour))
+	report("b", "hqz", "1.1.0", "success", start.Add(26*time.Hour))
+	report("d", "hqz", "1.1.0", "failure", start.Add(25*time.Hour), nostr.Tag{"error", "hash mismatch"})
+	report("a", "testnet", "2.0.0", "success", start)
+	forged := report("b", "hqz", "9.9.9", "success", start)
+	forged.Sig = strings.Repeat("0", 128)
+	events[len(events)-1] = forged
+
+	// A status event acting on a non-follow's signal is ignored: its node, version and
+	// failure would otherwise be counted
+	nodes["e"] = nostr.GeneratePrivateKey()
+	reportOn(stranger, "e", "hqz", "8.0.0", "failure", start)
+	reportOn(stranger, "a", "hqz", "8.0.0", "success", start.Add(48*time.Hour))
+
+	r := buildFleetReport(events, []string{follow.pk}, start.Add(30*24*time.Hour), 7*24*time.Hour)
+	if len(r.Networks) != 2 || r.Networks[0].Network != "hqz" {
+		t.Fatalf("networks = %+v, want hqz and testnet", r.Networks)
+	}
+	hqz := r.Networks[0]
+	if hqz.Nodes != 4 {
+		t.Errorf("hqz has %d nodes, want 4 (node-e only acted on a non-follow's signal)", hqz.Nodes)
+	}
+	if len(hqz.Versions) != 2 {
+		t.Fatalf("hqz versions = %+v, want 1.1.0 and 1.0.0 (forged 9.9.9 and non-follow 8.0.0 ignored)", hqz.Versions)
+	}
+	v := hqz.Versions[0]
+	if v.Version != "1.1.0" || v.Reached != 2 || v.Share != 0.5 {
+		t.Errorf("1.1.0 = %+v, want reached 2 of 4", v)
+	}
+	if v.P50 != 0 || v.P90 != 2*time.Hour || v.Max != 2*time.Hour {
+		t.Errorf("1.1.0 adoption p50=%s p90=%s max=%s, want 0s 2h 2h", v.P50, v.P90, v.Max)
+	}
+	if v := hqz.Versions[1]; v.Version != "1.0.0" || v.Reached != 3 {
+		t.Errorf("1.0.0 = %+v, want reached 3", v)
+	}
+	if len(hqz.Failures) != 1 || hqz.Failures[0].Error != "hash mismatch" || hqz.Failures[0].NodeID != "node-d" {
+		t.Errorf("failures = %+v, want node-d with its error", hqz.Failures)
+	}
+	if len(hqz.Silent) != 2 || hqz.Silent[0].NodeID != "node-c" || hqz.Silent[1].NodeID != "node-d" {
+		t.Errorf("silent = %+v, want node-c and node-d", hqz.Silent)
+	}
+
+	var out strings.Builder
+	if err := writeFleetTable(&out, r); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Network hqz: 4 node(s)") {
+		t.Errorf("table misses the hqz header:\n%s", out.String())
+	}
+	if err := fleetPage.Execute(&out, r); err != nil {
+		t.Errorf("render page: %v", err)
+	}
+}
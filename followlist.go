@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// kindFollowList is the NIP-51 follow set kind used for the signed trusted developer list
+const kindFollowList = 30000
+
+// followListDTag identifies the qube-manager follow list among a key's NIP-51 sets
+const followListDTag = "hyperqube-follows"
+
+// Rejection reason specific to follow list events
+const rejectInvalidFollowList = "invalid_follow_list"
+
+// followList is the latest list published by one author
+type followList struct {
+	Pubkeys   []string
+	CreatedAt nostr.Timestamp
+}
+
+// followListTracker applies signed follow lists to the follow set. With an anchor key,
+// the anchor's latest list is authoritative. Otherwise a pubkey is trusted once it is
+// listed by quorum current follows. Static config follows are always kept.
+type followListTracker struct {
+	mu      sync.Mutex
+	follows *followSet
+	anchor  string                // Hex pubkey whose list is authoritative (optional)
+	quorum  int                   // Lists from current follows needed to trust a pubkey
+	lists   map[string]followList // author hex pubkey -> latest list
+}
+
+// newFollowListTracker creates a tracker; it is disabled when neither anchor nor quorum is set
+func newFollowListTracker(follows *followSet, anchor string, quorum int) *followListTracker {
+	return &followListTracker{
+		follows: follows,
+		anchor:  anchor,
+		quorum:  quorum,
+		lists:   make(map[string]followList),
+	}
+}
+
+// enabled reports whether follow lists are used at all
+func (t *followListTracker) enabled() bool {
+	return t.anchor != "" || t.quorum > 0
+}
+
+// authors returns the pubkeys whose follow lists are subscribed to
+func (t *followListTracker) authors() []string {
+	if t.anchor != "" {
+		return []string{t.anchor}
+	}
+	return t.follows.Pubkeys()
+}
+
+// handle processes a kind=30000 event. Returns nil if the list was recorded, or a rejection.
+func (t *followListTracker) handle(ev *nostr.Event, config *Config, now time.Time) *rejection {
+	if ev.Kind != kindFollowList {
+		return &rejection{rejectWrongKind, fmt.Sprintf("kind %d", ev.Kind), false}
+	}
+
+	allowed := t.follows.Snapshot()
+	if t.anchor != "" {
+		allowed = map[string]bool{t.anchor: true}
+	}
+	if r := validateFollowEvent(ev, allowed, now, config.MaxClockSkew); r != nil {
+		return r
+	}
+
+	if d := getTagValue(ev, "d"); d != followListDTag {
+		return &rejection{rejectWrongDTag, fmt.Sprintf("d tag %q", d), false}
+	}
+
+	var pubkeys []string
+	for _, tag := range ev.Tags {
+		if len(tag) < 2 || tag[0] != "p" {
+			continue
+		}
+		if !nostr.IsValidPublicKey(tag[1]) {
+			return &rejection{rejectInvalidFollowList, fmt.Sprintf("invalid p tag %q", tag[1]), true}
+		}
+		pubkeys = append(pubkeys, tag[1])
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if prev, ok := t.lists[ev.PubKey]; ok && prev.CreatedAt >= ev.CreatedAt {
+		return &rejection{rejectStaleSignal, "older follow list", false}
+	}
+	t.lists[ev.PubKey] = followList{Pubkeys: pubkeys, CreatedAt: ev.CreatedAt}
+	log.Printf("[INFO] Follow list from %s with %d key(s)", shortKey(ev.PubKey), len(pubkeys))
+
+	t.follows.SetListed(t.listedLocked())
+	return nil
+}
+
+// listedLocked computes the pubkeys trusted via follow lists; t.mu must be held
+func (t *followListTracker) listedLocked() []string {
+	if t.anchor != "" {
+		return t.lists[t.anchor].Pubkeys
+	}
+
+	// Grow from the static floor until stable, so keys trusted via the list can in turn
+	// vote on the list, but nothing is trusted without quorum lists from trusted authors
+	var listed []string
+	for i := 0; i <= len(t.lists); i++ {
+		members := t.follows.Effective(listed)
+		counts := make(map[string]int)
+		for author, l := range t.lists {
+			if !members[author] {
+				continue
+			}
+			for _, pk := range l.Pubkeys {
+				counts[pk]++
+			}
+		}
+
+		next := make([]string, 0, len(counts))
+		for pk, n := range counts {
+			if n >= t.quorum {
+				next = append(next, pk)
+			}
+		}
+		sort.Strings(next)
+		if len(next) == len(listed) {
+			return next
+		}
+		listed = next
+	}
+	return listed
+}
+
+// followListCLI publishes this key's signed follow list
+func followListCLI(configDir, passphraseFile string, args []string) {
+	var dryRun bool
+
+	flagSet := flag.NewFlagSet("follow-list", flag.ExitOnError)
+	flagSet.BoolVar(&dryRun, "dry-run", false, "Print event instead of sending")
+	flagSet.Parse(args)
+
+	if flagSet.NArg() == 0 {
+		log.Fatal("[ERROR] Usage: qube-manager follow-list [-dry-run] <npub> [npub...]")
+	}
+	pubkeys, err := decodeNpubs(flagSet.Args())
+	if err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+
+	tags := nostr.Tags{{"d", followListDTag}}
+	for _, pk := range pubkeys {
+		tags = append(tags, nostr.Tag{"p", pk})
+	}
+	content := fmt.Sprintf("[qube-manager] Trusted HyperQube developer list (%d keys).", len(pubkeys))
+
+	if dryRun {
+		log.Printf("[DRY RUN] Prepared follow list event (kind %d):", kindFollowList)
+		for _, pk := range pubkeys {
+			npub, _ := nip19.EncodePublicKey(pk)
+			fmt.Println(npub)
+		}
+		return
+	}
+
+	_, privKey := loadSecretKey(configDir, passphraseFile, true)
+	cfg := loadConfig(configDir)
+	if len(cfg.Relays) == 0 {
+		log.Println("[WARN] No relays configured; follow list will not be sent.")
+		return
+	}
+
+	signer, err := newSigner(context.Background(), privKey, cfg.Bunker)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to set up signer: %v", err)
+	}
+
+	ev := nostr.Event{
+		CreatedAt: nostr.Now(),
+		Kind:      kindFollowList,
+		Tags:      tags,
+		Content:   content,
+	}
+	if err := signEvent(context.Background(), signer, &ev); err != nil {
+		log.Fatalf("[ERROR] Failed to sign follow list: %v", err)
+	}
+
+	log.Printf("[INFO] Created follow list event (kind %d) with %d key(s)", kindFollowList, len(pubkeys))
+	publishEvent(cfg.Relays, signer, ev)
+}
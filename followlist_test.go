@@ -0,0 +1,153 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// followListEvent returns d's signed follow list of pubkeys
+func (d testDev) followListEvent(t *testing.T, createdAt nostr.Timestamp, pubkeys ...string) *nostr.Event {
+	t.Helper()
+	ev := nostr.Event{Kind: kindFollowList, CreatedAt: createdAt, Tags: nostr.Tags{{"d", followListDTag}}}
+	for _, pk := range pubkeys {
+		ev.Tags = append(ev.Tags, nostr.Tag{"p", pk})
+	}
+	if err := ev.Sign(d.sk); err != nil {
+		t.Fatalf("sign follow list: %v", err)
+	}
+	return &ev
+}
+
+func TestFollowListAnchor(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	config := Config{Quorum: 2, MaxClockSkew: defaultMaxClockSkew}
+	devs := newTestDevs(5)
+	anchor, static := devs[0], devs[1]
+	follows := newFollowSet([]string{static.pk})
+	tracker := newFollowListTracker(follows, anchor.pk, 0)
+
+	// Only the anchor's list counts, even from a trusted follow
+	if r := tracker.handle(static.followListEvent(t, nostr.Timestamp(now.Unix()), devs[2].pk), &config, now); r == nil {
+		t.Fatal("follow list from a non-anchor key accepted")
+	}
+
+	ts := nostr.Timestamp(now.Unix())
+	if r := tracker.handle(anchor.followListEvent(t, ts, devs[2].pk, devs[3].pk), &config, now); r != nil {
+		t.Fatalf("anchor list rejected: %v", r)
+	}
+	if got := follows.Pubkeys(); len(got) != 3 || !follows.Contains(devs[2].pk) || !follows.Contains(devs[3].pk) {
+		t.Fatalf("follows = %v, want static plus the anchor's two keys", got)
+	}
+
+	// A newer list replaces the old one, but cannot remove the static floor
+	if r := tracker.handle(anchor.followListEvent(t, ts+1, devs[4].pk), &config, now); r != nil {
+		t.Fatalf("newer anchor list rejected: %v", r)
+	}
+	if follows.Contains(devs[2].pk) || !follows.Contains(devs[4].pk) || !follows.Contains(static.pk) {
+		t.Errorf("follows = %v after the anchor replaced its list", follows.Pubkeys())
+	}
+	if r := tracker.handle(anchor.followListEvent(t, ts, devs[2].pk), &config, now); r == nil {
+		t.Error("older anchor list accepted")
+	}
+}
+
+func TestFollowListAnchorCannotReachQuorumAlone(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	ts := nostr.Timestamp(now.Unix())
+	devs := newTestDevs(4)
+	anchor, static, puppet1, puppet2 := devs[0], devs[1], devs[2], devs[3]
+	config := Config{Quorum: 2, Network: "hqz", FollowListAnchor: anchor.npub, MaxClockSkew: defaultMaxClockSkew}
+	follows := newFollowSet([]string{static.pk})
+	tracker := newFollowListTracker(follows, anchor.pk, 0)
+	if r := tracker.handle(anchor.followListEvent(t, ts, puppet1.pk, puppet2.pk), &config, now); r != nil {
+		t.Fatalf("anchor list rejected: %v", r)
+	}
+
+	state := newSignalState()
+	vote := func(d testDev, version string) {
+		t.Helper()
+		ev := d.signal(t, version, "hqz", ts)
+		if r := state.ingest(&ev, &config, follows.Snapshot(), now); r != nil {
+			t.Fatalf("vote rejected: %v", r)
+		}
+	}
+	reached := func() bool {
+		state.mu.RLock()
+		defer state.mu.RUnlock()
+		return meetsQuorum(state.votes["upgrade:9.9.9"], &config, follows)
+	}
+
+	// Two keys only the anchor vouches for are not a quorum
+	vote(puppet1, "9.9.9")
+	vote(puppet2, "9.9.9")
+	if reached() {
+		t.Fatal("anchor-listed keys alone reached quorum")
+	}
+
+	// With a config follow among the voters they are
+	vote(static, "9.9.9")
+	if !reached() {
+		t.Fatal("no quorum with a config follow's vote")
+	}
+
+	// Without an anchor, quorum is only a count
+	config.FollowListAnchor = ""
+	follows = newFollowSet([]string{puppet1.pk, puppet2.pk})
+	if !meetsQuorum(map[string]bool{puppet1.pk: true, puppet2.pk: true}, &config, follows) {
+		t.Error("two follows do not meet a quorum of 2 without an anchor")
+	}
+}
+
+func TestFollowListQuorum(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	ts := nostr.Timestamp(now.Unix())
+	config := Config{Quorum: 2, MaxClockSkew: defaultMaxClockSkew}
+	devs := newTestDevs(6)
+	a, b, c, d, e, stranger := devs[0], devs[1], devs[2], devs[3], devs[4], devs[5]
+	follows := newFollowSet([]string{a.pk, b.pk})
+	tracker := newFollowListTracker(follows, "", 2)
+
+	handle := func(ev *nostr.Event) {
+		t.Helper()
+		if r := tracker.handle(ev, &config, now); r != nil {
+			t.Fatalf("list from %s rejected: %v", shortKey(ev.PubKey), r)
+		}
+	}
+
+	// One follow cannot add keys on its own, and untrusted authors are ignored
+	handle(a.followListEvent(t, ts, c.pk, d.pk))
+	if follows.Contains(c.pk) {
+		t.Fatal("key trusted on a single list")
+	}
+	if r := tracker.handle(stranger.followListEvent(t, ts, c.pk), &config, now); r == nil {
+		t.Fatal("list from an untrusted author accepted")
+	}
+
+	// A second list trusts c, whose own list then counts: with a's it trusts d, and
+	// d's list with c's trusts e
+	handle(b.followListEvent(t, ts, c.pk))
+	handle(c.followListEvent(t, ts, d.pk, e.pk))
+	handle(d.followListEvent(t, ts, e.pk))
+	want := []string{a.pk, b.pk, c.pk, d.pk, e.pk}
+	slices.Sort(want)
+	if got := follows.Pubkeys(); !slices.Equal(got, want) {
+		t.Fatalf("follows = %v, want %v", got, want)
+	}
+
+	// When b withdraws, c loses support and everything trusted through c goes with it
+	handle(b.followListEvent(t, ts+1))
+	static := []string{a.pk, b.pk}
+	slices.Sort(static)
+	if got := follows.Pubkeys(); !slices.Equal(got, static) {
+		t.Fatalf("follows = %v after withdrawal, want the static %v", got, static)
+	}
+
+	// Listing c again restores the whole chain in one update from the lists already held
+	handle(b.followListEvent(t, ts+2, c.pk))
+	if got := follows.Pubkeys(); !slices.Equal(got, want) {
+		t.Errorf("follows = %v after relisting, want %v", got, want)
+	}
+}
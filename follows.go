@@ -0,0 +1,169 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"sync"
+)
+
+// followSet is the effective set of trusted developer pubkeys (hex). It starts from
+// the static config follows, which are always trusted, and changes at runtime when a
+// signed follow list is updated or announced key rotations are applied. Subscribers
+// are notified of changes so they can update author filters.
+type followSet struct {
+	mu        sync.RWMutex
+	static    []string          // Hex pubkeys from config.yaml (the floor)
+	listed    []string          // Hex pubkeys from the signed follow list
+	rotations map[string]string // Applied rotations: old hex pubkey -> new hex pubkey
+	changed   chan struct{}     // Closed and replaced whenever the effective set changes
+}
+
+// newFollowSet creates a follow set from the static config follows
+func newFollowSet(static []string) *followSet {
+	return &followSet{
+		static:    static,
+		rotations: make(map[string]string),
+		changed:   make(chan struct{}),
+	}
+}
+
+// resolve follows applied rotations from pk to its current key
+func (f *followSet) resolve(pk string) string {
+	// Bounded by the number of rotations to guard against cycles
+	for i := 0; i <= len(f.rotations); i++ {
+		next, ok := f.rotations[pk]
+		if !ok {
+			return pk
+		}
+		pk = next
+	}
+	return pk
+}
+
+// effectiveLocked returns the resolved static follows plus listed; f.mu must be held
+func (f *followSet) effectiveLocked(listed []string) map[string]bool {
+	seen := make(map[string]bool, len(f.static)+len(listed))
+	for _, pk := range f.static {
+		seen[f.resolve(pk)] = true
+	}
+	for _, pk := range listed {
+		seen[f.resolve(pk)] = true
+	}
+	return seen
+}
+
+// Effective returns the set the follows would be if the signed list were listed,
+// without changing it
+func (f *followSet) Effective(listed []string) map[string]bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.effectiveLocked(listed)
+}
+
+// Pubkeys returns the sorted effective follow set
+func (f *followSet) Pubkeys() []string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	seen := f.effectiveLocked(f.listed)
+	pubkeys := make([]string, 0, len(seen))
+	for pk := range seen {
+		pubkeys = append(pubkeys, pk)
+	}
+	sort.Strings(pubkeys)
+	return pubkeys
+}
+
+// Snapshot returns the effective follow set as a lookup map
+func (f *followSet) Snapshot() map[string]bool {
+	pubkeys := f.Pubkeys()
+	set := make(map[string]bool, len(pubkeys))
+	for _, pk := range pubkeys {
+		set[pk] = true
+	}
+	return set
+}
+
+// Contains reports whether pk is currently trusted
+func (f *followSet) Contains(pk string) bool {
+	return f.Snapshot()[pk]
+}
+
+// Changed returns a channel that is closed the next time the effective set changes
+func (f *followSet) Changed() <-chan struct{} {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.changed
+}
+
+// notifyLocked wakes subscribers waiting on Changed; f.mu must be held for writing
+func (f *followSet) notifyLocked() {
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// SetStatic replaces the follows taken from config.yaml, e.g. when a NIP-05
+// identifier resolves to a different key
+func (f *followSet) SetStatic(static []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	before := f.effectiveLocked(f.listed)
+	f.static = static
+	f.diffLocked(before, "config follows")
+}
+
+// SetListed replaces the follows taken from the signed follow list. Static follows
+// remain trusted whether or not the list includes them.
+func (f *followSet) SetListed(listed []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	before := f.effectiveLocked(f.listed)
+	f.listed = listed
+	f.diffLocked(before, "signed follow list")
+}
+
+// diffLocked logs how the effective set changed from before and notifies subscribers
+// if it did; f.mu must be held for writing
+func (f *followSet) diffLocked(before map[string]bool, source string) {
+	after := f.effectiveLocked(f.listed)
+
+	changed := false
+	for pk := range after {
+		if !before[pk] {
+			log.Printf("[INFO] Follow %s added by %s", shortKey(pk), source)
+			changed = true
+		}
+	}
+	for pk := range before {
+		if !after[pk] {
+			log.Printf("[INFO] Follow %s removed by %s", shortKey(pk), source)
+			changed = true
+		}
+	}
+	if changed {
+		f.notifyLocked()
+	}
+}
+
+// ApplyRotation replaces oldPk with newPk in the effective set
+func (f *followSet) ApplyRotation(oldPk, newPk string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.rotations[oldPk] == newPk {
+		return
+	}
+	f.rotations[oldPk] = newPk
+	log.Printf("[INFO] Follow %s rotated to %s", shortKey(oldPk), shortKey(newPk))
+	f.notifyLocked()
+}
+
+// shortKey abbreviates a hex pubkey for log lines
+func shortKey(pk string) string {
+	if len(pk) > 8 {
+		return pk[:8] + "..."
+	}
+	return pk
+}
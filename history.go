@@ -1,73 +1,360 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/nbd-wtf/go-nostr/nip19"
 	"gopkg.in/yaml.v3"
 )
 
 // History tracks performed actions to ensure idempotency
 type History struct {
-	Entries map[string]string `yaml:"entries"` // key: message key, value: ISO8601 timestamp
-	path    string            // history file path (not in YAML)
+	Entries map[string]*HistoryEntry `yaml:"entries"` // key: message key, value: execution record
+	path    string                   // history file path (not in YAML)
+	mu      sync.Mutex               // Guards Entries between the quorum checker and the outbox
+
+	// The file as last read or written, to merge edits made by 'history forget'
+	synced     []byte
+	syncedKeys map[string]bool
+}
+
+// HistoryEntry records what was executed, who approved it and what happened
+type HistoryEntry struct {
+	ExecutedAt time.Time     `yaml:"executed_at"`        // When the action was executed (UTC)
+	Type       string        `yaml:"type"`               // "upgrade" or "reboot"
+	Version    string        `yaml:"version"`            // Semantic version acted on
+	Hash       string        `yaml:"hash,omitempty"`     // SHA256 hash of binary
+	Genesis    string        `yaml:"genesis,omitempty"`  // Genesis URL for reboot
+	Network    string        `yaml:"network,omitempty"`  // Network identifier
+	Voters     []string      `yaml:"voters,omitempty"`   // npubs whose votes reached quorum
+	Status     string        `yaml:"status"`             // "success" or "failure"
+	Error      string        `yaml:"error,omitempty"`    // Failure reason, if any
+	Duration   time.Duration `yaml:"duration,omitempty"` // Time taken to execute the action
+	Events     []string      `yaml:"events,omitempty"`   // IDs of kind=3333 events published
+	AckedBy    []string      `yaml:"acked_by,omitempty"` // Relays that returned OK for the kind=3333 event
+}
+
+// UnmarshalYAML accepts both the current mapping form and the legacy form,
+// where each entry was only an RFC3339 timestamp string
+func (e *HistoryEntry) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		t, err := time.Parse(time.RFC3339, node.Value)
+		if err != nil {
+			return fmt.Errorf("invalid legacy history timestamp %q: %w", node.Value, err)
+		}
+		// Legacy entries were only written after publishing a success status
+		*e = HistoryEntry{ExecutedAt: t, Status: "success"}
+		return nil
+	}
+
+	type plain HistoryEntry
+	return node.Decode((*plain)(e))
+}
+
+// migrateLegacyEntries fills in type, version and genesis for entries read from the
+// legacy format, which only stored a timestamp, by parsing the history key
+func (h *History) migrateLegacyEntries() int {
+	migrated := 0
+	for key, e := range h.Entries {
+		if e == nil {
+			h.Entries[key] = &HistoryEntry{Status: "success"}
+			e = h.Entries[key]
+		}
+		if e.Type != "" {
+			continue
+		}
+		// Keys are "upgrade:<version>" or "reboot:<version>:<genesis url>"
+		parts := strings.SplitN(key, ":", 3)
+		e.Type = parts[0]
+		if len(parts) > 1 {
+			e.Version = parts[1]
+		}
+		if len(parts) > 2 {
+			e.Genesis = parts[2]
+		}
+		migrated++
+	}
+	return migrated
+}
+
+// voterNpubs returns the sorted npubs of a vote set keyed by hex pubkey
+func voterNpubs(vset map[string]bool) []string {
+	npubs := make([]string, 0, len(vset))
+	for pk := range vset {
+		if npub, err := nip19.EncodePublicKey(pk); err == nil {
+			npubs = append(npubs, npub)
+		} else {
+			npubs = append(npubs, pk)
+		}
+	}
+	sort.Strings(npubs)
+	return npubs
 }
 
 // Has checks if an action key is already recorded in history
 func (h *History) Has(key string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
 	_, ok := h.Entries[key]
 	return ok
 }
 
-// Add records a new action with the current UTC timestamp
-func (h *History) Add(key string) {
-	h.Entries[key] = time.Now().UTC().Format(time.RFC3339)
-	log.Printf("[INFO] Added history entry for key: %s", key)
+// Add records an executed action. The caller sets ExecutedAt from its own clock.
+func (h *History) Add(key string, entry HistoryEntry) {
+	h.mu.Lock()
+	h.Entries[key] = &entry
+	h.mu.Unlock()
+	log.Printf("[INFO] Added history entry for key: %s (status: %s)", key, entry.Status)
 }
 
-// Save writes the history back to the YAML file
+// Save writes the history back to the YAML file atomically, and then the same content to
+// the backup for recovery
 func (h *History) Save() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.saveLocked()
+}
+
+// RecordAck notes that relay acknowledged the status event published for key and saves
+func (h *History) RecordAck(key, relay string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	e, ok := h.Entries[key]
+	if !ok || slices.Contains(e.AckedBy, relay) {
+		return nil
+	}
+	e.AckedBy = append(e.AckedBy, relay)
+	return h.saveLocked()
+}
+
+// Reload picks up changes another process, such as 'history forget', made to the file
+func (h *History) Reload() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.syncLocked()
+}
+
+// syncLocked merges changes made to the history file since it was last read or written:
+// entries removed on disk are dropped and entries added on disk are picked up, while
+// entries added in memory since are kept. h.mu must be held.
+func (h *History) syncLocked() {
+	if h.path == "" {
+		return // In memory only
+	}
+	data, err := os.ReadFile(h.path)
+	if err != nil || bytes.Equal(data, h.synced) {
+		return
+	}
+
+	var disk History
+	if err := yaml.Unmarshal(data, &disk); err != nil {
+		log.Printf("[WARN] History file %s changed on disk but does not parse; keeping the loaded history: %v", h.path, err)
+		return
+	}
+	for key := range h.syncedKeys {
+		if _, ok := disk.Entries[key]; !ok {
+			delete(h.Entries, key)
+			log.Printf("[INFO] History entry %s was removed from %s; the action may be executed again", key, h.path)
+		}
+	}
+	for key, e := range disk.Entries {
+		if _, ok := h.Entries[key]; !ok && e != nil {
+			h.Entries[key] = e
+		}
+	}
+	h.markSyncedLocked(data, disk.Entries)
+}
+
+// markSyncedLocked remembers data as the file content holding entries; h.mu must be held
+func (h *History) markSyncedLocked(data []byte, entries map[string]*HistoryEntry) {
+	h.synced = data
+	h.syncedKeys = make(map[string]bool, len(entries))
+	for key := range entries {
+		h.syncedKeys[key] = true
+	}
+}
+
+// saveLocked writes the history file; h.mu must be held
+func (h *History) saveLocked() error {
+	h.syncLocked()
+
 	data, err := yaml.Marshal(h)
 	if err != nil {
 		log.Printf("[ERROR] Failed to marshal history: %v", err)
 		return err
 	}
-	if err := os.WriteFile(h.path, data, 0644); err != nil {
+
+	if err := writeFileAtomic(h.path, data, 0644); err != nil {
 		log.Printf("[ERROR] Failed to write history file %s: %v", h.path, err)
 		return err
 	}
+
+	// The backup mirrors each successful write, so if the main file is later found corrupt,
+	// recovering from the backup loses nothing unless writing the backup failed as well
+	if err := writeFileAtomic(h.backupPath(), data, 0644); err != nil {
+		log.Printf("[WARN] Failed to write history backup %s: %v", h.backupPath(), err)
+	}
+	h.markSyncedLocked(data, h.Entries)
 	log.Printf("[INFO] History saved successfully to %s", h.path)
 	return nil
 }
 
-// loadHistory reads the YAML history file or creates a new empty history if missing
-func loadHistory(configDir string) *History {
+// backupPath returns the path of the backup, a copy of the history as last saved
+func (h *History) backupPath() string {
+	return h.path + ".bak"
+}
+
+// readHistoryFile parses a history file into h
+func readHistoryFile(path string, h *History) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal(data, h); err != nil {
+		return err
+	}
+	if h.Entries == nil {
+		h.Entries = make(map[string]*HistoryEntry)
+	}
+	h.markSyncedLocked(data, h.Entries)
+	return nil
+}
+
+// readHistory reads the history for display. Unlike loadHistory it never writes: nothing
+// is created, renamed or restored, and a corrupt file is only read around via the backup.
+func readHistory(configDir string) (*History, error) {
+	path := filepath.Join(configDir, "history.yaml")
+	h := &History{Entries: make(map[string]*HistoryEntry)}
+
+	err := readHistoryFile(path, h)
+	if os.IsNotExist(err) {
+		return h, nil
+	}
+	if err != nil {
+		log.Printf("[WARN] Failed to read history file %s, showing its backup: %v", path, err)
+		h = &History{Entries: make(map[string]*HistoryEntry)}
+		if err := readHistoryFile(path+".bak", h); err != nil {
+			return nil, fmt.Errorf("history file %s and its backup are unreadable: %w", path, err)
+		}
+	}
+	h.migrateLegacyEntries()
+	return h, nil
+}
+
+// newestEntry describes the most recently executed entry of h, for recovery messages
+func (h *History) newestEntry() string {
+	var key string
+	var newest *HistoryEntry
+	for k, e := range h.Entries {
+		if e != nil && (newest == nil || e.ExecutedAt.After(newest.ExecutedAt)) {
+			key, newest = k, e
+		}
+	}
+	if newest == nil {
+		return "no entries"
+	}
+	return fmt.Sprintf("%d entries, the newest %s executed at %s", len(h.Entries), key, newest.ExecutedAt.UTC().Format(time.RFC3339))
+}
+
+// openHistory reads the YAML history file or creates a new empty history if missing.
+// A corrupt or missing main file is recovered from the backup when one exists; since the
+// backup mirrors every save, this only loses saves whose backup write failed.
+func openHistory(configDir string, clk clock) (*History, error) {
 	path := filepath.Join(configDir, "history.yaml")
 	h := &History{
-		Entries: make(map[string]string),
+		Entries: make(map[string]*HistoryEntry),
 		path:    path,
 	}
 
-	if _, err := os.Stat(path); err == nil {
+	_, statErr := os.Stat(path)
+	if statErr != nil && !os.IsNotExist(statErr) {
+		return nil, fmt.Errorf("error checking history file %s: %w", path, statErr)
+	}
+
+	if statErr == nil {
 		log.Printf("[INFO] Loading existing history file from %s", path)
-		data, err := os.ReadFile(path)
-		if err != nil {
-			log.Fatalf("[ERROR] Failed to read history file %s: %v", path, err)
-		}
-		if err := yaml.Unmarshal(data, h); err != nil {
-			log.Fatalf("[ERROR] Failed to parse history file %s: %v", path, err)
+		err := readHistoryFile(path, h)
+		if err == nil {
+			if n := h.migrateLegacyEntries(); n > 0 {
+				log.Printf("[INFO] Migrated %d legacy history entries to the current format", n)
+			}
+			log.Printf("[INFO] History loaded: %d entries", len(h.Entries))
+			return h, nil
 		}
-		log.Printf("[INFO] History loaded: %d entries", len(h.Entries))
-	} else if os.IsNotExist(err) {
-		log.Printf("[WARN] History file does not exist, creating new one at %s", path)
-		if err := h.Save(); err != nil {
-			log.Fatalf("[ERROR] Failed to create history file %s: %v", path, err)
+		log.Printf("[ERROR] Failed to read history file %s: %v", path, err)
+	}
+
+	// Main file is missing or corrupt: recover from the backup if there is one
+	if _, err := os.Stat(h.backupPath()); err == nil {
+		recovered, err := recoverHistory(path, statErr == nil, clk)
+		if err != nil {
+			return nil, err
 		}
-	} else {
-		log.Fatalf("[ERROR] Error checking history file %s: %v", path, err)
+		log.Printf("[WARN] Recovered history from backup %s (%s); if a backup write failed since, "+
+			"an action missing from it may run again", h.backupPath(), recovered.newestEntry())
+		return recovered, nil
+	}
+
+	if statErr == nil {
+		return nil, fmt.Errorf("history file %s is corrupt and no backup exists; inspect it and restore it manually", path)
+	}
+
+	log.Printf("[WARN] History file does not exist, creating new one at %s", path)
+	if err := h.Save(); err != nil {
+		return nil, fmt.Errorf("failed to create history file %s: %w", path, err)
 	}
+	return h, nil
+}
 
+// loadHistory is openHistory for the daemon and commands that write history. Exits on failure.
+func loadHistory(configDir string, clk clock) *History {
+	h, err := openHistory(configDir, clk)
+	if err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
 	return h
 }
+
+// recoverHistory replaces the history file at path with its backup. A corrupt file is kept
+// as history.yaml.corrupt-<unix time> for inspection.
+func recoverHistory(path string, corrupt bool, clk clock) (*History, error) {
+	recovered := &History{path: path}
+	if err := readHistoryFile(recovered.backupPath(), recovered); err != nil {
+		return nil, fmt.Errorf("history file %s and its backup %s are both unreadable: %w", path, recovered.backupPath(), err)
+	}
+	recovered.migrateLegacyEntries()
+
+	if corrupt {
+		corruptPath := fmt.Sprintf("%s.corrupt-%d", path, clk.Now().Unix())
+		if err := os.Rename(path, corruptPath); err != nil {
+			return nil, fmt.Errorf("failed to move corrupt history file aside: %w", err)
+		}
+		log.Printf("[WARN] Moved corrupt history file to %s", corruptPath)
+	}
+	if err := recovered.Save(); err != nil {
+		return nil, fmt.Errorf("failed to restore history file %s: %w", path, err)
+	}
+	return recovered, nil
+}
+
+// restoreHistory replaces a corrupt or missing history file with its backup, for an
+// operator recovering by hand
+func restoreHistory(configDir string, clk clock) (*History, error) {
+	path := filepath.Join(configDir, "history.yaml")
+	current := &History{}
+	readErr := readHistoryFile(path, current)
+	if readErr == nil {
+		return nil, fmt.Errorf("history file %s is readable; nothing to restore", path)
+	}
+	return recoverHistory(path, !os.IsNotExist(readErr), clk)
+}
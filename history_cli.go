@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// historyRecord is a flattened history entry used for table/JSON/CSV output
+type historyRecord struct {
+	Key        string   `json:"key"`
+	ExecutedAt string   `json:"executed_at"`
+	Type       string   `json:"type"`
+	Version    string   `json:"version"`
+	Hash       string   `json:"hash,omitempty"`
+	Genesis    string   `json:"genesis,omitempty"`
+	Network    string   `json:"network,omitempty"`
+	Voters     []string `json:"voters,omitempty"`
+	Status     string   `json:"status"`
+	Error      string   `json:"error,omitempty"`
+	Duration   string   `json:"duration,omitempty"`
+	Events     []string `json:"events,omitempty"`
+	AckedBy    []string `json:"acked_by,omitempty"`
+}
+
+// historyFilter selects history entries for listing
+type historyFilter struct {
+	Type     string
+	Status   string
+	Versions *semver.Constraints
+	Since    time.Time
+	Until    time.Time
+}
+
+// matches reports whether an entry passes every configured filter
+func (f historyFilter) matches(e *HistoryEntry) bool {
+	if f.Type != "" && e.Type != f.Type {
+		return false
+	}
+	if f.Status != "" && e.Status != f.Status {
+		return false
+	}
+	if f.Versions != nil {
+		v, err := semver.NewVersion(e.Version)
+		if err != nil || !f.Versions.Check(v) {
+			return false
+		}
+	}
+	if !f.Since.IsZero() && e.ExecutedAt.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && e.ExecutedAt.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// parseHistoryDate accepts RFC3339 timestamps or plain YYYY-MM-DD dates (UTC). With
+// endOfDay a plain date means its last second, so an upper bound includes the whole day.
+func parseHistoryDate(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err == nil && endOfDay {
+		t = t.Add(24*time.Hour - time.Second)
+	}
+	return t, err
+}
+
+// historyCLI handles the 'history' subcommand: listing, filtering and exporting
+// executed actions, 'history forget <key>' to allow re-execution and 'history restore'
+// to recover from the backup
+func historyCLI(configDir string, args []string) {
+	if len(args) > 0 && args[0] == "forget" {
+		historyForget(configDir, args[1:])
+		return
+	}
+	if len(args) > 0 && args[0] == "restore" {
+		historyRestore(configDir, args[1:])
+		return
+	}
+
+	var (
+		actionType string
+		status     string
+		versions   string
+		since      string
+		until      string
+		format     string
+	)
+
+	flagSet := flag.NewFlagSet("history", flag.ExitOnError)
+	flagSet.StringVar(&actionType, "type", "", "Only show actions of this type ('upgrade' or 'reboot')")
+	flagSet.StringVar(&status, "status", "", "Only show actions with this status ('success' or 'failure')")
+	flagSet.StringVar(&versions, "version", "", "Only show versions matching a semver range (e.g. '>=1.2.0, <2.0.0')")
+	flagSet.StringVar(&since, "since", "", "Only show actions executed at or after this date (YYYY-MM-DD or RFC3339)")
+	flagSet.StringVar(&until, "until", "", "Only show actions executed at or before this date (YYYY-MM-DD or RFC3339)")
+	flagSet.StringVar(&format, "format", "table", "Output format: 'table', 'json' or 'csv'")
+	flagSet.Parse(args)
+
+	filter := historyFilter{Type: actionType, Status: status}
+	if versions != "" {
+		c, err := semver.NewConstraint(versions)
+		if err != nil {
+			log.Fatalf("[ERROR] Invalid version range '%s': %v", versions, err)
+		}
+		filter.Versions = c
+	}
+	if since != "" {
+		t, err := parseHistoryDate(since, false)
+		if err != nil {
+			log.Fatalf("[ERROR] Invalid --since date '%s': %v", since, err)
+		}
+		filter.Since = t
+	}
+	if until != "" {
+		t, err := parseHistoryDate(until, true)
+		if err != nil {
+			log.Fatalf("[ERROR] Invalid --until date '%s': %v", until, err)
+		}
+		filter.Until = t
+	}
+
+	history, err := readHistory(configDir)
+	if err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+
+	records := historyRecords(history, filter)
+	switch format {
+	case "table":
+		err = writeHistoryTable(os.Stdout, records)
+	case "json":
+		err = writeHistoryJSON(os.Stdout, records)
+	case "csv":
+		err = writeHistoryCSV(os.Stdout, records)
+	default:
+		log.Fatalf("[ERROR] Invalid format '%s'. Must be 'table', 'json' or 'csv'.", format)
+	}
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to write history: %v", err)
+	}
+}
+
+// historyRecords returns the entries of h that pass filter, oldest first
+func historyRecords(h *History, filter historyFilter) []historyRecord {
+	var records []historyRecord
+	for key, e := range h.Entries {
+		if !filter.matches(e) {
+			continue
+		}
+		r := historyRecord{
+			Key:        key,
+			ExecutedAt: e.ExecutedAt.UTC().Format(time.RFC3339),
+			Type:       e.Type,
+			Version:    e.Version,
+			Hash:       e.Hash,
+			Genesis:    e.Genesis,
+			Network:    e.Network,
+			Voters:     e.Voters,
+			Status:     e.Status,
+			Error:      e.Error,
+			Events:     e.Events,
+			AckedBy:    e.AckedBy,
+		}
+		if e.Duration > 0 {
+			r.Duration = e.Duration.String()
+		}
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ExecutedAt < records[j].ExecutedAt
+	})
+	return records
+}
+
+// writeHistoryTable prints records as an aligned table
+func writeHistoryTable(out io.Writer, records []historyRecord) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "EXECUTED AT\tTYPE\tVERSION\tSTATUS\tVOTERS\tACKS\tKEY")
+	for _, r := range records {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%s\n", r.ExecutedAt, r.Type, r.Version, r.Status, len(r.Voters), len(r.AckedBy), r.Key)
+	}
+	return w.Flush()
+}
+
+// writeHistoryJSON prints records as an indented JSON array, [] when there are none
+func writeHistoryJSON(out io.Writer, records []historyRecord) error {
+	if records == nil {
+		records = []historyRecord{}
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(records)
+}
+
+// writeHistoryCSV prints records as CSV with list fields joined by ';'
+func writeHistoryCSV(out io.Writer, records []historyRecord) error {
+	w := csv.NewWriter(out)
+	w.Write([]string{"key", "executed_at", "type", "version", "hash", "genesis", "network",
+		"voters", "status", "error", "duration", "events", "acked_by"})
+	for _, r := range records {
+		w.Write([]string{r.Key, r.ExecutedAt, r.Type, r.Version, r.Hash, r.Genesis, r.Network,
+			strings.Join(r.Voters, ";"), r.Status, r.Error, r.Duration, strings.Join(r.Events, ";"), strings.Join(r.AckedBy, ";")})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// historyForget removes an entry so the action can be executed again after a manual fix
+func historyForget(configDir string, args []string) {
+	if len(args) != 1 {
+		log.Fatal("[ERROR] Usage: qube-manager history forget <key>")
+	}
+	key := args[0]
+
+	history := loadHistory(configDir, realClock{})
+	if !history.Has(key) {
+		log.Fatalf("[ERROR] No history entry for key '%s'", key)
+	}
+
+	delete(history.Entries, key)
+	if err := history.Save(); err != nil {
+		log.Fatalf("[ERROR] Failed to save history: %v", err)
+	}
+
+	log.Printf("[INFO] Forgot history entry %s; a running daemon picks this up on its next quorum check", key)
+}
+
+// historyRestore recovers a corrupt or missing history file from its backup
+func historyRestore(configDir string, args []string) {
+	if len(args) != 0 {
+		log.Fatal("[ERROR] Usage: qube-manager history restore")
+	}
+
+	h, err := restoreHistory(configDir, realClock{})
+	if err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+	log.Printf("[WARN] Restored history from its backup (%s); the backup mirrors every save, so only "+
+		"actions whose backup write failed (see earlier warnings) are missing and run again once they reach quorum",
+		h.newestEntry())
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// testHistory returns a history of upgrades and a reboot executed on consecutive days of June 2025
+func testHistory() *History {
+	day := func(d int) time.Time { return time.Date(2025, 6, d, 12, 0, 0, 0, time.UTC) }
+	return &History{Entries: map[string]*HistoryEntry{
+		"upgrade:1.0.0": {ExecutedAt: day(1), Type: "upgrade", Version: "1.0.0", Status: "success"},
+		"upgrade:1.1.0": {ExecutedAt: day(2), Type: "upgrade", Version: "1.1.0", Status: "failure", Error: "exit status 1"},
+		"reboot:1.1.0":  {ExecutedAt: day(3), Type: "reboot", Version: "1.1.0", Status: "success"},
+		"upgrade:2.0.0": {ExecutedAt: day(4), Type: "upgrade", Version: "2.0.0", Status: "success",
+			Voters: []string{"npub1a", "npub1b"}, AckedBy: []string{"wss://a.example.com"}, Duration: 90 * time.Second},
+		"upgrade:next": {ExecutedAt: day(5), Type: "upgrade", Version: "next", Status: "success"},
+	}}
+}
+
+func historyKeys(records []historyRecord) []string {
+	var keys []string
+	for _, r := range records {
+		keys = append(keys, r.Key)
+	}
+	return keys
+}
+
+func mustConstraint(t *testing.T, r string) *semver.Constraints {
+	t.Helper()
+	c, err := semver.NewConstraint(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestParseHistoryDate(t *testing.T) {
+	tests := []struct {
+		value    string
+		endOfDay bool
+		want     time.Time
+	}{
+		{"2025-06-02", false, time.Date(2025, 6, 2, 0, 0, 0, 0, time.UTC)},
+		{"2025-06-02", true, time.Date(2025, 6, 2, 23, 59, 59, 0, time.UTC)},
+		{"2025-06-02T08:30:00Z", false, time.Date(2025, 6, 2, 8, 30, 0, 0, time.UTC)},
+		// An explicit time is used as given, even as an upper bound
+		{"2025-06-02T08:30:00Z", true, time.Date(2025, 6, 2, 8, 30, 0, 0, time.UTC)},
+		{"2025-06-02T10:30:00+02:00", true, time.Date(2025, 6, 2, 8, 30, 0, 0, time.UTC)},
+	}
+	for _, tc := range tests {
+		got, err := parseHistoryDate(tc.value, tc.endOfDay)
+		if err != nil || !got.Equal(tc.want) {
+			t.Errorf("parseHistoryDate(%q, %v) = %s, %v, want %s", tc.value, tc.endOfDay, got, err, tc.want)
+		}
+	}
+	for _, bad := range []string{"", "2025-13-01", "06/02/2025", "2025-06-02 08:30"} {
+		if _, err := parseHistoryDate(bad, false); err == nil {
+			t.Errorf("parseHistoryDate(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestHistoryFilter(t *testing.T) {
+	date := func(value string, endOfDay bool) time.Time {
+		d, err := parseHistoryDate(value, endOfDay)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	tests := []struct {
+		name   string
+		filter historyFilter
+		want   []string
+	}{
+		{"no filter", historyFilter{},
+			[]string{"upgrade:1.0.0", "upgrade:1.1.0", "reboot:1.1.0", "upgrade:2.0.0", "upgrade:next"}},
+		{"type", historyFilter{Type: "reboot"}, []string{"reboot:1.1.0"}},
+		{"status", historyFilter{Status: "failure"}, []string{"upgrade:1.1.0"}},
+		{"type and status", historyFilter{Type: "upgrade", Status: "success"},
+			[]string{"upgrade:1.0.0", "upgrade:2.0.0", "upgrade:next"}},
+		// Unparseable versions never match a range
+		{"version range", historyFilter{Versions: mustConstraint(t, ">=1.1.0, <2.0.0")}, []string{"upgrade:1.1.0", "reboot:1.1.0"}},
+		{"open version range", historyFilter{Versions: mustConstraint(t, ">=0.0.0")},
+			[]string{"upgrade:1.0.0", "upgrade:1.1.0", "reboot:1.1.0", "upgrade:2.0.0"}},
+		{"since", historyFilter{Since: date("2025-06-04", false)}, []string{"upgrade:2.0.0", "upgrade:next"}},
+		{"until a plain date includes that day", historyFilter{Until: date("2025-06-02", true)},
+			[]string{"upgrade:1.0.0", "upgrade:1.1.0"}},
+		{"until a timestamp", historyFilter{Until: date("2025-06-02T11:59:59Z", true)}, []string{"upgrade:1.0.0"}},
+		{"since and until", historyFilter{Since: date("2025-06-02", false), Until: date("2025-06-03", true)},
+			[]string{"upgrade:1.1.0", "reboot:1.1.0"}},
+		{"nothing matches", historyFilter{Type: "reboot", Status: "failure"}, nil},
+	}
+	h := testHistory()
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := historyKeys(historyRecords(h, tc.filter)); !slices.Equal(got, tc.want) {
+				t.Errorf("records = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWriteHistoryCSV(t *testing.T) {
+	records := historyRecords(testHistory(), historyFilter{Versions: mustConstraint(t, ">=2.0.0")})
+	var out bytes.Buffer
+	if err := writeHistoryCSV(&out, records); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatalf("output is not valid CSV: %v", err)
+	}
+	want := [][]string{
+		{"key", "executed_at", "type", "version", "hash", "genesis", "network",
+			"voters", "status", "error", "duration", "events", "acked_by"},
+		{"upgrade:2.0.0", "2025-06-04T12:00:00Z", "upgrade", "2.0.0", "", "", "",
+			"npub1a;npub1b", "success", "", "1m30s", "", "wss://a.example.com"},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("rows = %q, want %q", rows, want)
+	}
+	for i := range want {
+		if !slices.Equal(rows[i], want[i]) {
+			t.Errorf("row %d = %q, want %q", i, rows[i], want[i])
+		}
+	}
+
+	// Without records only the header is written
+	out.Reset()
+	if err := writeHistoryCSV(&out, nil); err != nil {
+		t.Fatal(err)
+	}
+	if rows, _ := csv.NewReader(&out).ReadAll(); len(rows) != 1 {
+		t.Errorf("empty CSV has %d rows, want only the header", len(rows))
+	}
+}
+
+func TestWriteHistoryJSON(t *testing.T) {
+	records := historyRecords(testHistory(), historyFilter{Status: "failure"})
+	var out bytes.Buffer
+	if err := writeHistoryJSON(&out, records); err != nil {
+		t.Fatal(err)
+	}
+	var got []map[string]any
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("output is not valid JSON: %v", err)
+	}
+	want := map[string]any{"key": "upgrade:1.1.0", "executed_at": "2025-06-02T12:00:00Z", "type": "upgrade",
+		"version": "1.1.0", "status": "failure", "error": "exit status 1"}
+	if len(got) != 1 || len(got[0]) != len(want) {
+		t.Fatalf("records = %v, want [%v]", got, want)
+	}
+	for k, v := range want {
+		if got[0][k] != v {
+			t.Errorf("%s = %v, want %v", k, got[0][k], v)
+		}
+	}
+
+	// No matches is an empty array, not null
+	out.Reset()
+	if err := writeHistoryJSON(&out, historyRecords(testHistory(), historyFilter{Type: "none"})); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != "[]\n" {
+		t.Errorf("empty output = %q, want []", got)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// dirEntries lists the file names in dir
+func dirEntries(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+// truncateHistory cuts history file data off in the middle of its last timestamp, as a
+// write interrupted by a crash would
+func truncateHistory(data []byte) []byte {
+	i := bytes.LastIndex(data, []byte("executed_at: "))
+	return data[:i+len("executed_at: 2025-0")]
+}
+
+func TestHistoryLegacyMigration(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "history.yaml")
+	genesis := "https://example.com/genesis.json"
+	legacy := "entries:\n" +
+		"    upgrade:v1.2.0: \"2025-01-02T03:04:05Z\"\n" +
+		"    reboot:1.3.0:" + genesis + ": \"2025-02-03T04:05:06Z\"\n"
+	if err := os.WriteFile(path, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]HistoryEntry{
+		"upgrade:v1.2.0": {ExecutedAt: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC), Type: "upgrade", Version: "v1.2.0", Status: "success"},
+		"reboot:1.3.0:" + genesis: {ExecutedAt: time.Date(2025, 2, 3, 4, 5, 6, 0, time.UTC), Type: "reboot", Version: "1.3.0",
+			Genesis: genesis, Status: "success"},
+	}
+	check := func(name string, h *History) {
+		t.Helper()
+		if len(h.Entries) != len(want) {
+			t.Fatalf("%s: entries = %v, want %d", name, h.Entries, len(want))
+		}
+		for key, w := range want {
+			e := h.Entries[key]
+			if e == nil || !e.ExecutedAt.Equal(w.ExecutedAt) || e.Type != w.Type || e.Version != w.Version ||
+				e.Genesis != w.Genesis || e.Status != w.Status {
+				t.Errorf("%s: %s = %+v, want %+v", name, key, e, w)
+			}
+		}
+	}
+
+	// Listing migrates in memory without writing
+	listed, err := readHistory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("readHistory", listed)
+	if data, _ := os.ReadFile(path); string(data) != legacy {
+		t.Error("readHistory rewrote the legacy file")
+	}
+
+	h := loadHistory(dir, realClock{})
+	check("loadHistory", h)
+
+	// The next save writes the mapping form, which reads back the same
+	if err := h.Save(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var onDisk struct {
+		Entries map[string]map[string]any `yaml:"entries"`
+	}
+	if err := yaml.Unmarshal(data, &onDisk); err != nil {
+		t.Fatalf("saved history is not in the mapping form: %v\n%s", err, data)
+	}
+	if e := onDisk.Entries["reboot:1.3.0:"+genesis]; e["type"] != "reboot" || e["genesis"] != genesis || e["status"] != "success" {
+		t.Errorf("saved reboot entry = %v", e)
+	}
+	reloaded := loadHistory(dir, realClock{})
+	check("reloaded", reloaded)
+}
+
+func TestHistoryForgetWhileLoaded(t *testing.T) {
+	dir := t.TempDir()
+	daemon := loadHistory(dir, realClock{})
+	daemon.Add("upgrade:1.0.0", HistoryEntry{Type: "upgrade", Version: "1.0.0", Status: "success"})
+	daemon.Add("upgrade:1.1.0", HistoryEntry{Type: "upgrade", Version: "1.1.0", Status: "success"})
+	if err := daemon.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A save after a forget keeps the forget and the daemon's own new entries
+	historyForget(dir, []string{"upgrade:1.0.0"})
+	daemon.Add("upgrade:1.2.0", HistoryEntry{Type: "upgrade", Version: "1.2.0", Status: "success"})
+	if err := daemon.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if daemon.Has("upgrade:1.0.0") {
+		t.Error("forgotten entry still in the loaded history after a save")
+	}
+	onDisk, err := readHistory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if onDisk.Has("upgrade:1.0.0") || !onDisk.Has("upgrade:1.1.0") || !onDisk.Has("upgrade:1.2.0") {
+		t.Errorf("history on disk = %v, want 1.1.0 and 1.2.0", onDisk.Entries)
+	}
+
+	// Without a save in between, a reload picks the forget up
+	historyForget(dir, []string{"upgrade:1.1.0"})
+	daemon.Reload()
+	if daemon.Has("upgrade:1.1.0") {
+		t.Error("forgotten entry still in the loaded history after a reload")
+	}
+	if !daemon.Has("upgrade:1.2.0") {
+		t.Error("reload dropped an entry that is still on disk")
+	}
+}
+
+func TestReadHistoryDoesNotWrite(t *testing.T) {
+	dir := t.TempDir()
+	h, err := readHistory(dir)
+	if err != nil || len(h.Entries) != 0 {
+		t.Fatalf("readHistory of an empty directory = %v, %v", h, err)
+	}
+	if names := dirEntries(t, dir); len(names) != 0 {
+		t.Errorf("readHistory created %v", names)
+	}
+
+	// A corrupt file is shown from its backup and left in place for loadHistory to recover
+	saved := loadHistory(dir, realClock{})
+	saved.Add("upgrade:1.0.0", HistoryEntry{Type: "upgrade", Version: "1.0.0", Status: "success"})
+	saved.Save()
+	saved.Add("upgrade:1.1.0", HistoryEntry{Type: "upgrade", Version: "1.1.0", Status: "success"})
+	saved.Save()
+	path := filepath.Join(dir, "history.yaml")
+	os.WriteFile(path, []byte("entries: [not a map"), 0644)
+	before := dirEntries(t, dir)
+
+	h, err = readHistory(dir)
+	if err != nil {
+		t.Fatalf("readHistory: %v", err)
+	}
+	if !h.Has("upgrade:1.0.0") || !h.Has("upgrade:1.1.0") {
+		t.Errorf("readHistory = %v, want the backup with 1.0.0 and 1.1.0", h.Entries)
+	}
+	if after := dirEntries(t, dir); !slices.Equal(before, after) {
+		t.Errorf("readHistory changed the directory from %v to %v", before, after)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "entries: [not a map" {
+		t.Error("readHistory rewrote the corrupt file")
+	}
+}
+
+func TestHistoryBackupMirrorsSaves(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "history.yaml")
+	h := loadHistory(dir, realClock{})
+	h.Add("upgrade:1.0.0", HistoryEntry{Type: "upgrade", Version: "1.0.0", Status: "success"})
+	if err := h.Save(); err != nil {
+		t.Fatal(err)
+	}
+	h.Add("upgrade:1.1.0", HistoryEntry{Type: "upgrade", Version: "1.1.0", Status: "success"})
+	if err := h.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// After two saves the backup holds the second, not the first
+	main, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup, err := os.ReadFile(path + ".bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(backup) != string(main) {
+		t.Errorf("backup = %q, want the same content as history.yaml %q", backup, main)
+	}
+	var saved History
+	if err := readHistoryFile(path+".bak", &saved); err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Entries) != 2 || saved.Entries["upgrade:1.1.0"] == nil {
+		t.Errorf("backup entries = %v, want 1.0.0 and 1.1.0", saved.Entries)
+	}
+}
+
+func TestHistoryBackupRecovery(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "history.yaml")
+	clk := newFakeClock(time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC))
+	h := loadHistory(dir, clk)
+	h.Add("upgrade:1.0.0", HistoryEntry{ExecutedAt: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), Type: "upgrade", Version: "1.0.0", Status: "success"})
+	h.Save()
+	h.Add("upgrade:1.1.0", HistoryEntry{ExecutedAt: time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC), Type: "upgrade", Version: "1.1.0", Status: "success"})
+	h.Save()
+
+	// A truncated file is recovered from the backup, which kept up with every write,
+	// and moved aside under the injected clock's time
+	data, _ := os.ReadFile(path)
+	os.WriteFile(path, truncateHistory(data), 0644)
+	recovered, err := openHistory(dir, clk)
+	if err != nil {
+		t.Fatalf("openHistory of a truncated file: %v", err)
+	}
+	if !recovered.Has("upgrade:1.0.0") || !recovered.Has("upgrade:1.1.0") {
+		t.Errorf("recovered history = %v, want 1.0.0 and 1.1.0", recovered.Entries)
+	}
+	corruptPath := fmt.Sprintf("%s.corrupt-%d", path, clk.Now().Unix())
+	if kept, _ := os.ReadFile(corruptPath); string(kept) != string(truncateHistory(data)) {
+		t.Errorf("corrupt file not kept as %s", corruptPath)
+	}
+	if onDisk, err := readHistory(dir); err != nil || !onDisk.Has("upgrade:1.1.0") {
+		t.Errorf("history file not rewritten from the backup: %v", err)
+	}
+
+	// A missing file is recovered too
+	os.Remove(path)
+	if h, err := openHistory(dir, clk); err != nil || !h.Has("upgrade:1.1.0") {
+		t.Errorf("openHistory of a missing file with a backup = %v", err)
+	}
+
+	// The operator can restore by hand; a readable file is never replaced
+	clk.Advance(time.Hour)
+	os.WriteFile(path, []byte("entries: [not a map"), 0644)
+	restored, err := restoreHistory(dir, clk)
+	if err != nil || !restored.Has("upgrade:1.1.0") {
+		t.Fatalf("restoreHistory = %v", err)
+	}
+	if corrupt, _ := filepath.Glob(path + ".corrupt-*"); len(corrupt) != 2 {
+		t.Errorf("corrupt files kept = %v, want 2", corrupt)
+	}
+	if _, err := restoreHistory(dir, clk); err == nil {
+		t.Error("restoreHistory replaced a readable history file")
+	}
+
+	// Without a backup, a corrupt file cannot be recovered automatically
+	empty := t.TempDir()
+	os.WriteFile(filepath.Join(empty, "history.yaml"), []byte("entries: [not a map"), 0644)
+	if _, err := openHistory(empty, clk); err == nil || !strings.Contains(err.Error(), "no backup") {
+		t.Errorf("openHistory of a corrupt file without backup = %v", err)
+	}
+}
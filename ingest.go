@@ -0,0 +1,306 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Rejection reasons for HyperSignal events that are not counted as votes
+const (
+	rejectBadSignature   = "bad_signature"
+	rejectUnknownAuthor  = "unknown_author"
+	rejectFutureEvent    = "future_timestamp"
+	rejectWrongKind      = "wrong_kind"
+	rejectWrongDTag      = "wrong_d_tag"
+	rejectMissingTags    = "missing_tags"
+	rejectInvalidHash    = "invalid_hash"
+	rejectWrongNetwork   = "wrong_network"
+	rejectInvalidVersion = "invalid_version"
+	rejectInvalidGenesis = "invalid_genesis"
+	rejectUnknownAction  = "unknown_action"
+	rejectStaleSignal    = "stale_signal"
+	rejectDuplicate      = "duplicate"
+)
+
+// defaultMaxClockSkew is how far in the future an event's created_at may be when
+// max_clock_skew is not configured
+const defaultMaxClockSkew = 10 * time.Minute
+
+// rejection describes why an event was not counted as a vote
+type rejection struct {
+	Reason string // One of the reject* constants
+	Detail string // Human-readable explanation
+	Warn   bool   // Log at WARN (security relevant) rather than DEBUG
+}
+
+func (r *rejection) Error() string {
+	return fmt.Sprintf("%s: %s", r.Reason, r.Detail)
+}
+
+// signalState holds candidate actions and votes built from HyperSignal events
+type signalState struct {
+	mu sync.RWMutex
+
+	// Map to hold candidate actions keyed by unique history keys
+	actions map[string]*CandidateAction
+
+	// Map of action key -> set of pubkeys that voted for this action
+	votes map[string]map[string]bool
+
+	// Track latest signal from each dev for single active message model
+	// Map: dev_pubkey -> latest created_at timestamp
+	latestSignal map[string]nostr.Timestamp
+
+	// Track which action key each dev's latest signal created
+	// Map: dev_pubkey -> action_key
+	signalActionMap map[string]string
+
+	// Latest accepted signal of each dev, persisted and exported
+	// Map: dev_pubkey -> signed event
+	signals map[string]nostr.Event
+
+	// Actions whose status event could not be signed are not retried before this time
+	// Map: action_key -> retry time
+	signRetryAt map[string]time.Time
+}
+
+// newSignalState creates an empty vote tally
+func newSignalState() *signalState {
+	return &signalState{
+		actions:         make(map[string]*CandidateAction),
+		votes:           make(map[string]map[string]bool),
+		latestSignal:    make(map[string]nostr.Timestamp),
+		signalActionMap: make(map[string]string),
+		signals:         make(map[string]nostr.Event),
+		signRetryAt:     make(map[string]time.Time),
+	}
+}
+
+// held returns the latest accepted signal of each dev, oldest first
+func (s *signalState) held() []nostr.Event {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	events := make([]nostr.Event, 0, len(s.signals))
+	for _, ev := range s.signals {
+		events = append(events, ev)
+	}
+	sortEvents(events)
+	return events
+}
+
+// validateFollowEvent performs the checks every event from a trusted developer must
+// pass regardless of its kind: author, signature and timestamp sanity.
+func validateFollowEvent(ev *nostr.Event, follows map[string]bool, now time.Time, maxSkew time.Duration) *rejection {
+	if !follows[ev.PubKey] {
+		return &rejection{rejectUnknownAuthor, fmt.Sprintf("pubkey %s is not followed", ev.PubKey), true}
+	}
+
+	if ok, err := ev.CheckSignature(); !ok {
+		detail := "signature does not match event"
+		if err != nil {
+			detail = err.Error()
+		}
+		return &rejection{rejectBadSignature, detail, true}
+	}
+
+	if limit := now.Add(maxSkew); ev.CreatedAt.Time().After(limit) {
+		return &rejection{rejectFutureEvent, fmt.Sprintf("created_at %s is more than %s ahead of local clock",
+			ev.CreatedAt.Time().UTC().Format(time.RFC3339), maxSkew), true}
+	}
+
+	return nil
+}
+
+// ingest validates a HyperSignal event and records it as a vote.
+// Returns nil if the vote was counted, or a rejection explaining why it was not.
+func (s *signalState) ingest(ev *nostr.Event, config *Config, follows map[string]bool, now time.Time) *rejection {
+	if ev.Kind != 33321 {
+		return &rejection{rejectWrongKind, fmt.Sprintf("kind %d", ev.Kind), false}
+	}
+	if r := validateFollowEvent(ev, follows, now, config.MaxClockSkew); r != nil {
+		return r
+	}
+
+	// Validate required tags
+	dTag := getTagValue(ev, "d")
+	if dTag != "hyperqube" {
+		return &rejection{rejectWrongDTag, fmt.Sprintf("d tag %q", dTag), false}
+	}
+
+	// Extract required tags
+	version := getTagValue(ev, "version")
+	hash := getTagValue(ev, "hash")
+	network := getTagValue(ev, "network")
+	action := getTagValue(ev, "action")
+
+	// Validate required tags are present
+	if version == "" || hash == "" || network == "" || action == "" {
+		return &rejection{rejectMissingTags, fmt.Sprintf("version=%s, hash=%s, network=%s, action=%s",
+			version, hash, network, action), false}
+	}
+
+	// Nodes compare the hash with the binary they download, so a malformed one can never match
+	if err := validateHash(hash); err != nil {
+		return &rejection{rejectInvalidHash, fmt.Sprintf("%q: %v", hash, err), true}
+	}
+
+	// Network filtering: only process events for our configured network
+	if network != config.Network {
+		return &rejection{rejectWrongNetwork, fmt.Sprintf("network %s (we are %s)", network, config.Network), false}
+	}
+
+	// Parse semantic version
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return &rejection{rejectInvalidVersion, fmt.Sprintf("invalid semantic version: %s", version), true}
+	}
+
+	var key, genesisURL string
+	switch action {
+	case "upgrade":
+		key = fmt.Sprintf("upgrade:%s", v.Original())
+	case "reboot":
+		genesisURL = getTagValue(ev, "genesis_url")
+		if genesisURL == "" {
+			return &rejection{rejectInvalidGenesis, "reboot action missing genesis_url tag", true}
+		}
+		if _, err := url.ParseRequestURI(genesisURL); err != nil {
+			return &rejection{rejectInvalidGenesis, fmt.Sprintf("invalid genesis URL in reboot: %s", genesisURL), true}
+		}
+		key = fmt.Sprintf("reboot:%s:%s", v.Original(), genesisURL)
+	default:
+		return &rejection{rejectUnknownAction, fmt.Sprintf("unknown action type: %s", action), false}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Single active message model: Check if this is a newer signal from this dev
+	if prevTimestamp, exists := s.latestSignal[ev.PubKey]; exists {
+		if ev.CreatedAt == prevTimestamp {
+			// Same signal delivered again, typically by another relay
+			return &rejection{rejectDuplicate, fmt.Sprintf("already counted signal from pubkey %s at %d",
+				ev.PubKey[:8]+"...", ev.CreatedAt), false}
+		}
+		if ev.CreatedAt < prevTimestamp {
+			// This signal is older than what we've already seen from this dev - ignore it
+			return &rejection{rejectStaleSignal, fmt.Sprintf("older signal from pubkey %s (timestamp %d < %d)",
+				ev.PubKey[:8]+"...", ev.CreatedAt, prevTimestamp), false}
+		}
+
+		// This is a newer signal from the same dev - clear old votes
+		if oldActionKey, hasOldAction := s.signalActionMap[ev.PubKey]; hasOldAction {
+			// Remove this dev's vote from the old action
+			if oldVotes, oldVotesExist := s.votes[oldActionKey]; oldVotesExist {
+				delete(oldVotes, ev.PubKey)
+				log.Printf("[INFO] Cleared vote from pubkey %s for old action %s (superseded by newer signal)",
+					ev.PubKey[:8]+"...", oldActionKey)
+			}
+		}
+	}
+
+	actionStruct, exists := s.actions[key]
+	if !exists {
+		actionStruct = &CandidateAction{
+			Type:           action,
+			Version:        v,
+			Key:            key,
+			Genesis:        genesisURL,
+			Hash:           hash,
+			Network:        network,
+			OriginalPubkey: ev.PubKey,
+		}
+		s.actions[key] = actionStruct
+	}
+	actionStruct.addReleaseSignatures(getReleaseSignatures(ev))
+
+	if s.votes[key] == nil {
+		s.votes[key] = make(map[string]bool)
+	}
+	s.votes[key][ev.PubKey] = true
+
+	// Update tracking for single active message model
+	s.latestSignal[ev.PubKey] = ev.CreatedAt
+	s.signalActionMap[ev.PubKey] = key
+	s.signals[ev.PubKey] = *ev
+
+	if action == "reboot" {
+		log.Printf("[INFO] Parsed reboot signal: version=%s network=%s genesis=%s hash=%s pubkey=%s",
+			v.Original(), network, genesisURL, hash[:8]+"...", ev.PubKey[:8]+"...")
+	} else {
+		log.Printf("[INFO] Parsed upgrade signal: version=%s network=%s hash=%s pubkey=%s",
+			v.Original(), network, hash[:8]+"...", ev.PubKey[:8]+"...")
+	}
+
+	return nil
+}
+
+// ingestStats counts accepted and rejected events by reason
+type ingestStats struct {
+	mu       sync.Mutex
+	accepted int
+	rejected map[string]int
+}
+
+// newIngestStats creates empty counters
+func newIngestStats() *ingestStats {
+	return &ingestStats{rejected: make(map[string]int)}
+}
+
+// accept counts an accepted event
+func (st *ingestStats) accept() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.accepted++
+}
+
+// reject counts a rejected event and returns the running total for its reason
+func (st *ingestStats) reject(reason string) int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.rejected[reason]++
+	return st.rejected[reason]
+}
+
+// summary returns a one-line overview such as "accepted=4 rejected: bad_signature=1"
+func (st *ingestStats) summary() string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	reasons := make([]string, 0, len(st.rejected))
+	for reason := range st.rejected {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+
+	parts := make([]string, 0, len(reasons))
+	for _, reason := range reasons {
+		parts = append(parts, fmt.Sprintf("%s=%d", reason, st.rejected[reason]))
+	}
+	if len(parts) == 0 {
+		return fmt.Sprintf("accepted=%d rejected=0", st.accepted)
+	}
+	return fmt.Sprintf("accepted=%d rejected: %s", st.accepted, strings.Join(parts, " "))
+}
+
+// logRejection logs a rejected event, always for security relevant reasons and otherwise only when verbose
+func logRejection(ev *nostr.Event, relayURL string, r *rejection, count int, verbose bool) {
+	author := ev.PubKey
+	if len(author) > 8 {
+		author = author[:8] + "..."
+	}
+	if r.Warn {
+		log.Printf("[WARN] Rejected event %s from %s via %s: %s (%d %s rejection(s) so far)",
+			ev.ID, author, relayURL, r, count, r.Reason)
+	} else if verbose {
+		log.Printf("[DEBUG] Skipping event %s from %s: %s", ev.ID, author, r)
+	}
+}
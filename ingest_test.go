@@ -0,0 +1,137 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestIngestRejectsMalformedHash(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	dev := newTestDevs(1)[0]
+	config := Config{Quorum: 1, Network: "hqz", MaxClockSkew: defaultMaxClockSkew}
+	follows := newFollowSet([]string{dev.pk}).Snapshot()
+	state := newSignalState()
+
+	for i, hash := range []string{"abc", "A1B2C3D4E5F6A7B8C9D0E1F2A3B4C5D6E7F8A9B0C1D2E3F4A5B6C7D8E9F0A1B2"} {
+		ev := dev.signal(t, "1.1.0", "hqz", nostr.Timestamp(now.Unix())+nostr.Timestamp(i))
+		ev.Tags[2] = nostr.Tag{"hash", hash}
+		if err := ev.Sign(dev.sk); err != nil {
+			t.Fatal(err)
+		}
+		r := state.ingest(&ev, &config, follows, now)
+		if r == nil || r.Reason != rejectInvalidHash {
+			t.Errorf("hash %q: rejection = %v, want %s", hash, r, rejectInvalidHash)
+		}
+	}
+	if len(state.actions) != 0 {
+		t.Errorf("malformed hashes created actions: %v", state.actions)
+	}
+
+	ev := dev.signal(t, "1.1.0", "hqz", nostr.Timestamp(now.Unix()))
+	if r := state.ingest(&ev, &config, follows, now); r != nil {
+		t.Errorf("valid signal rejected: %v", r)
+	}
+}
+
+func TestIngestRejections(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	devs := newTestDevs(2)
+	dev, stranger := devs[0], devs[1]
+	config := Config{Quorum: 1, Network: "hqz", MaxClockSkew: defaultMaxClockSkew}
+	follows := newFollowSet([]string{dev.pk}).Snapshot()
+	at := nostr.Timestamp(now.Unix())
+
+	// resign signs ev again as d after a change, so only the change is wrong
+	resign := func(d testDev, ev nostr.Event) nostr.Event {
+		if err := ev.Sign(d.sk); err != nil {
+			t.Fatal(err)
+		}
+		return ev
+	}
+
+	tests := []struct {
+		name   string
+		event  func() nostr.Event
+		reason string
+		warn   bool
+	}{
+		{"bad signature", func() nostr.Event {
+			ev := dev.signal(t, "1.1.0", "hqz", at)
+			ev.Content = "tampered after signing"
+			return ev
+		}, rejectBadSignature, true},
+		{"signature from another key", func() nostr.Event {
+			ev := dev.signal(t, "1.1.0", "hqz", at)
+			ev.Sig = stranger.signal(t, "1.1.0", "hqz", at).Sig
+			return ev
+		}, rejectBadSignature, true},
+		{"author outside the follow set", func() nostr.Event {
+			return stranger.signal(t, "1.1.0", "hqz", at)
+		}, rejectUnknownAuthor, true},
+		{"timestamp beyond max_clock_skew", func() nostr.Event {
+			return dev.signal(t, "1.1.0", "hqz", at+nostr.Timestamp(defaultMaxClockSkew/time.Second)+1)
+		}, rejectFutureEvent, true},
+		{"wrong kind", func() nostr.Event {
+			ev := dev.signal(t, "1.1.0", "hqz", at)
+			ev.Kind = 30078
+			return resign(dev, ev)
+		}, rejectWrongKind, false},
+		{"wrong d tag", func() nostr.Event {
+			ev := dev.signal(t, "1.1.0", "hqz", at)
+			ev.Tags[0] = nostr.Tag{"d", "other-app"}
+			return resign(dev, ev)
+		}, rejectWrongDTag, false},
+		{"missing network tag", func() nostr.Event {
+			ev := dev.signal(t, "1.1.0", "hqz", at)
+			ev.Tags = slices.Delete(ev.Tags, 3, 4)
+			return resign(dev, ev)
+		}, rejectMissingTags, false},
+		{"wrong network", func() nostr.Event {
+			return dev.signal(t, "1.1.0", "testnet", at)
+		}, rejectWrongNetwork, false},
+		{"invalid version", func() nostr.Event {
+			return dev.signal(t, "not-a-version", "hqz", at)
+		}, rejectInvalidVersion, true},
+	}
+
+	state := newSignalState()
+	stats := newIngestStats()
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ev := tc.event()
+			r := state.ingest(&ev, &config, follows, now)
+			if r == nil {
+				t.Fatalf("accepted, want %s", tc.reason)
+			}
+			if r.Reason != tc.reason || r.Warn != tc.warn {
+				t.Errorf("rejection = %v (warn %v), want %s (warn %v)", r, r.Warn, tc.reason, tc.warn)
+			}
+			before := stats.rejected[r.Reason]
+			if got := stats.reject(r.Reason); got != before+1 {
+				t.Errorf("%s count = %d, want %d", r.Reason, got, before+1)
+			}
+		})
+	}
+	if len(state.actions) != 0 || len(state.signals) != 0 {
+		t.Errorf("rejected events were counted: actions %v", state.actions)
+	}
+
+	// Within max_clock_skew a signal is accepted
+	ev := dev.signal(t, "1.1.0", "hqz", at+nostr.Timestamp(defaultMaxClockSkew/time.Second))
+	if r := state.ingest(&ev, &config, follows, now); r != nil {
+		t.Fatalf("signal within max_clock_skew rejected: %v", r)
+	}
+	stats.accept()
+
+	if stats.accepted != 1 || stats.rejected[rejectBadSignature] != 2 || stats.rejected[rejectUnknownAuthor] != 1 {
+		t.Errorf("counters = accepted %d, rejected %v", stats.accepted, stats.rejected)
+	}
+	want := "accepted=1 rejected: bad_signature=2 future_timestamp=1 invalid_version=1 missing_tags=1 " +
+		"unknown_author=1 wrong_d_tag=1 wrong_kind=1 wrong_network=1"
+	if got := stats.summary(); got != want {
+		t.Errorf("summary = %q, want %q", got, want)
+	}
+}
@@ -2,40 +2,192 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
+	"log"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/nbd-wtf/go-nostr"
 	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/nbd-wtf/go-nostr/nip49"
+	"golang.org/x/term"
 )
 
+// passphraseEnv is the environment variable checked first for the key passphrase
+const passphraseEnv = "QUBE_MANAGER_PASSPHRASE"
+
+// ncryptsecLogN is the NIP-49 scrypt cost parameter (2^16 rounds, ~100ms)
+const ncryptsecLogN = 16
+
 type Keypair struct {
-	Nsec string `json:"nsec"` // nsec...
-	Npub string `json:"npub"` // npub...
+	Nsec      string `json:"nsec,omitempty"`      // nsec... (plaintext, empty when encrypted)
+	Ncryptsec string `json:"ncryptsec,omitempty"` // ncryptsec... (NIP-49 encrypted secret key)
+	Npub      string `json:"npub"`                // npub...
 }
 
-func loadOrCreateKeypair(configDir string) Keypair {
-	keyPath := filepath.Join(configDir, "keys.json")
+// Encrypted reports whether the secret key is stored as a NIP-49 ncryptsec
+func (kp Keypair) Encrypted() bool {
+	return kp.Ncryptsec != ""
+}
 
-	if data, err := os.ReadFile(keyPath); err == nil {
-		var kp Keypair
-		if json.Unmarshal(data, &kp) == nil {
-			return kp
+// SecretKey returns the hex secret key, decrypting it with passphrase when encrypted
+func (kp Keypair) SecretKey(passphrase string) (string, error) {
+	if kp.Encrypted() {
+		sk, err := nip49.Decrypt(kp.Ncryptsec, passphrase)
+		if err != nil {
+			return "", fmt.Errorf("failed to decrypt ncryptsec (wrong passphrase?): %w", err)
 		}
+		return sk, nil
 	}
 
-	// Generate new key
+	prefix, sk, err := nip19.Decode(kp.Nsec)
+	if err != nil {
+		return "", fmt.Errorf("invalid nsec: %w", err)
+	}
+	if prefix != "nsec" {
+		return "", fmt.Errorf("expected nsec but got %s", prefix)
+	}
+	return sk.(string), nil
+}
+
+// readKeypair reads keys.json. It returns ok=false if the file does not exist and exits
+// with recovery guidance if the file exists but cannot be used, rather than replacing
+// it and orphaning the identity it holds.
+func readKeypair(configDir string) (kp Keypair, ok bool) {
+	kp, ok, err := openKeypair(configDir)
+	if err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+	return kp, ok
+}
+
+// openKeypair reads keys.json, returning ok=false if the file does not exist and an
+// error with recovery guidance if it exists but cannot be used
+func openKeypair(configDir string) (kp Keypair, ok bool, err error) {
+	keyPath := filepath.Join(configDir, "keys.json")
+
+	data, err := os.ReadFile(keyPath)
+	if os.IsNotExist(err) {
+		return Keypair{}, false, nil
+	}
+	if err != nil {
+		return Keypair{}, false, fmt.Errorf("failed to read %s: %w", keyPath, err)
+	}
+
+	if err := json.Unmarshal(data, &kp); err != nil {
+		return Keypair{}, false, fmt.Errorf("failed to parse %s: %w\n"+
+			"Refusing to generate a new key, which would replace your identity. To recover:\n"+
+			"  - restore keys.json from a backup (e.g. a keys-*.json.bak file in %s), or\n"+
+			"  - re-import your key with: qube-manager keys import <nsec|ncryptsec>, or\n"+
+			"  - move the file away and run again to generate a new identity", keyPath, err, configDir)
+	}
+	if kp.Npub == "" || (kp.Nsec == "" && kp.Ncryptsec == "") {
+		return Keypair{}, false, fmt.Errorf("%s is missing its npub or secret key; restore it from a backup or run: qube-manager keys import <nsec|ncryptsec>", keyPath)
+	}
+	return kp, true, nil
+}
+
+// generateKeypair creates a new random plaintext keypair
+func generateKeypair() Keypair {
 	sk := nostr.GeneratePrivateKey()
 	pk, _ := nostr.GetPublicKey(sk)
 	nsec, _ := nip19.EncodePrivateKey(sk)
 	npub, _ := nip19.EncodePublicKey(pk)
+	return Keypair{Nsec: nsec, Npub: npub}
+}
 
-	kp := Keypair{
-		Nsec: nsec,
-		Npub: npub,
+func loadOrCreateKeypair(configDir string) Keypair {
+	kp, created, err := openOrCreateKeypair(configDir)
+	if err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+	if created {
+		log.Printf("[INFO] Generated new keypair %s", kp.Npub)
 	}
-	data, _ := json.MarshalIndent(kp, "", "  ")
-	os.MkdirAll(configDir, 0700)
-	_ = os.WriteFile(keyPath, data, 0600)
 	return kp
 }
+
+// openOrCreateKeypair reads keys.json, generating and saving a new keypair only when the
+// file does not exist. An unusable file is an error and is left untouched.
+func openOrCreateKeypair(configDir string) (kp Keypair, created bool, err error) {
+	kp, ok, err := openKeypair(configDir)
+	if err != nil || ok {
+		return kp, false, err
+	}
+
+	// Generate new key
+	kp = generateKeypair()
+	os.MkdirAll(configDir, 0700)
+	if err := saveKeypair(configDir, kp); err != nil {
+		return Keypair{}, false, fmt.Errorf("failed to save new keypair: %w", err)
+	}
+	return kp, true, nil
+}
+
+// saveKeypair atomically replaces keys.json with kp
+func saveKeypair(configDir string, kp Keypair) error {
+	data, err := json.MarshalIndent(kp, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(configDir, "keys.json"), data, 0600)
+}
+
+// readPassphrase obtains the key passphrase from, in order: the QUBE_MANAGER_PASSPHRASE
+// environment variable, passphraseFile (if set), or an interactive terminal prompt
+// when interactive is true
+func readPassphrase(passphraseFile string, interactive bool, prompt string) (string, error) {
+	if p := os.Getenv(passphraseEnv); p != "" {
+		return p, nil
+	}
+
+	if passphraseFile != "" {
+		data, err := os.ReadFile(passphraseFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read passphrase file: %w", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	if !interactive {
+		return "", fmt.Errorf("no passphrase available: set %s or use --passphrase-file", passphraseEnv)
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", fmt.Errorf("cannot prompt for passphrase: stdin is not a terminal (set %s or use --passphrase-file)", passphraseEnv)
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	p, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	return string(p), nil
+}
+
+// loadSecretKey loads the keypair from configDir and returns it with its hex secret key,
+// unlocking an encrypted key via readPassphrase. Exits on failure.
+func loadSecretKey(configDir, passphraseFile string, interactive bool) (Keypair, string) {
+	kp := loadOrCreateKeypair(configDir)
+	return kp, unlockKeypair(kp, passphraseFile, interactive)
+}
+
+// unlockKeypair returns the hex secret key of kp, asking for the passphrase if it is encrypted
+func unlockKeypair(kp Keypair, passphraseFile string, interactive bool) string {
+	passphrase := ""
+	if kp.Encrypted() {
+		log.Printf("[INFO] Private key is encrypted (NIP-49), unlocking")
+		p, err := readPassphrase(passphraseFile, interactive, "Passphrase for "+kp.Npub+": ")
+		if err != nil {
+			log.Fatalf("[ERROR] Cannot unlock private key: %v", err)
+		}
+		passphrase = p
+	}
+
+	sk, err := kp.SecretKey(passphrase)
+	if err != nil {
+		log.Fatalf("[ERROR] Invalid private key in config: %v", err)
+	}
+	return sk
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/nbd-wtf/go-nostr/nip49"
+)
+
+// keysCLI handles the 'keys' subcommand for key lifecycle management
+func keysCLI(configDir, passphraseFile string, args []string) {
+	sub := "show"
+	if len(args) > 0 {
+		sub, args = args[0], args[1:]
+	}
+
+	switch sub {
+	case "show":
+		keysShow(configDir)
+	case "import":
+		keysImport(configDir, passphraseFile, args)
+	case "generate":
+		keysGenerate(configDir, passphraseFile, args)
+	case "export":
+		keysExport(configDir, passphraseFile)
+	case "follow-line":
+		keysFollowLine(configDir, args)
+	case "encrypt":
+		keysEncrypt(configDir, passphraseFile)
+	case "decrypt":
+		keysDecrypt(configDir, passphraseFile)
+	case "rotate":
+		keysRotate(configDir, passphraseFile, args)
+	case "endorse-rotation":
+		keysEndorseRotation(configDir, passphraseFile, args)
+	default:
+		log.Fatalf("[ERROR] Unknown keys command '%s'. Must be one of: show, import, generate, export, follow-line, encrypt, decrypt, rotate, endorse-rotation", sub)
+	}
+}
+
+// keysShow prints the public identity stored in keys.json
+func keysShow(configDir string) {
+	kp, ok := readKeypair(configDir)
+	if !ok {
+		log.Fatalf("[ERROR] No keys.json in %s; run 'qube-manager keys generate' or 'qube-manager keys import'", configDir)
+	}
+
+	_, pk, err := nip19.Decode(kp.Npub)
+	if err != nil {
+		log.Fatalf("[ERROR] Invalid npub in keys.json: %v", err)
+	}
+
+	storage := "plaintext nsec"
+	if kp.Encrypted() {
+		storage = "encrypted (NIP-49 ncryptsec)"
+	}
+
+	fmt.Printf("npub:    %s\n", kp.Npub)
+	fmt.Printf("pubkey:  %s\n", pk.(string))
+	fmt.Printf("storage: %s\n", storage)
+}
+
+// keysImport replaces keys.json with an existing nsec, hex or ncryptsec secret key
+func keysImport(configDir, passphraseFile string, args []string) {
+	var encrypt bool
+
+	flagSet := flag.NewFlagSet("keys import", flag.ExitOnError)
+	flagSet.BoolVar(&encrypt, "encrypt", false, "Encrypt the imported key with a passphrase (NIP-49)")
+	flagSet.Parse(args)
+
+	if flagSet.NArg() != 1 {
+		log.Fatal("[ERROR] Usage: qube-manager keys import [-encrypt] <nsec|hex|ncryptsec>")
+	}
+	input := strings.TrimSpace(flagSet.Arg(0))
+
+	var kp Keypair
+	switch {
+	case strings.HasPrefix(input, "ncryptsec1"):
+		passphrase, err := readPassphrase(passphraseFile, true, "Passphrase for ncryptsec: ")
+		if err != nil {
+			log.Fatalf("[ERROR] %v", err)
+		}
+		sk, err := nip49.Decrypt(input, passphrase)
+		if err != nil {
+			log.Fatalf("[ERROR] Failed to decrypt ncryptsec (wrong passphrase?): %v", err)
+		}
+		kp = keypairFromSecret(sk)
+		kp.Nsec, kp.Ncryptsec = "", input
+		encrypt = false
+	case strings.HasPrefix(input, "nsec1"):
+		prefix, sk, err := nip19.Decode(input)
+		if err != nil || prefix != "nsec" {
+			log.Fatalf("[ERROR] Invalid nsec: %v", err)
+		}
+		kp = keypairFromSecret(sk.(string))
+	default:
+		if b, err := hex.DecodeString(input); err != nil || len(b) != 32 {
+			log.Fatal("[ERROR] Key must be an nsec, an ncryptsec or 64 hex characters")
+		}
+		kp = keypairFromSecret(strings.ToLower(input))
+	}
+
+	if encrypt {
+		kp = encryptKeypair(kp, passphraseFile)
+	}
+
+	replaceKeypair(configDir, kp)
+	log.Printf("[INFO] Imported key %s", kp.Npub)
+}
+
+// keysGenerate creates a new identity, backing up the previous keys.json
+func keysGenerate(configDir, passphraseFile string, args []string) {
+	var encrypt bool
+
+	flagSet := flag.NewFlagSet("keys generate", flag.ExitOnError)
+	flagSet.BoolVar(&encrypt, "encrypt", false, "Encrypt the new key with a passphrase (NIP-49)")
+	flagSet.Parse(args)
+
+	kp := generateKeypair()
+	if encrypt {
+		kp = encryptKeypair(kp, passphraseFile)
+	}
+
+	replaceKeypair(configDir, kp)
+	log.Printf("[INFO] Generated new key %s", kp.Npub)
+	log.Println("[INFO] Other nodes must update their follows to trust the new key (see 'qube-manager keys follow-line')")
+}
+
+// keysExport prints the secret key encrypted as a NIP-49 ncryptsec
+func keysExport(configDir, passphraseFile string) {
+	kp, ok := readKeypair(configDir)
+	if !ok {
+		log.Fatalf("[ERROR] No keys.json in %s", configDir)
+	}
+	if !kp.Encrypted() {
+		kp = encryptKeypair(kp, passphraseFile)
+	}
+	fmt.Println(kp.Ncryptsec)
+}
+
+// keysFollowLine prints the config.yaml line other nodes add to their follows to trust this key
+func keysFollowLine(configDir string, args []string) {
+	var name string
+
+	flagSet := flag.NewFlagSet("keys follow-line", flag.ExitOnError)
+	flagSet.StringVar(&name, "name", "", "Name to put in the line comment")
+	flagSet.Parse(args)
+
+	kp, ok := readKeypair(configDir)
+	if !ok {
+		log.Fatalf("[ERROR] No keys.json in %s", configDir)
+	}
+	if name == "" {
+		name = "<name>"
+	}
+	fmt.Printf("  - %s  # %s\n", kp.Npub, name)
+}
+
+// keysEncrypt replaces the plaintext nsec in keys.json with a NIP-49 ncryptsec
+func keysEncrypt(configDir, passphraseFile string) {
+	kp, ok := readKeypair(configDir)
+	if !ok {
+		log.Fatalf("[ERROR] No keys.json in %s", configDir)
+	}
+	if kp.Encrypted() {
+		log.Println("[INFO] Private key is already encrypted")
+		return
+	}
+
+	if err := saveKeypair(configDir, encryptKeypair(kp, passphraseFile)); err != nil {
+		log.Fatalf("[ERROR] Failed to save keys.json: %v", err)
+	}
+	log.Println("[INFO] Private key encrypted with NIP-49; keys.json no longer contains the plaintext nsec")
+}
+
+// keysDecrypt stores an encrypted key in plaintext again
+func keysDecrypt(configDir, passphraseFile string) {
+	kp, sk := loadSecretKey(configDir, passphraseFile, true)
+	if !kp.Encrypted() {
+		log.Println("[INFO] Private key is already stored in plaintext")
+		return
+	}
+
+	if err := saveKeypair(configDir, keypairFromSecret(sk)); err != nil {
+		log.Fatalf("[ERROR] Failed to save keys.json: %v", err)
+	}
+	log.Println("[INFO] Private key decrypted and stored in plaintext")
+}
+
+// keypairFromSecret builds a plaintext keypair from a hex secret key
+func keypairFromSecret(sk string) Keypair {
+	pk, err := nostr.GetPublicKey(sk)
+	if err != nil {
+		log.Fatalf("[ERROR] Invalid secret key: %v", err)
+	}
+	nsec, _ := nip19.EncodePrivateKey(sk)
+	npub, _ := nip19.EncodePublicKey(pk)
+	return Keypair{Nsec: nsec, Npub: npub}
+}
+
+// encryptKeypair returns kp with its nsec replaced by a NIP-49 ncryptsec under a new passphrase
+func encryptKeypair(kp Keypair, passphraseFile string) Keypair {
+	sk, err := kp.SecretKey("")
+	if err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+
+	passphrase, err := readPassphrase(passphraseFile, true, "New passphrase: ")
+	if err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+	if passphrase == "" {
+		log.Fatal("[ERROR] Passphrase must not be empty")
+	}
+	if os.Getenv(passphraseEnv) == "" && passphraseFile == "" {
+		confirm, err := readPassphrase("", true, "Repeat passphrase: ")
+		if err != nil {
+			log.Fatalf("[ERROR] %v", err)
+		}
+		if confirm != passphrase {
+			log.Fatal("[ERROR] Passphrases do not match")
+		}
+	}
+
+	ncryptsec, err := nip49.Encrypt(sk, passphrase, ncryptsecLogN, nip49.ClientDoesNotTrackThisData)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to encrypt private key: %v", err)
+	}
+	return Keypair{Ncryptsec: ncryptsec, Npub: kp.Npub}
+}
+
+// replaceKeypair backs up any existing keys.json and saves kp in its place
+func replaceKeypair(configDir string, kp Keypair) {
+	keyPath := filepath.Join(configDir, "keys.json")
+	if data, err := os.ReadFile(keyPath); err == nil {
+		backup := filepath.Join(configDir, fmt.Sprintf("keys-%s.json.bak", time.Now().UTC().Format("20060102-150405")))
+		if err := writeFileAtomic(backup, data, 0600); err != nil {
+			log.Fatalf("[ERROR] Failed to back up %s: %v", keyPath, err)
+		}
+		log.Printf("[INFO] Previous keys.json backed up to %s", backup)
+	}
+
+	if err := saveKeypair(configDir, kp); err != nil {
+		log.Fatalf("[ERROR] Failed to save keys.json: %v", err)
+	}
+}
+
+// keysRotate announces that the current key is rotating to the key stored in another
+// config directory. The announcement is signed by the current key and countersigned by the new one.
+func keysRotate(configDir, passphraseFile string, args []string) {
+	var newConfigDir string
+
+	flagSet := flag.NewFlagSet("keys rotate", flag.ExitOnError)
+	flagSet.StringVar(&newConfigDir, "new-config-dir", "", "Config directory holding the new key in keys.json (generated if missing)")
+	flagSet.Parse(args)
+
+	if newConfigDir == "" {
+		log.Fatal("[ERROR] Usage: qube-manager keys rotate -new-config-dir <dir>")
+	}
+
+	_, oldSk := loadSecretKey(configDir, passphraseFile, true)
+	newKp, newSk := loadSecretKey(newConfigDir, passphraseFile, true)
+	if oldSk == newSk {
+		log.Fatal("[ERROR] New key is the same as the current key")
+	}
+
+	cfg := loadConfig(configDir)
+	signer, err := newSigner(context.Background(), oldSk, cfg.Bunker)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to set up signer: %v", err)
+	}
+	oldPk, err := signer.GetPublicKey(context.Background())
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to get public key: %v", err)
+	}
+
+	ev, _, err := buildRotationAnnouncement(oldPk, newSk)
+	if err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+	if err := signEvent(context.Background(), signer, &ev); err != nil {
+		log.Fatalf("[ERROR] Failed to sign rotation announcement: %v", err)
+	}
+
+	if publishEvent(cfg.Relays, signer, ev) == 0 {
+		log.Fatal("[ERROR] Rotation announcement was not accepted by any relay")
+	}
+
+	log.Printf("[INFO] Announced rotation to %s", newKp.Npub)
+	if cfg.RotationQuorum > 0 {
+		log.Printf("[INFO] Nodes apply the rotation once %d other follow(s) run: qube-manager keys endorse-rotation -old <your current npub> -new %s",
+			cfg.RotationQuorum, newKp.Npub)
+	}
+	log.Printf("[INFO] Publish future signals with: qube-manager --config-dir %s send-message ...", newConfigDir)
+}
+
+// keysEndorseRotation endorses another follow's announced key rotation
+func keysEndorseRotation(configDir, passphraseFile string, args []string) {
+	var oldNpub, newNpub string
+
+	flagSet := flag.NewFlagSet("keys endorse-rotation", flag.ExitOnError)
+	flagSet.StringVar(&oldNpub, "old", "", "npub of the key being rotated out")
+	flagSet.StringVar(&newNpub, "new", "", "npub of the replacement key")
+	flagSet.Parse(args)
+
+	keys, err := decodeNpubs([]string{oldNpub, newNpub})
+	if err != nil || oldNpub == "" || newNpub == "" {
+		log.Fatal("[ERROR] Usage: qube-manager keys endorse-rotation -old <npub> -new <npub>")
+	}
+
+	_, sk := loadSecretKey(configDir, passphraseFile, true)
+	cfg := loadConfig(configDir)
+	signer, err := newSigner(context.Background(), sk, cfg.Bunker)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to set up signer: %v", err)
+	}
+
+	ev := buildRotationEndorsement(keys[0], keys[1])
+	if err := signEvent(context.Background(), signer, &ev); err != nil {
+		log.Fatalf("[ERROR] Failed to sign rotation endorsement: %v", err)
+	}
+
+	if publishEvent(cfg.Relays, signer, ev) == 0 {
+		log.Fatal("[ERROR] Rotation endorsement was not accepted by any relay")
+	}
+	log.Printf("[INFO] Endorsed rotation %s -> %s", oldNpub, newNpub)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEncryptedKeypair(t *testing.T) {
+	t.Setenv(passphraseEnv, "")
+	dir := t.TempDir()
+	passFile := filepath.Join(dir, "passphrase")
+	os.WriteFile(passFile, []byte("correct horse\n"), 0600)
+
+	kp := generateKeypair()
+	sk, err := kp.SecretKey("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enc := encryptKeypair(kp, passFile)
+	if !enc.Encrypted() || enc.Nsec != "" || enc.Npub != kp.Npub {
+		t.Fatalf("encrypted keypair = %+v, want only ncryptsec and npub", enc)
+	}
+
+	// The passphrase file is read without its trailing newline
+	passphrase, err := readPassphrase(passFile, false, "")
+	if err != nil || passphrase != "correct horse" {
+		t.Fatalf("readPassphrase = %q, %v", passphrase, err)
+	}
+	if got, err := enc.SecretKey(passphrase); err != nil || got != sk {
+		t.Errorf("SecretKey(correct passphrase) = %s, %v", got, err)
+	}
+	for _, wrong := range []string{"", "correct horse\n", "Correct horse"} {
+		if _, err := enc.SecretKey(wrong); err == nil || !strings.Contains(err.Error(), "wrong passphrase") {
+			t.Errorf("SecretKey(%q) = %v, want a wrong passphrase error", wrong, err)
+		}
+	}
+
+	// The environment takes precedence over the file; without either a daemon cannot unlock
+	t.Setenv(passphraseEnv, "from env")
+	if p, _ := readPassphrase(passFile, false, ""); p != "from env" {
+		t.Errorf("readPassphrase = %q, want the environment's passphrase", p)
+	}
+	t.Setenv(passphraseEnv, "")
+	if _, err := readPassphrase("", false, ""); err == nil {
+		t.Error("readPassphrase without a source succeeded non-interactively")
+	}
+}
+
+func TestCorruptKeypairNotReplaced(t *testing.T) {
+	kp := generateKeypair()
+	for name, contents := range map[string]string{
+		"truncated json":     `{"nsec": "` + kp.Nsec[:20],
+		"missing secret key": `{"npub": "` + kp.Npub + `"}`,
+		"missing npub":       `{"nsec": "` + kp.Nsec + `"}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			keyPath := filepath.Join(dir, "keys.json")
+			if err := os.WriteFile(keyPath, []byte(contents), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, ok, err := openKeypair(dir); err == nil || ok {
+				t.Errorf("openKeypair = ok %v, %v, want an error", ok, err)
+			}
+			got, created, err := openOrCreateKeypair(dir)
+			if err == nil || created {
+				t.Fatalf("openOrCreateKeypair = %+v (created %v), want an error", got, created)
+			}
+			if !strings.Contains(err.Error(), "keys.json") {
+				t.Errorf("error %q does not name the file", err)
+			}
+
+			data, err := os.ReadFile(keyPath)
+			if err != nil || string(data) != contents {
+				t.Errorf("keys.json = %q, %v, want it unchanged", data, err)
+			}
+			if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+				t.Errorf("config directory has %d files, want only keys.json", len(entries))
+			}
+		})
+	}
+
+	// Only a missing file is replaced by a new identity
+	dir := t.TempDir()
+	created, isNew, err := openOrCreateKeypair(dir)
+	if err != nil || !isNew {
+		t.Fatalf("openOrCreateKeypair on an empty directory = %v, %v", isNew, err)
+	}
+	again, isNew, err := openOrCreateKeypair(dir)
+	if err != nil || isNew || again != created {
+		t.Errorf("second openOrCreateKeypair = %+v (created %v), %v, want the saved keypair", again, isNew, err)
+	}
+}
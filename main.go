@@ -5,28 +5,27 @@ import (
 	"flag"
 	"fmt"
 	"log"
-	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
-	"sync"
+	"slices"
 	"syscall"
 	"time"
 
 	"github.com/Masterminds/semver/v3"
 	"github.com/nbd-wtf/go-nostr"
-	"github.com/nbd-wtf/go-nostr/nip19"
 )
 
 // CandidateAction holds details of a potential action to perform
 type CandidateAction struct {
-	Version        *semver.Version // Parsed semantic version
-	Type           string          // "upgrade" or "reboot"
-	Key            string          // Unique history key
-	Genesis        string          // Genesis URL for reboot, empty for upgrade
-	Hash           string          // SHA256 hash of binary
-	Network        string          // Network identifier (e.g., "hqz")
-	OriginalPubkey string          // Pubkey of dev who issued the signal (for kind=3333 reference)
+	Version        *semver.Version    // Parsed semantic version
+	Type           string             // "upgrade" or "reboot"
+	Key            string             // Unique history key
+	Genesis        string             // Genesis URL for reboot, empty for upgrade
+	Hash           string             // SHA256 hash of binary
+	Network        string             // Network identifier (e.g., "hqz")
+	OriginalPubkey string             // Pubkey of dev who issued the signal (for kind=3333 reference)
+	ReleaseSigs    []ReleaseSignature // Detached release signatures over Hash collected from signals
 }
 
 // getTagValue returns the value of the first tag with the given name, or empty string if not found
@@ -49,19 +48,42 @@ func hasTag(event *nostr.Event, tagName string) bool {
 	return false
 }
 
-// checkAndExecuteQuorum checks if any action has reached quorum and executes it
-// This function is called periodically by the quorum check ticker
-func checkAndExecuteQuorum(
-	actionsMux *sync.RWMutex,
-	actions map[string]*CandidateAction,
-	votes map[string]map[string]bool,
-	config *Config,
-	history *History,
-	keypair *Keypair,
-	dryRun bool,
-) {
-	actionsMux.Lock()
-	defer actionsMux.Unlock()
+// meetsQuorum reports whether votes reach the configured quorum. A follow list anchor's
+// list is trusted without a quorum of its own, so with an anchor at least one vote must
+// come from a config follow: a single compromised anchor key cannot reach quorum with
+// keys it listed itself.
+func meetsQuorum(votes map[string]bool, config *Config, follows *followSet) bool {
+	if len(votes) < config.Quorum {
+		return false
+	}
+	if config.FollowListAnchor == "" {
+		return true
+	}
+	static := follows.Effective(nil)
+	for pk := range votes {
+		if static[pk] {
+			return true
+		}
+	}
+	return false
+}
+
+// quorumActionLocked selects the highest version action that reached quorum and is not
+// in history, and returns it with the counted votes per action. The caller holds state.mu.
+func quorumActionLocked(state *signalState, config *Config, history *History, follows *followSet) (*CandidateAction, map[string]map[string]bool) {
+	actions := state.actions
+
+	// Only votes from the current effective follows count; a rotated-out key no longer votes
+	trusted := follows.Snapshot()
+	votes := make(map[string]map[string]bool, len(state.votes))
+	for key, vset := range state.votes {
+		votes[key] = make(map[string]bool, len(vset))
+		for pk := range vset {
+			if trusted[pk] {
+				votes[key][pk] = true
+			}
+		}
+	}
 
 	// Select the latest semver action meeting quorum and not already in history
 	var latest *CandidateAction
@@ -79,94 +101,181 @@ func checkAndExecuteQuorum(
 			log.Printf("[DEBUG] Action %s has %d/%d votes (below quorum)", a.Key, voteCount, config.Quorum)
 			continue
 		}
+		if !meetsQuorum(votes[a.Key], config, follows) {
+			log.Printf("[DEBUG] Action %s has %d/%d votes, but none from a config follow (follow_list_anchor)",
+				a.Key, voteCount, config.Quorum)
+			continue
+		}
 
 		if latest == nil || a.Version.GreaterThan(latest.Version) {
 			latest = a
 		}
 	}
 
+	return latest, votes
+}
+
+// signRetryDelay is how long an action waits before its status event is signed again
+// after signing failed, e.g. because a remote signer did not approve it in time
+const signRetryDelay = 5 * time.Minute
+
+// checkAndExecuteQuorum checks if any action has reached quorum and executes it
+// It is called on every quorum check tick and whenever a vote arrives. state.mu is only
+// held while the action is selected, so a slow remote signer never blocks ingestion.
+func checkAndExecuteQuorum(
+	state *signalState,
+	config *Config,
+	history *History,
+	follows *followSet,
+	outbox *statusOutbox,
+	signer nostr.Signer,
+	clk clock,
+	dryRun bool,
+) {
+	state.mu.Lock()
+	latest, votes := quorumActionLocked(state, config, history, follows)
+	if latest != nil && clk.Now().Before(state.signRetryAt[latest.Key]) {
+		log.Printf("[DEBUG] Action %s waits until %s to sign its status event again",
+			latest.Key, state.signRetryAt[latest.Key].UTC().Format(time.RFC3339))
+		latest = nil
+	}
+	var action CandidateAction
+	var voters []string
+	if latest != nil {
+		// A copy, as ingestion keeps adding release signatures once the lock is released
+		action = *latest
+		action.ReleaseSigs = slices.Clone(latest.ReleaseSigs)
+		voters = voterNpubs(votes[latest.Key])
+	}
+	state.mu.Unlock()
 	if latest == nil {
 		return // No action meeting quorum
 	}
 
 	log.Printf("[INFO] Selected action %s with version %s and %d votes",
-		latest.Key, latest.Version.Original(), len(votes[latest.Key]))
+		action.Key, action.Version.Original(), len(voters))
+	startedAt := clk.Now()
+
+	// Verify the detached release signature before acting on the binary hash
+	if len(config.ReleaseKeys) > 0 {
+		trusted := trustedReleaseKeys(config, follows)
+		if err := verifyReleaseSignature(action.Hash, action.ReleaseSigs, trusted); err != nil {
+			log.Printf("[ERROR] Refusing action %s: release signature verification failed: %v", action.Key, err)
+			return
+		}
+		log.Printf("[INFO] Release signature verified for action %s", action.Key)
+	}
 
-	switch latest.Type {
+	switch action.Type {
 	case "upgrade":
-		log.Printf("[UPGRADE ACTION] Version: %s", latest.Version.Original())
+		log.Printf("[UPGRADE ACTION] Version: %s", action.Version.Original())
 	case "reboot":
-		log.Printf("[REBOOT ACTION] Version: %s Genesis: %s", latest.Version.Original(), latest.Genesis)
+		log.Printf("[REBOOT ACTION] Version: %s Genesis: %s", action.Version.Original(), action.Genesis)
 	}
 
 	if !dryRun {
 		// Build kind=3333 QubeManager status event
 		// Use config values for network and node_id
 		tags := nostr.Tags{
-			{"a", fmt.Sprintf("33321:%s:hyperqube", latest.OriginalPubkey)},
-			{"p", latest.OriginalPubkey},
-			{"version", latest.Version.Original()},
+			{"a", fmt.Sprintf("33321:%s:hyperqube", action.OriginalPubkey)},
+			{"p", action.OriginalPubkey},
+			{"version", action.Version.Original()},
 			{"network", config.Network},
-			{"action", latest.Type},
+			{"action", action.Type},
 			{"status", "success"},
 			{"node_id", config.NodeID},
-			{"action_at", fmt.Sprintf("%d", time.Now().Unix())},
+			{"action_at", fmt.Sprintf("%d", startedAt.Unix())},
+		}
+		// Reboots of the same version to different genesis files are different actions
+		if action.Type == "reboot" {
+			tags = append(tags, nostr.Tag{"genesis_url", action.Genesis})
 		}
 
 		// Build human-readable content
 		content := fmt.Sprintf("[qube-manager] The %s to version %s has been successful on node %s.",
-			latest.Type, latest.Version.Original(), config.NodeID)
+			action.Type, action.Version.Original(), config.NodeID)
 
 		doneEvent := nostr.Event{
-			PubKey:    keypair.Npub,
-			CreatedAt: nostr.Timestamp(time.Now().Unix()),
+			CreatedAt: nostr.Timestamp(startedAt.Unix()),
 			Kind:      3333,
 			Tags:      tags,
 			Content:   content,
 		}
 
-		_, priv, err := nip19.Decode(keypair.Nsec)
-		if err != nil {
-			log.Printf("[ERROR] Invalid private key: %v", err)
-			return
-		}
-
-		if err := doneEvent.Sign(priv.(string)); err != nil {
-			log.Printf("[ERROR] Error signing status event: %v", err)
+		if err := signEvent(context.Background(), signer, &doneEvent); err != nil {
+			retryAt := clk.Now().Add(signRetryDelay)
+			log.Printf("[ERROR] Error signing status event for action %s, retrying at %s: %v",
+				action.Key, retryAt.UTC().Format(time.RFC3339), err)
+			state.mu.Lock()
+			state.signRetryAt[action.Key] = retryAt
+			state.mu.Unlock()
 			return
 		}
 
-		log.Printf("[INFO] Publishing kind=3333 status event for action %s to %d relays", latest.Key, len(config.Relays))
-
-		for _, r := range config.Relays {
-			go func(url string) {
-				log.Printf("[INFO] Publishing to relay %s", url)
-				if relay, err := nostr.RelayConnect(context.Background(), url); err == nil {
-					_ = relay.Publish(context.Background(), doneEvent)
-				} else {
-					log.Printf("[WARN] Relay publish error (%s): %v", url, err)
-				}
-			}(r)
-		}
-
-		history.Add(latest.Key)
+		log.Printf("[INFO] Queueing kind=3333 status event for action %s to %d relays", action.Key, len(config.Relays))
+
+		// The entry is added in memory first, so relay acknowledgements can be recorded on
+		// it, but saved only after the status event is persisted in the outbox: a crash in
+		// between must not leave an executed action whose event is never delivered
+		history.Add(action.Key, HistoryEntry{
+			ExecutedAt: startedAt.UTC().Truncate(time.Second),
+			Type:       action.Type,
+			Version:    action.Version.Original(),
+			Hash:       action.Hash,
+			Genesis:    action.Genesis,
+			Network:    action.Network,
+			Voters:     voters,
+			Status:     "success",
+			Duration:   clk.Now().Sub(startedAt),
+			Events:     []string{doneEvent.ID},
+		})
+		outbox.enqueue(action.Key, doneEvent, config.Relays)
 		if err := history.Save(); err != nil {
 			log.Printf("[WARN] Error saving history: %v", err)
 		} else {
-			log.Printf("[INFO] Action %s saved to history", latest.Key)
+			log.Printf("[INFO] Action %s saved to history", action.Key)
 		}
 	} else {
 		log.Println("[INFO] Dry run - not saving action to history.")
 	}
 }
 
+// Reasons consumeEvents returns for the caller to resubscribe
+const (
+	streamFollowsChanged = "follow set changed"
+	streamEnded          = "event stream ended"
+)
+
+// consumeEvents passes events to handle until the stream ends, ctx is cancelled or
+// changed is closed. Returns why the caller should resubscribe, or "" once ctx is cancelled.
+func consumeEvents(ctx context.Context, events <-chan nostr.RelayEvent, changed <-chan struct{}, handle func(nostr.RelayEvent)) string {
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("[INFO] Context cancelled, stopping event processing")
+			return ""
+		case <-changed:
+			return streamFollowsChanged
+		case relayEvent, ok := <-events:
+			if !ok {
+				if ctx.Err() != nil {
+					return ""
+				}
+				return streamEnded
+			}
+			handle(relayEvent)
+		}
+	}
+}
+
 func main() {
 	// Command-line flags
 	var (
-		dryRun     = flag.Bool("dry-run", false, "Perform a trial run without saving actions")
-		configDir  = flag.String("config-dir", filepath.Join(os.Getenv("HOME"), ".qube-manager"), "Configuration directory")
-		verbose    = flag.Bool("verbose", false, "Enable verbose logging including go-nostr logs")
+		dryRun      = flag.Bool("dry-run", false, "Perform a trial run without saving actions")
+		configDir   = flag.String("config-dir", filepath.Join(os.Getenv("HOME"), ".qube-manager"), "Configuration directory")
+		verbose     = flag.Bool("verbose", false, "Enable verbose logging including go-nostr logs")
 		showVersion = flag.Bool("version", false, "Show version information and exit")
+		passFile    = flag.String("passphrase-file", "", "File containing the passphrase for an encrypted (NIP-49) private key")
 	)
 	flag.Parse()
 
@@ -184,6 +293,38 @@ func main() {
 		log.Printf("[INFO] Ensured config directory exists at %s", *configDir)
 	}
 
+	// Operator commands that print results to stdout run before file logging is
+	// set up, so their output is not interleaved with log lines (logs go to stderr)
+	switch flag.Arg(0) {
+	case "history":
+		historyCLI(*configDir, flag.Args()[1:])
+		return
+	case "keys":
+		keysCLI(*configDir, *passFile, flag.Args()[1:])
+		return
+	case "relays":
+		relaysCLI(*configDir)
+		return
+	case "export-signals":
+		exportSignalsCLI(*configDir, flag.Args()[1:])
+		return
+	case "import-signals":
+		importSignalsCLI(*configDir, flag.Args()[1:])
+		return
+	case "export-status":
+		exportStatusCLI(*configDir, flag.Args()[1:])
+		return
+	case "replay":
+		replayCLI(*configDir, *verbose, flag.Args()[1:])
+		return
+	case "simulate":
+		simulateCLI(*configDir, *verbose, flag.Args()[1:])
+		return
+	case "fleet":
+		fleetCLI(*configDir, flag.Args()[1:])
+		return
+	}
+
 	// Setup logging to file and stdout
 	setupLogging(*configDir)
 
@@ -194,30 +335,32 @@ func main() {
 		log.Println("[INFO] Verbose logging enabled")
 	}
 
-	log.Println("[INFO] Loading or creating keypair")
-	keypair := loadOrCreateKeypair(*configDir)
-	_, _, err := nip19.Decode(keypair.Nsec)
-	if err != nil {
-		log.Fatalf("[ERROR] Invalid private key in config: %v", err)
-	}
-
 	// Suppress go-nostr info logs like "filter doesn't match"
 	configureNostrLogging(*verbose)
 	log.Println("[INFO] Nostr logging configured")
 
-	if len(os.Args) > 1 && os.Args[1] == "send-message" {
+	switch flag.Arg(0) {
+	case "send-message":
 		log.Println("[INFO] Handling 'send-message' command")
-		sendMessageCLI(*configDir)
+		sendMessageCLI(*configDir, *passFile, flag.Args()[1:])
+		return
+	case "sign-release":
+		log.Println("[INFO] Handling 'sign-release' command")
+		signReleaseCLI(*configDir, *passFile, flag.Args()[1:])
+		return
+	case "follow-list":
+		log.Println("[INFO] Handling 'follow-list' command")
+		followListCLI(*configDir, *passFile, flag.Args()[1:])
+		return
+	case "publish-status":
+		log.Println("[INFO] Handling 'publish-status' command")
+		publishStatusCLI(*configDir, *passFile, flag.Args()[1:])
+		return
+	case "proposal-status":
+		proposalStatusCLI(*configDir, *passFile, flag.Args()[1:])
 		return
 	}
 
-	// Load configuration and history from files
-	config := loadConfig(*configDir)
-	history := loadHistory(*configDir)
-
-	log.Printf("[INFO] Loaded config: %d relays, %d follows, quorum=%d",
-		len(config.Relays), len(config.Follows), config.Quorum)
-
 	// Context for graceful shutdown (no timeout - long-running daemon)
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -231,231 +374,11 @@ func main() {
 		cancel()
 	}()
 
-	// Map to hold candidate actions keyed by unique history keys
-	actions := make(map[string]*CandidateAction)
-
-	// Map of action key -> set of pubkeys that voted for this action
-	votes := make(map[string]map[string]bool)
-
-	// Track latest signal from each dev for single active message model
-	// Map: dev_pubkey -> latest created_at timestamp
-	latestSignal := make(map[string]nostr.Timestamp)
-
-	// Track which action key each dev's latest signal created
-	// Map: dev_pubkey -> action_key
-	signalActionMap := make(map[string]string)
-
-	// Mutex for thread-safe access to actions and votes maps
-	var actionsMux sync.RWMutex
-
-	// Start periodic quorum check ticker (runs every 60 seconds)
-	ticker := time.NewTicker(60 * time.Second)
-	defer ticker.Stop()
-
-	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				log.Printf("[DEBUG] Running periodic quorum check...")
-				checkAndExecuteQuorum(&actionsMux, actions, votes, &config, history, &keypair, *dryRun)
-			case <-ctx.Done():
-				log.Printf("[INFO] Quorum checker goroutine shutting down")
-				return
-			}
-		}
-	}()
-
-	log.Printf("[INFO] Started quorum check ticker (interval: 60s)")
-
-	// Decode all npubs to hex pubkeys for filtering
-	hexFollows := make([]string, 0, len(config.Follows))
-	for _, npub := range config.Follows {
-		kind, pubkeyAny, err := nip19.Decode(npub)
-		if err != nil {
-			log.Printf("[WARN] Skipping invalid npub (%s): %v", npub, err)
-			continue
-		}
-		if kind != "npub" {
-			log.Printf("[WARN] Expected npub but got %s: %s", kind, npub)
-			continue
-		}
-		pubkey, ok := pubkeyAny.(string)
-		if !ok {
-			log.Printf("[WARN] Unexpected pubkey format for %s: %v", npub, pubkeyAny)
-			continue
-		}
-		hexFollows = append(hexFollows, pubkey)
-	}
-	log.Printf("[INFO] Decoded %d valid npubs for following", len(hexFollows))
-
-	// Create SimplePool without authentication (Qubestr allows unauthenticated reads and kind 3333 writes)
-	pool := nostr.NewSimplePool(ctx)
-
-	// Subscribe to kind=33321 (HyperSignal) events from followed pubkeys across all relays
-	filters := nostr.Filters{{
-		Authors: hexFollows,
-		Kinds:   []int{33321},
-		Tags:    nostr.TagMap{"d": []string{"hyperqube"}},
-	}}
-
-	log.Printf("[INFO] Subscribing to %d relay(s) for kind=33321 events", len(config.Relays))
-	events := pool.SubMany(ctx, config.Relays, filters)
-
-	// Read events from all relays
-	for relayEvent := range events {
-		select {
-		case <-ctx.Done():
-			log.Printf("[INFO] Context cancelled, stopping event processing")
-			return
-		default:
-		}
-
-		ev := relayEvent.Event
-
-		// Validate required tags
-		dTag := getTagValue(ev, "d")
-		if dTag != "hyperqube" {
-			if *verbose {
-				log.Printf("[DEBUG] Skipping event with wrong d tag: %s", dTag)
-			}
-			continue
-		}
-
-		// Extract required tags
-		version := getTagValue(ev, "version")
-		hash := getTagValue(ev, "hash")
-		network := getTagValue(ev, "network")
-		action := getTagValue(ev, "action")
-
-		// Validate required tags are present
-		if version == "" || hash == "" || network == "" || action == "" {
-			if *verbose {
-				log.Printf("[DEBUG] Skipping event with missing required tags (version=%s, hash=%s, network=%s, action=%s)",
-					version, hash, network, action)
-			}
-			continue
-		}
-
-		// Network filtering: only process events for our configured network
-		if network != config.Network {
-			if *verbose {
-				log.Printf("[DEBUG] Skipping event for network %s (we are %s)",
-					network, config.Network)
-			}
-			continue
-		}
-
-		// Parse semantic version
-		v, err := semver.NewVersion(version)
-		if err != nil {
-			log.Printf("[WARN] Invalid semantic version: %s", version)
-			continue
-		}
-
-		// Lock for writing to actions/votes maps
-		actionsMux.Lock()
-
-		// Single active message model: Check if this is a newer signal from this dev
-		if prevTimestamp, exists := latestSignal[ev.PubKey]; exists {
-			if ev.CreatedAt > prevTimestamp {
-				// This is a newer signal from the same dev - clear old votes
-				if oldActionKey, hasOldAction := signalActionMap[ev.PubKey]; hasOldAction {
-					// Remove this dev's vote from the old action
-					if oldVotes, oldVotesExist := votes[oldActionKey]; oldVotesExist {
-						delete(oldVotes, ev.PubKey)
-						log.Printf("[INFO] Cleared vote from pubkey %s for old action %s (superseded by newer signal)",
-							ev.PubKey[:8]+"...", oldActionKey)
-					}
-				}
-			} else {
-				// This signal is older than what we've already seen from this dev - ignore it
-				actionsMux.Unlock()
-				if *verbose {
-					log.Printf("[DEBUG] Ignoring older signal from pubkey %s (timestamp %d < %d)",
-						ev.PubKey[:8]+"...", ev.CreatedAt, prevTimestamp)
-				}
-				continue
-			}
-		}
-
-		switch action {
-		case "upgrade":
-			key := fmt.Sprintf("upgrade:%s", v.Original())
-			actionStruct, exists := actions[key]
-			if !exists {
-				actionStruct = &CandidateAction{
-					Type:           "upgrade",
-					Version:        v,
-					Key:            key,
-					Hash:           hash,
-					Network:        network,
-					OriginalPubkey: ev.PubKey,
-				}
-				actions[key] = actionStruct
-			}
-
-			if votes[key] == nil {
-				votes[key] = make(map[string]bool)
-			}
-			votes[key][ev.PubKey] = true
-
-			// Update tracking for single active message model
-			latestSignal[ev.PubKey] = ev.CreatedAt
-			signalActionMap[ev.PubKey] = key
-
-			log.Printf("[INFO] Parsed upgrade signal: version=%s network=%s hash=%s pubkey=%s",
-				v.Original(), network, hash[:8]+"...", ev.PubKey[:8]+"...")
-
-		case "reboot":
-			genesisURL := getTagValue(ev, "genesis_url")
-			if genesisURL == "" {
-				actionsMux.Unlock()
-				log.Printf("[WARN] Reboot action missing genesis_url tag")
-				continue
-			}
-
-			if _, err := url.ParseRequestURI(genesisURL); err != nil {
-				actionsMux.Unlock()
-				log.Printf("[WARN] Invalid genesis URL in reboot: %s", genesisURL)
-				continue
-			}
-
-			key := fmt.Sprintf("reboot:%s:%s", v.Original(), genesisURL)
-			actionStruct, exists := actions[key]
-			if !exists {
-				actionStruct = &CandidateAction{
-					Type:           "reboot",
-					Version:        v,
-					Key:            key,
-					Genesis:        genesisURL,
-					Hash:           hash,
-					Network:        network,
-					OriginalPubkey: ev.PubKey,
-				}
-				actions[key] = actionStruct
-			}
-
-			if votes[key] == nil {
-				votes[key] = make(map[string]bool)
-			}
-			votes[key][ev.PubKey] = true
-
-			// Update tracking for single active message model
-			latestSignal[ev.PubKey] = ev.CreatedAt
-			signalActionMap[ev.PubKey] = key
-
-			log.Printf("[INFO] Parsed reboot signal: version=%s network=%s genesis=%s hash=%s pubkey=%s",
-				v.Original(), network, genesisURL, hash[:8]+"...", ev.PubKey[:8]+"...")
-
-		default:
-			if *verbose {
-				log.Printf("[DEBUG] Ignoring event with unknown action type: %s", action)
-			}
-		}
-
-		actionsMux.Unlock()
-	}
-
-	log.Printf("[INFO] Event stream ended")
+	runDaemon(ctx, daemonOptions{
+		configDir: *configDir,
+		passFile:  *passFile,
+		dryRun:    *dryRun,
+		verbose:   *verbose,
+	})
 	log.Printf("[INFO] Qube Manager shutting down cleanly")
 }
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/keyer"
+)
+
+// gatedSigner signs like a remote signer awaiting approval: each SignEvent call waits
+// for a result on approve, and signs with the inner key when that result is nil
+type gatedSigner struct {
+	nostr.Signer
+	started chan struct{}
+	approve chan error
+}
+
+func (s *gatedSigner) SignEvent(ctx context.Context, ev *nostr.Event) error {
+	s.started <- struct{}{}
+	if err := <-s.approve; err != nil {
+		return err
+	}
+	return s.Signer.SignEvent(ctx, ev)
+}
+
+func TestCheckAndExecuteQuorumSignsOutsideLock(t *testing.T) {
+	devs := newTestDevs(3)
+	config := Config{Quorum: 2, Network: "hqz", NodeID: "test-node", MaxClockSkew: defaultMaxClockSkew}
+	follows := newFollowSet([]string{devs[0].pk, devs[1].pk, devs[2].pk})
+	state := newSignalState()
+	dir := t.TempDir()
+	history := loadHistory(dir, realClock{})
+	outbox := loadOutbox(dir, history, newFakeOutboxRelays(), realClock{})
+	key, err := keyer.NewPlainKeySigner(nostr.GeneratePrivateKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := &gatedSigner{Signer: key, started: make(chan struct{}, 1), approve: make(chan error)}
+
+	vote := func(d testDev, version string) {
+		ev := d.signal(t, version, "hqz", nostr.Now())
+		if r := state.ingest(&ev, &config, follows.Snapshot(), time.Now()); r != nil {
+			t.Fatalf("vote rejected: %s", r.Detail)
+		}
+	}
+	vote(devs[0], "1.1.0")
+	vote(devs[1], "1.1.0")
+
+	check := func() chan struct{} {
+		done := make(chan struct{})
+		go func() {
+			checkAndExecuteQuorum(state, &config, history, follows, outbox, signer, realClock{}, false)
+			close(done)
+		}()
+		return done
+	}
+
+	// While the signature is pending, signals are still ingested
+	done := check()
+	<-signer.started
+	vote(devs[2], "2.0.0")
+
+	// A refused signature is not retried before signRetryDelay
+	signer.approve <- errors.New("not approved in time")
+	<-done
+	if history.Has("upgrade:1.1.0") {
+		t.Fatal("action recorded without a signed status event")
+	}
+	if retryAt := state.signRetryAt["upgrade:1.1.0"]; time.Until(retryAt) < signRetryDelay-time.Minute {
+		t.Fatalf("retry at %s, want about %s from now", retryAt, signRetryDelay)
+	}
+	<-check()
+	select {
+	case <-signer.started:
+		t.Fatal("status event signed again before the retry delay")
+	default:
+	}
+
+	state.signRetryAt["upgrade:1.1.0"] = time.Now().Add(-time.Second)
+	done = check()
+	<-signer.started
+	signer.approve <- nil
+	<-done
+	if !history.Has("upgrade:1.1.0") {
+		t.Error("action not recorded once its status event was signed")
+	}
+	if saved := loadOutbox(dir, nil, nil, realClock{}); saved.pending() != 1 {
+		t.Errorf("outbox.json has %d events, want the status event persisted", saved.pending())
+	}
+}
+
+func TestCheckAndExecuteQuorumUsesClock(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir := t.TempDir()
+	clk := newFakeClock(time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC))
+	devs := newTestDevs(3)
+	var pubkeys []string
+	for _, d := range devs {
+		pubkeys = append(pubkeys, d.pk)
+	}
+
+	config := Config{Quorum: 3, Network: "hqz", NodeID: "test-node", MaxClockSkew: defaultMaxClockSkew}
+	follows := newFollowSet(pubkeys)
+	state := newSignalState()
+	history := loadHistory(dir, realClock{})
+	outbox := loadOutbox(dir, history, newRelayManager(ctx, nil, time.Minute, defaultMaxClockSkew, clk), clk)
+	signer, err := newSigner(ctx, nostr.GeneratePrivateKey(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vote := func(d testDev) {
+		ev := d.signal(t, "1.1.0", "hqz", nostr.Timestamp(clk.Now().Unix()))
+		if r := state.ingest(&ev, &config, follows.Snapshot(), clk.Now()); r != nil {
+			t.Fatalf("vote rejected: %s", r.Detail)
+		}
+	}
+
+	vote(devs[0])
+	vote(devs[1])
+	checkAndExecuteQuorum(state, &config, history, follows, outbox, signer, clk, false)
+	if history.Has("upgrade:1.1.0") {
+		t.Fatal("action executed below quorum")
+	}
+
+	clk.Advance(90 * time.Second)
+	vote(devs[2])
+	checkAndExecuteQuorum(state, &config, history, follows, outbox, signer, clk, false)
+
+	entry := history.Entries["upgrade:1.1.0"]
+	if entry == nil {
+		t.Fatal("action not executed at quorum")
+	}
+	if !entry.ExecutedAt.Equal(clk.Now()) {
+		t.Errorf("executed_at = %s, want %s", entry.ExecutedAt, clk.Now())
+	}
+	if entry.Duration != 0 {
+		t.Errorf("duration = %s, want 0 with a stopped clock", entry.Duration)
+	}
+
+	if outbox.pending() != 1 {
+		t.Fatalf("outbox has %d events, want 1", outbox.pending())
+	}
+	ev := outbox.Entries[0].Event
+	if ev.CreatedAt != nostr.Timestamp(clk.Now().Unix()) {
+		t.Errorf("status event created_at = %d, want %d", ev.CreatedAt, clk.Now().Unix())
+	}
+	if got, want := getTagValue(&ev, "action_at"), fmt.Sprint(clk.Now().Unix()); got != want {
+		t.Errorf("action_at = %s, want %s", got, want)
+	}
+}
@@ -5,12 +5,12 @@ import (
 	"flag"
 	"fmt"
 	"log"
-	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/Masterminds/semver/v3"
 	"github.com/nbd-wtf/go-nostr"
-	"github.com/nbd-wtf/go-nostr/nip19"
 )
 
 // UpgradeMessage represents the "upgrade" message type
@@ -28,7 +28,71 @@ type RebootMessage struct {
 	ExtraData string `json:"extraData,omitempty"` // additional metadata or status
 }
 
-func sendMessageCLI(configDir string) {
+// binaryFlag collects repeated -binary [platform=]path flags
+type binaryFlag []string
+
+func (b *binaryFlag) String() string { return strings.Join(*b, ",") }
+
+func (b *binaryFlag) Set(value string) error {
+	*b = append(*b, value)
+	return nil
+}
+
+// binaryHash is the SHA256 hash of a release binary, optionally labelled with its platform
+type binaryHash struct {
+	Platform string
+	Path     string
+	Hash     string
+}
+
+// splitPlatform splits a [platform=]value flag. A '=' inside a path is not a label.
+func splitPlatform(spec string) (platform, value string) {
+	if platform, value, ok := strings.Cut(spec, "="); ok && !strings.ContainsRune(platform, filepath.Separator) {
+		return platform, value
+	}
+	return "", spec
+}
+
+// hashBinaries computes the hash of each [platform=]path. A -hash, given as [platform=]hash,
+// must agree with every binary it covers: all of them when unlabelled, otherwise the binary
+// of that platform. The binary it names is listed first, since nodes verify the first hash tag.
+func hashBinaries(specs []string, hash string) ([]binaryHash, error) {
+	var hashes []binaryHash
+	for _, spec := range specs {
+		b := binaryHash{}
+		b.Platform, b.Path = splitPlatform(spec)
+		sum, err := fileSHA256(b.Path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", b.Path, err)
+		}
+		b.Hash = sum
+		hashes = append(hashes, b)
+	}
+
+	if hash == "" || len(hashes) == 0 {
+		return hashes, nil
+	}
+	platform, hash := splitPlatform(hash)
+	first := -1
+	for i, b := range hashes {
+		if platform != "" && b.Platform != platform {
+			continue
+		}
+		if b.Hash != hash {
+			return nil, fmt.Errorf("-hash %s does not match -binary %s (%s)", hash, b.Path, b.Hash)
+		}
+		if first < 0 {
+			first = i
+		}
+	}
+	if first < 0 {
+		return nil, fmt.Errorf("-hash is for platform %s, but no -binary has that platform", platform)
+	}
+	hashes[0], hashes[first] = hashes[first], hashes[0]
+	return hashes, nil
+}
+
+func sendMessageCLI(configDir, passphraseFile string, args []string) {
 	var (
 		msgType    string
 		version    string
@@ -36,18 +100,39 @@ func sendMessageCLI(configDir string) {
 		hash       string
 		network    string
 		requiredBy string
+		releaseSig string
+		bunkerURL  string
+		cosign     string
 		dryRun     bool
+		watch      bool
+		yes        bool
+		binaries   binaryFlag
 	)
 
 	flagSet := flag.NewFlagSet("send-message", flag.ExitOnError)
 	flagSet.StringVar(&msgType, "type", "", "Action type: 'upgrade' or 'reboot'")
 	flagSet.StringVar(&version, "version", "", "Semantic version (e.g. v1.2.3)")
-	flagSet.StringVar(&hash, "hash", "", "SHA256 hash of binary (required)")
+	flagSet.StringVar(&hash, "hash", "", "SHA256 hash of binary, as [platform=]hash with -binary (required unless -binary is given)")
+	flagSet.Var(&binaries, "binary", "Release binary to hash, as [platform=]path (repeatable, one per platform)")
 	flagSet.StringVar(&network, "network", "", "Network identifier (e.g. 'hqz', 'testnet')")
 	flagSet.StringVar(&genesis, "genesis", "", "Genesis URL (required for 'reboot')")
 	flagSet.StringVar(&requiredBy, "required-by", "", "Unix timestamp deadline (optional for 'reboot')")
+	flagSet.StringVar(&releaseSig, "release-sig", "", "Detached release signature as printed by sign-release (<pubkey>:<sig>)")
+	flagSet.StringVar(&bunkerURL, "bunker", "", "Sign with a remote NIP-46 bunker (bunker://...) instead of the local key (overrides config)")
 	flagSet.BoolVar(&dryRun, "dry-run", false, "Print event instead of sending")
-	flagSet.Parse(os.Args[2:])
+	flagSet.BoolVar(&watch, "watch", false, "After publishing, follow votes and node reports until interrupted")
+	flagSet.StringVar(&cosign, "cosign", "", "Vote for an existing proposal: republish the HyperSignal referenced by this nevent, naddr or note under your key")
+	flagSet.BoolVar(&yes, "yes", false, "With -cosign, publish without asking for confirmation")
+	flagSet.Parse(args)
+
+	// Co-signing copies every proposal field from the referenced signal
+	if cosign != "" {
+		if msgType != "" || version != "" || hash != "" || len(binaries) > 0 || network != "" || genesis != "" || requiredBy != "" || releaseSig != "" {
+			log.Fatal("[ERROR] -cosign takes the proposal from the referenced signal; do not combine it with -type, -version, -hash, -binary, -network, -genesis, -required-by or -release-sig")
+		}
+		cosignCLI(configDir, passphraseFile, cosign, bunkerURL, yes, dryRun, watch)
+		return
+	}
 
 	// Validate message type
 	if msgType != "upgrade" && msgType != "reboot" {
@@ -62,10 +147,31 @@ func sendMessageCLI(configDir string) {
 		log.Fatalf("[ERROR] Invalid semantic version '%s': %v", version, err)
 	}
 
-	// Validate required fields
-	if hash == "" {
-		log.Fatal("[ERROR] Hash is required (use --hash flag)")
+	// Validate the hash: nodes refuse any binary that does not match it exactly
+	if hash == "" && len(binaries) == 0 {
+		log.Fatal("[ERROR] Hash is required (use --hash or --binary flag)")
+	}
+	if hash != "" {
+		platform, h := splitPlatform(hash)
+		if platform != "" && len(binaries) == 0 {
+			log.Fatal("[ERROR] A platform-labelled -hash needs the -binary it is checked against")
+		}
+		if err := validateHash(h); err != nil {
+			log.Fatalf("[ERROR] Invalid hash '%s': %v", h, err)
+		}
+	}
+	hashes, err := hashBinaries(binaries, hash)
+	if err != nil {
+		log.Fatalf("[ERROR] Refusing to send: %v", err)
 	}
+	for _, b := range hashes {
+		log.Printf("[INFO] SHA256 of %s: %s", b.Path, b.Hash)
+	}
+	if len(hashes) > 0 {
+		hash = hashes[0].Hash
+	}
+
+	// Validate required fields
 	if network == "" {
 		log.Fatal("[ERROR] Network is required (use --network flag)")
 	}
@@ -75,14 +181,45 @@ func sendMessageCLI(configDir string) {
 		log.Fatal("[ERROR] Genesis URL is required for reboot messages (use --genesis flag)")
 	}
 
+	// One hash tag per distinct binary; nodes verify against the first
+	hashTags := nostr.Tags{{"hash", hash}}
+	if len(hashes) > 0 {
+		hashTags = nil
+		seen := make(map[string]bool)
+		for _, b := range hashes {
+			if seen[b.Hash] {
+				continue
+			}
+			seen[b.Hash] = true
+			tag := nostr.Tag{"hash", b.Hash}
+			if b.Platform != "" {
+				tag = append(tag, b.Platform)
+			}
+			hashTags = append(hashTags, tag)
+		}
+	}
+
 	// Build event tags based on action type
 	tags := nostr.Tags{
 		{"d", "hyperqube"},
 		{"version", version},
-		{"hash", hash},
+		hashTags[0],
 		{"network", network},
 		{"action", msgType},
 	}
+	tags = append(tags, hashTags[1:]...)
+
+	// Attach detached release signature over the hash, if provided
+	if releaseSig != "" {
+		rs, err := parseReleaseSigFlag(releaseSig)
+		if err != nil {
+			log.Fatalf("[ERROR] Invalid release signature: %v", err)
+		}
+		if err := verifyReleaseSignature(hash, []ReleaseSignature{rs}, []string{rs.Pubkey}); err != nil {
+			log.Fatalf("[ERROR] Release signature does not match hash: %v", err)
+		}
+		tags = append(tags, nostr.Tag{"release_sig", rs.Sig, rs.Pubkey})
+	}
 
 	// Add reboot-specific tags
 	if msgType == "reboot" {
@@ -112,62 +249,63 @@ func sendMessageCLI(configDir string) {
 		return
 	}
 
-	log.Printf("[INFO] Loading keypair from config directory: %s", configDir)
-	kp := loadOrCreateKeypair(configDir)
-	_, privKey, err := nip19.Decode(kp.Nsec)
-	if err != nil {
-		log.Fatalf("[ERROR] Invalid private key: %v", err)
-	}
+	cfg := loadConfig(configDir)
+	publishSignal(configDir, passphraseFile, bunkerURL, &cfg, tags, content, proposal{msgType, version, network, genesis}, watch)
+}
 
-	// Decode npub to hex pubkey
-	_, pubKeyHex, err := nip19.Decode(kp.Npub)
-	if err != nil {
-		log.Fatalf("[ERROR] Invalid public key: %v", err)
-	}
+// publishSignal signs a kind 33321 HyperSignal with tags and content under the local key or
+// bunker and publishes it to the configured relays, then optionally watches the proposal
+func publishSignal(configDir, passphraseFile, bunkerURL string, cfg *Config, tags nostr.Tags, content string, p proposal, watch bool) {
+	log.Printf("[INFO] Loading keypair from config directory: %s", configDir)
+	_, privKey := loadSecretKey(configDir, passphraseFile, true)
 
-	cfg := loadConfig(configDir)
 	if len(cfg.Relays) == 0 {
 		log.Println("[WARN] No relays configured; message will not be sent.")
 		return
 	}
+	if bunkerURL == "" {
+		bunkerURL = cfg.Bunker
+	}
+
+	signer, err := newSigner(context.Background(), privKey, bunkerURL)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to set up signer: %v", err)
+	}
 
 	// Create kind 33321 HyperSignal event
 	ev := nostr.Event{
-		PubKey:    pubKeyHex.(string),
 		CreatedAt: nostr.Timestamp(time.Now().Unix()),
 		Kind:      33321,
 		Tags:      tags,
 		Content:   content,
 	}
-	if err := ev.Sign(privKey.(string)); err != nil {
+	if bunkerURL != "" {
+		log.Println("[INFO] Waiting for the remote signer to approve the HyperSignal event")
+	}
+	if err := signEvent(context.Background(), signer, &ev); err != nil {
 		log.Fatalf("[ERROR] Failed to sign event: %v", err)
 	}
 
-	log.Printf("[INFO] Created HyperSignal event (kind 33321) for %s action, version %s", msgType, version)
+	log.Printf("[INFO] Created HyperSignal event (kind 33321) for %s action, version %s", p.Action, p.Version)
 
+	if publishEvent(cfg.Relays, signer, ev) > 0 && watch {
+		runProposalWatch(cfg, configDir, signer, p, true)
+	}
+}
+
+// publishEvent publishes a signed event to all relays, answering NIP-42 AUTH challenges
+// with signer, and returns the number of relays that accepted it
+func publishEvent(relays []string, signer nostr.Signer, ev nostr.Event) int {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	// Create auth handler that will respond to AUTH challenges from relay
-	authHandler := nostr.WithAuthHandler(func(authCtx context.Context, authEvent nostr.RelayEvent) error {
-		evt := authEvent.Event
-		log.Printf("[DEBUG] Relay %s requested auth, signing challenge", authEvent.Relay.URL)
-		log.Printf("[DEBUG] AUTH challenge tags: %v", evt.Tags)
-		if err := evt.Sign(privKey.(string)); err != nil {
-			log.Printf("[ERROR] Failed to sign AUTH event: %v", err)
-			return err
-		}
-		log.Printf("[INFO] Successfully signed AUTH event for %s", authEvent.Relay.URL)
-		return nil
-	})
-
 	// Use SimplePool with auth handler for proper NIP-42 support
-	pool := nostr.NewSimplePool(ctx, authHandler)
+	pool := nostr.NewSimplePool(ctx, signerAuthHandler(signer))
 
-	log.Printf("[INFO] Publishing message to %d relay(s)", len(cfg.Relays))
+	log.Printf("[INFO] Publishing message to %d relay(s)", len(relays))
 
 	// Publish to all relays
-	statuses := pool.PublishMany(ctx, cfg.Relays, ev)
+	statuses := pool.PublishMany(ctx, relays, ev)
 
 	successCount := 0
 	for status := range statuses {
@@ -179,5 +317,6 @@ func sendMessageCLI(configDir string) {
 		}
 	}
 
-	log.Printf("[INFO] Finished publishing message to %d/%d relays", successCount, len(cfg.Relays))
+	log.Printf("[INFO] Finished publishing message to %d/%d relays", successCount, len(relays))
+	return successCount
 }
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultNIP05Interval is how often NIP-05 identifiers of follows are re-resolved
+const defaultNIP05Interval = time.Hour
+
+// nip05Timeout bounds a single .well-known/nostr.json request
+const nip05Timeout = 10 * time.Second
+
+// nip05NameRegex matches the local part of a NIP-05 identifier
+var nip05NameRegex = regexp.MustCompile(`^[a-z0-9._+-]+$`)
+
+// FollowEntry is a trusted developer in config.yaml: an npub, a NIP-05 identifier,
+// or both, in which case the npub is checked against the identifier. Plain strings
+// are accepted for the single forms:
+//
+//	follows:
+//	  - npub1...
+//	  - george@zenon.org
+//	  - {npub: npub1..., nip05: george@zenon.org}
+type FollowEntry struct {
+	Npub  string `yaml:"npub,omitempty"`
+	NIP05 string `yaml:"nip05,omitempty"`
+}
+
+// UnmarshalYAML accepts a scalar npub or NIP-05 identifier, or a mapping with both
+func (f *FollowEntry) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		if strings.HasPrefix(value.Value, "npub1") {
+			*f = FollowEntry{Npub: value.Value}
+		} else {
+			*f = FollowEntry{NIP05: value.Value}
+		}
+		return nil
+	}
+
+	type plain FollowEntry
+	return value.Decode((*plain)(f))
+}
+
+// MarshalYAML writes the single forms back as plain strings
+func (f FollowEntry) MarshalYAML() (interface{}, error) {
+	switch {
+	case f.NIP05 == "":
+		return f.Npub, nil
+	case f.Npub == "":
+		return f.NIP05, nil
+	}
+	type plain FollowEntry
+	return plain(f), nil
+}
+
+// String describes the entry for log lines
+func (f FollowEntry) String() string {
+	switch {
+	case f.NIP05 == "":
+		return f.Npub
+	case f.Npub == "":
+		return f.NIP05
+	}
+	return f.Npub + " (" + f.NIP05 + ")"
+}
+
+// nip05URL returns the .well-known/nostr.json URL and local name for a NIP-05 identifier.
+// Plain http is only used for localhost, so identifiers can be tested against a local server.
+func nip05URL(identifier string) (string, string, error) {
+	name, domain := "_", identifier
+	if i := strings.LastIndex(identifier, "@"); i >= 0 {
+		name, domain = identifier[:i], identifier[i+1:]
+	}
+	name = strings.ToLower(name)
+
+	if !nip05NameRegex.MatchString(name) {
+		return "", "", fmt.Errorf("invalid NIP-05 name %q", name)
+	}
+	u, err := url.Parse("https://" + domain)
+	if err != nil || u.Host != domain || u.Hostname() == "" {
+		return "", "", fmt.Errorf("invalid NIP-05 domain %q", domain)
+	}
+
+	host := u.Hostname()
+	if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		u.Scheme = "http"
+	}
+	u.Path = "/.well-known/nostr.json"
+	u.RawQuery = url.Values{"name": {name}}.Encode()
+	return u.String(), name, nil
+}
+
+// nip05Client refuses redirects, as required by NIP-05
+var nip05Client = &http.Client{
+	Timeout: nip05Timeout,
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// resolveNIP05 returns the hex pubkey a NIP-05 identifier currently points to
+func resolveNIP05(ctx context.Context, identifier string) (string, error) {
+	wellKnown, name, err := nip05URL(identifier)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, wellKnown, nil)
+	if err != nil {
+		return "", err
+	}
+	res, err := nip05Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("request failed: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s returned HTTP %d", wellKnown, res.StatusCode)
+	}
+
+	var body struct {
+		Names map[string]string `json:"names"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("invalid nostr.json: %w", err)
+	}
+
+	pubkey, ok := body.Names[name]
+	if !ok {
+		return "", fmt.Errorf("no entry for name %q", name)
+	}
+	if !nostr.IsValidPublicKey(pubkey) {
+		return "", fmt.Errorf("invalid public key %q", pubkey)
+	}
+	return pubkey, nil
+}
+
+// nip05Verifier resolves the configured follows to hex pubkeys, checking NIP-05 identifiers
+type nip05Verifier struct {
+	entries  []FollowEntry
+	strict   bool              // Drop follows whose npub does not match their NIP-05 identifier
+	resolved map[int]string    // Entry index -> last pubkey resolved for identifier-only entries
+	failing  map[int]string    // Entry index -> last problem, to log only changes
+	decoded  map[string]string // npub -> hex pubkey
+}
+
+// newNIP05Verifier creates a verifier for the configured follows
+func newNIP05Verifier(entries []FollowEntry, strict bool) *nip05Verifier {
+	v := &nip05Verifier{
+		entries:  entries,
+		strict:   strict,
+		resolved: make(map[int]string),
+		failing:  make(map[int]string),
+		decoded:  make(map[string]string),
+	}
+	for _, e := range entries {
+		if e.Npub != "" {
+			keys, _ := decodeNpubs([]string{e.Npub})
+			v.decoded[e.Npub] = keys[0]
+		}
+	}
+	return v
+}
+
+// hasIdentifiers reports whether any follow uses NIP-05
+func (v *nip05Verifier) hasIdentifiers() bool {
+	for _, e := range v.entries {
+		if e.NIP05 != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// problem logs a NIP-05 problem for entry i once, until it changes or clears
+func (v *nip05Verifier) problem(i int, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if v.failing[i] != msg {
+		log.Printf("[WARN] %s", msg)
+		v.failing[i] = msg
+	}
+}
+
+// resolve returns the hex pubkeys of the follows. An identifier-only follow keeps its last
+// resolved key while its domain is unreachable; a follow whose npub does not match its
+// identifier is warned about and, in strict mode, left out.
+func (v *nip05Verifier) resolve(ctx context.Context) []string {
+	pubkeys := make([]string, 0, len(v.entries))
+	for i, e := range v.entries {
+		if e.NIP05 == "" {
+			pubkeys = append(pubkeys, v.decoded[e.Npub])
+			continue
+		}
+
+		pk, err := resolveNIP05(ctx, e.NIP05)
+		if e.Npub == "" {
+			if err != nil {
+				v.problem(i, "Failed to resolve NIP-05 follow %s: %v", e.NIP05, err)
+				pk = v.resolved[i]
+			} else {
+				if prev := v.resolved[i]; prev != "" && prev != pk {
+					log.Printf("[WARN] NIP-05 follow %s now points to %s (was %s)", e.NIP05, shortKey(pk), shortKey(prev))
+				}
+				v.resolved[i] = pk
+				delete(v.failing, i)
+			}
+			if pk != "" {
+				pubkeys = append(pubkeys, pk)
+			}
+			continue
+		}
+
+		expected := v.decoded[e.Npub]
+		switch {
+		case err != nil:
+			// An unreachable domain is not evidence of a mismatch; keep trusting the npub
+			v.problem(i, "Could not verify NIP-05 %s for follow %s: %v", e.NIP05, e.Npub, err)
+		case pk != expected:
+			action := "still trusted (set nip05_strict to drop it)"
+			if v.strict {
+				action = "dropped until it matches again"
+			}
+			v.problem(i, "NIP-05 mismatch for follow %s: %s points to %s; %s", e.Npub, e.NIP05, shortKey(pk), action)
+			if v.strict {
+				continue
+			}
+		default:
+			if _, was := v.failing[i]; was {
+				log.Printf("[INFO] NIP-05 %s verified for follow %s", e.NIP05, e.Npub)
+			}
+			delete(v.failing, i)
+		}
+		pubkeys = append(pubkeys, expected)
+	}
+	return pubkeys
+}
+
+// run re-resolves the follows every interval of clk and updates the static follow set
+func (v *nip05Verifier) run(ctx context.Context, follows *followSet, interval time.Duration, clk clock) {
+	ticker := clk.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			follows.SetStatic(v.resolve(ctx))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
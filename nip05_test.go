@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testNIP05Server serves .well-known/nostr.json from a mutable name -> pubkey map
+type testNIP05Server struct {
+	*httptest.Server
+	mu    sync.Mutex
+	names map[string]string
+}
+
+func startTestNIP05Server(t *testing.T) *testNIP05Server {
+	s := &testNIP05Server{names: make(map[string]string)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/.well-known/nostr.json" {
+			http.NotFound(w, r)
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]any{"names": s.names})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// set points name at pubkey
+func (s *testNIP05Server) set(name, pubkey string) {
+	s.mu.Lock()
+	s.names[name] = pubkey
+	s.mu.Unlock()
+}
+
+// identifier returns the NIP-05 identifier for name on this server
+func (s *testNIP05Server) identifier(name string) string {
+	return name + "@" + strings.TrimPrefix(s.URL, "http://")
+}
+
+func TestNIP05URL(t *testing.T) {
+	for identifier, want := range map[string]string{
+		"george@zenon.org":   "https://zenon.org/.well-known/nostr.json?name=george",
+		"zenon.org":          "https://zenon.org/.well-known/nostr.json?name=_",
+		"Bob@127.0.0.1:8080": "http://127.0.0.1:8080/.well-known/nostr.json?name=bob",
+		"bob@localhost":      "http://localhost/.well-known/nostr.json?name=bob",
+	} {
+		got, _, err := nip05URL(identifier)
+		if err != nil || got != want {
+			t.Errorf("nip05URL(%s) = %s, %v; want %s", identifier, got, err, want)
+		}
+	}
+	for _, bad := range []string{"bad name@zenon.org", "george@", "george@zenon.org/path"} {
+		if _, _, err := nip05URL(bad); err == nil {
+			t.Errorf("nip05URL(%q) accepted", bad)
+		}
+	}
+}
+
+func TestNIP05Verifier(t *testing.T) {
+	devs := newTestDevs(3)
+	alice, bob, mallory := devs[0], devs[1], devs[2]
+	server := startTestNIP05Server(t)
+	server.set("alice", alice.pk)
+	server.set("bob", bob.pk)
+	ctx := context.Background()
+
+	entries := []FollowEntry{
+		{NIP05: server.identifier("alice")},
+		{Npub: bob.npub, NIP05: server.identifier("bob")},
+	}
+	for _, strict := range []bool{false, true} {
+		v := newNIP05Verifier(entries, strict)
+		if got := v.resolve(ctx); !slices.Equal(got, []string{alice.pk, bob.pk}) {
+			t.Fatalf("strict=%v: resolve = %v, want alice and bob", strict, got)
+		}
+
+		// bob's identifier now points elsewhere: only strict mode drops him
+		server.set("bob", mallory.pk)
+		want := []string{alice.pk, bob.pk}
+		if strict {
+			want = want[:1]
+		}
+		if got := v.resolve(ctx); !slices.Equal(got, want) {
+			t.Errorf("strict=%v: after mismatch resolve = %v, want %v", strict, got, want)
+		}
+		server.set("bob", bob.pk)
+		if got := v.resolve(ctx); !slices.Equal(got, []string{alice.pk, bob.pk}) {
+			t.Errorf("strict=%v: after fix resolve = %v, want alice and bob", strict, got)
+		}
+	}
+
+	// An unknown name is not resolved
+	if got := newNIP05Verifier([]FollowEntry{{NIP05: server.identifier("carol")}}, false).resolve(ctx); len(got) != 0 {
+		t.Errorf("unknown name resolved to %v", got)
+	}
+}
+
+func TestNIP05Unreachable(t *testing.T) {
+	devs := newTestDevs(2)
+	alice, bob := devs[0], devs[1]
+	server := startTestNIP05Server(t)
+	server.set("alice", alice.pk)
+	server.set("bob", bob.pk)
+	ctx := context.Background()
+
+	v := newNIP05Verifier([]FollowEntry{
+		{NIP05: server.identifier("alice")},
+		{Npub: bob.npub, NIP05: server.identifier("bob")},
+	}, true)
+	if got := v.resolve(ctx); !slices.Equal(got, []string{alice.pk, bob.pk}) {
+		t.Fatalf("resolve = %v, want alice and bob", got)
+	}
+
+	// An unreachable domain keeps the last resolved key and the configured npub,
+	// even in strict mode
+	server.Close()
+	if _, err := resolveNIP05(ctx, server.identifier("alice")); err == nil {
+		t.Fatal("resolveNIP05 succeeded against a closed server")
+	}
+	if got := v.resolve(ctx); !slices.Equal(got, []string{alice.pk, bob.pk}) {
+		t.Errorf("unreachable: resolve = %v, want alice and bob", got)
+	}
+
+	// Never resolved, an identifier-only follow is not trusted
+	fresh := newNIP05Verifier([]FollowEntry{{NIP05: server.identifier("alice")}}, false)
+	if got := fresh.resolve(ctx); len(got) != 0 {
+		t.Errorf("unreachable, never resolved: resolve = %v", got)
+	}
+}
+
+func TestNIP05RunFollowsClock(t *testing.T) {
+	devs := newTestDevs(2)
+	before, after := devs[0], devs[1]
+	server := startTestNIP05Server(t)
+	server.set("alice", before.pk)
+
+	v := newNIP05Verifier([]FollowEntry{{NIP05: server.identifier("alice")}}, false)
+	follows := newFollowSet(v.resolve(context.Background()))
+	clk := newFakeClock(time.Now())
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		v.run(ctx, follows, time.Hour, clk)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// The identifier moves, but nothing is re-resolved until the clock reaches the interval
+	server.set("alice", after.pk)
+	time.Sleep(testSettle)
+	if follows.Contains(after.pk) {
+		t.Fatal("re-resolved before the interval elapsed")
+	}
+	waitFor(t, 5*time.Second, "re-resolution after the interval", func() bool {
+		clk.Advance(time.Hour)
+		return follows.Contains(after.pk) && !follows.Contains(before.pk)
+	})
+}
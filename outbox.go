@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Outbox retry schedule: failed relays are retried with exponential backoff, and an
+// event still undelivered after outboxMaxAge is dropped
+const (
+	outboxRetryMin  = 30 * time.Second
+	outboxRetryMax  = time.Hour
+	outboxMaxAge    = 7 * 24 * time.Hour
+	outboxPollEvery = 5 * time.Second
+)
+
+// outboxDelivery is the delivery state of an outbox event on one relay
+type outboxDelivery struct {
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt,omitzero"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// outboxEntry is a signed event awaiting an OK from each relay
+type outboxEntry struct {
+	Key       string                     `json:"key"` // History key of the action the event reports
+	Event     nostr.Event                `json:"event"`
+	QueuedAt  time.Time                  `json:"queued_at"`
+	Pending   map[string]*outboxDelivery `json:"pending"` // relay URL -> delivery state
+	Delivered []string                   `json:"delivered,omitempty"`
+}
+
+// outboxRelays is where the outbox delivers events; *relayManager in the daemon
+type outboxRelays interface {
+	has(url string) bool
+	publish(ctx context.Context, url string, ev nostr.Event) error
+}
+
+// statusOutbox persists kind=3333 status events in outbox.json until every relay has
+// acknowledged them, so events survive restarts and relay outages
+type statusOutbox struct {
+	mu      sync.Mutex
+	path    string
+	Entries []*outboxEntry `json:"entries"`
+	history *History
+	relays  outboxRelays
+	kick    chan struct{} // Wakes the delivery loop when an event is queued
+	clock   clock
+}
+
+// loadOutbox reads outbox.json from configDir, starting empty if it does not exist
+func loadOutbox(configDir string, history *History, relays outboxRelays, clk clock) *statusOutbox {
+	o := &statusOutbox{
+		path:    filepath.Join(configDir, "outbox.json"),
+		history: history,
+		relays:  relays,
+		kick:    make(chan struct{}, 1),
+		clock:   clk,
+	}
+
+	data, err := os.ReadFile(o.path)
+	if os.IsNotExist(err) {
+		return o
+	}
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to read outbox %s: %v", o.path, err)
+	}
+	if err := json.Unmarshal(data, o); err != nil {
+		// Losing undelivered status events is preferable to not starting
+		log.Printf("[ERROR] Failed to parse outbox %s, starting empty: %v", o.path, err)
+		o.Entries = nil
+		return o
+	}
+	if len(o.Entries) > 0 {
+		log.Printf("[INFO] Loaded %d undelivered status event(s) from %s", len(o.Entries), o.path)
+	}
+	return o
+}
+
+// saveLocked writes the outbox atomically; o.mu must be held
+func (o *statusOutbox) saveLocked() {
+	data, err := json.MarshalIndent(o, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(o.path, data, 0644)
+	}
+	if err != nil {
+		log.Printf("[ERROR] Failed to save outbox %s: %v", o.path, err)
+	}
+}
+
+// enqueue persists a signed event for delivery to all relays and wakes the delivery loop
+func (o *statusOutbox) enqueue(key string, ev nostr.Event, relays []string) {
+	o.mu.Lock()
+	entry := &outboxEntry{
+		Key:      key,
+		Event:    ev,
+		QueuedAt: o.clock.Now().UTC(),
+		Pending:  make(map[string]*outboxDelivery, len(relays)),
+	}
+	for _, url := range relays {
+		entry.Pending[nostr.NormalizeURL(url)] = &outboxDelivery{}
+	}
+	o.Entries = append(o.Entries, entry)
+	o.saveLocked()
+	o.mu.Unlock()
+
+	select {
+	case o.kick <- struct{}{}:
+	default:
+	}
+}
+
+// pending returns the number of events not yet acknowledged by every relay
+func (o *statusOutbox) pending() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.Entries)
+}
+
+// run delivers queued events until ctx is cancelled
+func (o *statusOutbox) run(ctx context.Context) {
+	ticker := o.clock.NewTicker(outboxPollEvery)
+	defer ticker.Stop()
+
+	for {
+		o.deliver(ctx)
+		select {
+		case <-ticker.C():
+		case <-o.kick:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// outboxAttempt is one due delivery of an entry to a relay
+type outboxAttempt struct {
+	entry *outboxEntry
+	url   string
+}
+
+// deliver publishes every due event/relay pair and records the results
+func (o *statusOutbox) deliver(ctx context.Context) {
+	now := o.clock.Now()
+
+	o.mu.Lock()
+	var due []outboxAttempt
+	dropped := false
+	kept := o.Entries[:0]
+	for _, e := range o.Entries {
+		if now.Sub(e.QueuedAt) > outboxMaxAge {
+			log.Printf("[WARN] Dropping status event %s for %s: not acknowledged by %d relay(s) after %s",
+				e.Event.ID, e.Key, len(e.Pending), outboxMaxAge)
+			continue
+		}
+		for url := range e.Pending {
+			if !o.relays.has(url) {
+				log.Printf("[WARN] Relay %s is no longer configured; not delivering status event %s to it", url, e.Event.ID)
+				delete(e.Pending, url)
+				dropped = true
+			}
+		}
+		if len(e.Pending) == 0 {
+			continue
+		}
+		kept = append(kept, e)
+		for url, d := range e.Pending {
+			if !now.Before(d.NextAttempt) {
+				due = append(due, outboxAttempt{e, url})
+			}
+		}
+	}
+	dropped = dropped || len(kept) != len(o.Entries)
+	o.Entries = kept
+	if dropped {
+		o.saveLocked()
+	}
+	o.mu.Unlock()
+
+	if len(due) == 0 {
+		return
+	}
+
+	// Publish outside the lock, waiting for each relay's OK
+	results := make([]error, len(due))
+	var wg sync.WaitGroup
+	for i, a := range due {
+		wg.Add(1)
+		go func(i int, a outboxAttempt) {
+			defer wg.Done()
+			results[i] = o.relays.publish(ctx, a.url, a.entry.Event)
+		}(i, a)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for i, a := range due {
+		d := a.entry.Pending[a.url]
+		if err := results[i]; err != nil {
+			d.Attempts++
+			d.LastError = err.Error()
+			d.NextAttempt = now.Add(min(outboxRetryMin<<min(d.Attempts-1, 8), outboxRetryMax))
+			log.Printf("[WARN] Status event %s not accepted by %s (attempt %d, retry at %s): %v",
+				a.entry.Event.ID, a.url, d.Attempts, d.NextAttempt.Format(time.RFC3339), err)
+			continue
+		}
+
+		delete(a.entry.Pending, a.url)
+		a.entry.Delivered = append(a.entry.Delivered, a.url)
+		log.Printf("[INFO] Status event %s for %s acknowledged by %s", a.entry.Event.ID, a.entry.Key, a.url)
+		if err := o.history.RecordAck(a.entry.Key, a.url); err != nil {
+			log.Printf("[WARN] Failed to record acknowledgement in history: %v", err)
+		}
+	}
+
+	kept = o.Entries[:0]
+	for _, e := range o.Entries {
+		if len(e.Pending) == 0 {
+			log.Printf("[INFO] Status event %s for %s delivered to all %d relay(s)", e.Event.ID, e.Key, len(e.Delivered))
+			continue
+		}
+		kept = append(kept, e)
+	}
+	o.Entries = kept
+	o.saveLocked()
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// fakeOutboxRelays answers publishes with a per-relay result instead of connecting
+type fakeOutboxRelays struct {
+	mu        sync.Mutex
+	results   map[string]error // relay URL -> result of a publish; missing means OK
+	published map[string]int   // relay URL -> publishes attempted
+}
+
+func newFakeOutboxRelays(urls ...string) *fakeOutboxRelays {
+	f := &fakeOutboxRelays{results: make(map[string]error), published: make(map[string]int)}
+	for _, url := range urls {
+		f.results[url] = nil
+	}
+	return f
+}
+
+func (f *fakeOutboxRelays) has(url string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.results[url]
+	return ok
+}
+
+func (f *fakeOutboxRelays) publish(ctx context.Context, url string, ev nostr.Event) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.published[url]++
+	return f.results[url]
+}
+
+func (f *fakeOutboxRelays) set(url string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.results[url] = err
+}
+
+func (f *fakeOutboxRelays) attempts(url string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.published[url]
+}
+
+const (
+	outboxTestA = "wss://a.example.com"
+	outboxTestB = "wss://b.example.com"
+	outboxTestC = "wss://c.example.com"
+)
+
+// newTestOutbox returns an outbox in a new config directory with an executed action
+// upgrade:1.1.0 in its history
+func newTestOutbox(t *testing.T, relays outboxRelays, clk clock) (*statusOutbox, *History, string) {
+	t.Helper()
+	dir := t.TempDir()
+	history := loadHistory(dir, clk)
+	history.Add("upgrade:1.1.0", HistoryEntry{ExecutedAt: clk.Now(), Type: "upgrade", Version: "1.1.0", Status: "success"})
+	if err := history.Save(); err != nil {
+		t.Fatal(err)
+	}
+	return loadOutbox(dir, history, relays, clk), history, dir
+}
+
+func TestOutboxRetryBackoff(t *testing.T) {
+	clk := newFakeClock(time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC))
+	relays := newFakeOutboxRelays(outboxTestA)
+	relays.set(outboxTestA, errors.New("connection refused"))
+	o, _, _ := newTestOutbox(t, relays, clk)
+	o.enqueue("upgrade:1.1.0", nostr.Event{ID: "e1"}, []string{outboxTestA})
+
+	// Each failure doubles the wait from 30s, capped at an hour
+	o.deliver(context.Background())
+	for i, wait := range []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute,
+		8 * time.Minute, 16 * time.Minute, 32 * time.Minute, time.Hour, time.Hour} {
+		attempts := relays.attempts(outboxTestA)
+		clk.Advance(wait - time.Second)
+		o.deliver(context.Background())
+		if got := relays.attempts(outboxTestA); got != attempts {
+			t.Fatalf("retry %d: published %s before the %s backoff elapsed", i+1, wait-time.Second, wait)
+		}
+		clk.Advance(time.Second)
+		o.deliver(context.Background())
+		if got := relays.attempts(outboxTestA); got != attempts+1 {
+			t.Fatalf("retry %d: not published after the %s backoff", i+1, wait)
+		}
+	}
+	if d := o.Entries[0].Pending[outboxTestA]; d.Attempts != 10 || d.LastError != "connection refused" {
+		t.Errorf("delivery state = %+v, want 10 attempts with the last error", d)
+	}
+}
+
+func TestOutboxDropsAfterMaxAge(t *testing.T) {
+	clk := newFakeClock(time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC))
+	relays := newFakeOutboxRelays(outboxTestA)
+	relays.set(outboxTestA, errors.New("connection refused"))
+	o, _, dir := newTestOutbox(t, relays, clk)
+	o.enqueue("upgrade:1.1.0", nostr.Event{ID: "e1"}, []string{outboxTestA})
+
+	clk.Advance(outboxMaxAge)
+	o.deliver(context.Background())
+	if o.pending() != 1 {
+		t.Fatal("event dropped at exactly the maximum age")
+	}
+
+	clk.Advance(time.Second)
+	o.deliver(context.Background())
+	if o.pending() != 0 {
+		t.Fatal("event kept past the maximum age")
+	}
+	if reloaded := loadOutbox(dir, nil, relays, clk); reloaded.pending() != 0 {
+		t.Error("dropped event still in outbox.json")
+	}
+}
+
+func TestOutboxSurvivesRestart(t *testing.T) {
+	clk := newFakeClock(time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC))
+	relays := newFakeOutboxRelays(outboxTestA, outboxTestB)
+	relays.set(outboxTestB, errors.New("connection refused"))
+	o, history, dir := newTestOutbox(t, relays, clk)
+	o.enqueue("upgrade:1.1.0", nostr.Event{ID: "e1"}, []string{outboxTestA, outboxTestB})
+	o.deliver(context.Background())
+
+	// After a restart the event is still pending on B, with its backoff
+	restarted := loadOutbox(dir, history, relays, clk)
+	if restarted.pending() != 1 {
+		t.Fatalf("reloaded outbox has %d events, want 1", restarted.pending())
+	}
+	e := restarted.Entries[0]
+	if e.Key != "upgrade:1.1.0" || e.Event.ID != "e1" || !slices.Equal(e.Delivered, []string{outboxTestA}) {
+		t.Errorf("reloaded entry = %+v", e)
+	}
+	if d := e.Pending[outboxTestB]; d == nil || d.Attempts != 1 || !d.NextAttempt.Equal(clk.Now().Add(outboxRetryMin)) {
+		t.Errorf("reloaded delivery to B = %+v, want 1 attempt retried after %s", d, outboxRetryMin)
+	}
+
+	restarted.deliver(context.Background())
+	if relays.attempts(outboxTestB) != 1 {
+		t.Error("reloaded outbox ignored the backoff")
+	}
+
+	relays.set(outboxTestB, nil)
+	clk.Advance(outboxRetryMin)
+	restarted.deliver(context.Background())
+	if restarted.pending() != 0 {
+		t.Fatal("event still pending after every relay acknowledged it")
+	}
+	if acked := history.Entries["upgrade:1.1.0"].AckedBy; !slices.Equal(acked, []string{outboxTestA, outboxTestB}) {
+		t.Errorf("acked_by = %v, want A and B", acked)
+	}
+}
+
+func TestOutboxAckedByOnlyOnOK(t *testing.T) {
+	clk := newFakeClock(time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC))
+	relays := newFakeOutboxRelays(outboxTestA, outboxTestB, outboxTestC)
+	relays.set(outboxTestB, errors.New("msg: blocked: not on the allow list"))
+	relays.set(outboxTestC, context.DeadlineExceeded)
+	o, history, dir := newTestOutbox(t, relays, clk)
+	o.enqueue("upgrade:1.1.0", nostr.Event{ID: "e1"}, []string{outboxTestA, outboxTestB, outboxTestC})
+	o.deliver(context.Background())
+
+	// A refused and a timed-out publish are not acknowledgements
+	if acked := history.Entries["upgrade:1.1.0"].AckedBy; !slices.Equal(acked, []string{outboxTestA}) {
+		t.Errorf("acked_by = %v, want only A", acked)
+	}
+	onDisk, err := readHistory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acked := onDisk.Entries["upgrade:1.1.0"].AckedBy; !slices.Equal(acked, []string{outboxTestA}) {
+		t.Errorf("acked_by on disk = %v, want only A", acked)
+	}
+	if len(o.Entries[0].Pending) != 2 {
+		t.Errorf("pending relays = %v, want B and C", o.Entries[0].Pending)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// proposal identifies the action proposed by HyperSignals
+type proposal struct {
+	Action  string
+	Version string
+	Network string
+	Genesis string
+}
+
+// key returns the history key nodes use for the proposed action
+func (p proposal) key() string {
+	if p.Action == "reboot" {
+		return fmt.Sprintf("reboot:%s:%s", p.Version, p.Genesis)
+	}
+	return fmt.Sprintf("upgrade:%s", p.Version)
+}
+
+// nodeReport is the latest kind=3333 status a node published for the proposal
+type nodeReport struct {
+	NodeID string
+	Status string
+	At     time.Time
+}
+
+// proposalTracker follows the votes for a proposal and the nodes that acted on it
+type proposalTracker struct {
+	mu      sync.Mutex
+	p       proposal
+	cfg     Config // Copy with the proposal's network
+	follows *followSet
+	state   *signalState
+	voters  []string              // npubs currently voting for the proposal
+	nodes   map[string]nodeReport // node pubkey -> latest report
+}
+
+// newProposalTracker tracks p with the quorum and follows of cfg
+func newProposalTracker(p proposal, cfg *Config, follows *followSet) *proposalTracker {
+	t := &proposalTracker{
+		p:       p,
+		cfg:     *cfg,
+		follows: follows,
+		state:   newSignalState(),
+		nodes:   make(map[string]nodeReport),
+	}
+	t.cfg.Network = p.Network
+	return t
+}
+
+// signalAddresses returns the "a" tag values of the HyperSignals of pubkeys, which
+// status events use to refer to the signal they acted on
+func signalAddresses(pubkeys []string) []string {
+	addresses := make([]string, len(pubkeys))
+	for i, pk := range pubkeys {
+		addresses[i] = fmt.Sprintf("33321:%s:hyperqube", pk)
+	}
+	return addresses
+}
+
+// filters returns the follows' HyperSignals and the status events referring to them.
+// Nodes reference the first signal they saw for an action, which may be any follow's.
+func (t *proposalTracker) filters() nostr.Filters {
+	authors := t.follows.Pubkeys()
+	addresses := signalAddresses(authors)
+	return nostr.Filters{
+		{
+			Authors: authors,
+			Kinds:   []int{33321},
+			Tags:    nostr.TagMap{"d": []string{"hyperqube"}},
+		},
+		{
+			Kinds: []int{3333},
+			Tags:  nostr.TagMap{"a": addresses},
+		},
+	}
+}
+
+// handle applies an event and returns a line describing what changed, if anything
+func (t *proposalTracker) handle(ev *nostr.Event, now time.Time) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch ev.Kind {
+	case 33321:
+		// Any signal of a follow can move their vote to or away from the proposal
+		if r := t.state.ingest(ev, &t.cfg, t.follows.Snapshot(), now); r != nil {
+			return "", false
+		}
+		trusted := t.follows.Snapshot()
+		votes := make(map[string]bool)
+		t.state.mu.RLock()
+		for pk := range t.state.votes[t.p.key()] {
+			if trusted[pk] {
+				votes[pk] = true
+			}
+		}
+		t.state.mu.RUnlock()
+		voters := voterNpubs(votes)
+		if strings.Join(voters, ",") == strings.Join(t.voters, ",") {
+			return "", false
+		}
+		t.voters = voters
+		line := fmt.Sprintf("Votes: %d/%d", len(voters), t.cfg.Quorum)
+		if meetsQuorum(votes, &t.cfg, t.follows) {
+			line += " (quorum reached)"
+		}
+		return line, true
+
+	case 3333:
+		if ok, _ := ev.CheckSignature(); !ok {
+			return "", false
+		}
+		if getTagValue(ev, "version") != t.p.Version || getTagValue(ev, "action") != t.p.Action ||
+			getTagValue(ev, "network") != t.p.Network {
+			return "", false
+		}
+		if t.p.Action == "reboot" && getTagValue(ev, "genesis_url") != t.p.Genesis {
+			return "", false
+		}
+		report := nodeReport{
+			NodeID: getTagValue(ev, "node_id"),
+			Status: getTagValue(ev, "status"),
+			At:     ev.CreatedAt.Time(),
+		}
+		if prev, ok := t.nodes[ev.PubKey]; ok && !report.At.After(prev.At) {
+			return "", false
+		}
+		t.nodes[ev.PubKey] = report
+		return fmt.Sprintf("Node %s (%s) reported %s at %s", report.NodeID, shortKey(ev.PubKey), report.Status,
+			report.At.UTC().Format(time.RFC3339)), true
+	}
+	return "", false
+}
+
+// summary describes the votes and node reports seen so far
+func (t *proposalTracker) summary() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Proposal %s on %s\n", t.p.key(), t.p.Network)
+	fmt.Fprintf(&b, "Votes: %d/%d\n", len(t.voters), t.cfg.Quorum)
+	for _, npub := range t.voters {
+		fmt.Fprintf(&b, "  %s\n", npub)
+	}
+
+	counts := make(map[string]int)
+	pubkeys := make([]string, 0, len(t.nodes))
+	for pk, r := range t.nodes {
+		counts[r.Status]++
+		pubkeys = append(pubkeys, pk)
+	}
+	sort.Slice(pubkeys, func(i, j int) bool { return t.nodes[pubkeys[i]].At.Before(t.nodes[pubkeys[j]].At) })
+	fmt.Fprintf(&b, "Nodes: %d reported (%d success, %d failure)\n", len(t.nodes), counts["success"], counts["failure"])
+	for _, pk := range pubkeys {
+		r := t.nodes[pk]
+		fmt.Fprintf(&b, "  %-40s %-8s %s  %s\n", r.NodeID, r.Status, r.At.UTC().Format(time.RFC3339), shortKey(pk))
+	}
+	return b.String()
+}
+
+// watchProposal subscribes to the relays and writes the proposal's state to w once the
+// relays' stored events are in, then each change until ctx is cancelled. With once it
+// returns after the first summary.
+func watchProposal(ctx context.Context, cfg *Config, follows *followSet, signer nostr.Signer, p proposal, w io.Writer, once bool) *proposalTracker {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	tracker := newProposalTracker(p, cfg, follows)
+	relays := newRelayManager(ctx, cfg.Relays, cfg.StreamTimeout, cfg.MaxClockSkew, realClock{})
+	relays.setAuth(signer, cfg.RelayAuth)
+
+	caughtUp := make(chan struct{})
+	events := relays.subscribe(ctx, tracker.filters(), func() { close(caughtUp) })
+	live := false
+	for {
+		select {
+		case <-caughtUp:
+			caughtUp = nil
+			fmt.Fprint(w, tracker.summary())
+			if once {
+				return tracker
+			}
+			live = true
+		case re, ok := <-events:
+			if !ok {
+				return tracker
+			}
+			if line, changed := tracker.handle(re.Event, time.Now()); changed && live {
+				fmt.Fprintf(w, "%s %s\n", time.Now().UTC().Format(time.RFC3339), line)
+			}
+		}
+	}
+}
+
+// proposalStatusCLI shows the votes for a proposed action and the nodes that reported on
+// it, optionally following changes until interrupted
+func proposalStatusCLI(configDir, passphraseFile string, args []string) {
+	var (
+		p     proposal
+		watch bool
+	)
+
+	flagSet := flag.NewFlagSet("proposal-status", flag.ExitOnError)
+	flagSet.StringVar(&p.Action, "type", "upgrade", "Action type: 'upgrade' or 'reboot'")
+	flagSet.StringVar(&p.Version, "version", "", "Semantic version of the proposal (required)")
+	flagSet.StringVar(&p.Network, "network", "", "Network identifier (default: network in config)")
+	flagSet.StringVar(&p.Genesis, "genesis", "", "Genesis URL (required for 'reboot')")
+	flagSet.BoolVar(&watch, "watch", false, "Keep following votes and node reports until interrupted")
+	flagSet.Parse(args)
+
+	if p.Action != "upgrade" && p.Action != "reboot" {
+		log.Fatalf("[ERROR] Invalid action type '%s'. Must be 'upgrade' or 'reboot'.", p.Action)
+	}
+	if _, err := semver.NewVersion(p.Version); err != nil {
+		log.Fatalf("[ERROR] Invalid semantic version '%s': %v", p.Version, err)
+	}
+	if p.Action == "reboot" && p.Genesis == "" {
+		log.Fatal("[ERROR] Genesis URL is required for reboot proposals (use --genesis flag)")
+	}
+
+	cfg := loadConfig(configDir)
+	if p.Network == "" {
+		p.Network = cfg.Network
+	}
+
+	// The key only answers relay AUTH challenges
+	_, privKey := loadSecretKey(configDir, passphraseFile, true)
+	signer, err := newSigner(context.Background(), privKey, cfg.Bunker)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to set up signer: %v", err)
+	}
+	runProposalWatch(&cfg, configDir, signer, p, watch)
+}
+
+// runProposalWatch watches p on the configured relays until interrupted, or only until
+// the current state is shown unless watch is set
+func runProposalWatch(cfg *Config, configDir string, signer nostr.Signer, p proposal, watch bool) {
+	if len(cfg.Relays) == 0 {
+		log.Fatal("[ERROR] No relays configured")
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	follows := offlineFollows(ctx, configDir, cfg)
+	if watch {
+		log.Printf("[INFO] Watching proposal %s on %d relay(s), press Ctrl-C to stop", p.key(), len(cfg.Relays))
+	}
+	watchProposal(ctx, cfg, follows, signer, p, os.Stdout, !watch)
+}
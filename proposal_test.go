@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestProposalTrackerRebootReports(t *testing.T) {
+	devs := newTestDevs(3)
+	dev, nodeA, nodeB := devs[0], devs[1], devs[2]
+	p := proposal{Action: "reboot", Version: "v2.0.0", Network: "hqz", Genesis: "https://example.com/genesis-b.json"}
+	tracker := newProposalTracker(p, &Config{Quorum: 1}, newFollowSet([]string{dev.pk}))
+	now := time.Now()
+
+	report := func(node testDev, genesis string) *nostr.Event {
+		ev := &nostr.Event{
+			Kind:      3333,
+			CreatedAt: nostr.Timestamp(now.Unix()),
+			Tags: nostr.Tags{
+				{"a", "33321:" + dev.pk + ":hyperqube"},
+				{"version", p.Version},
+				{"network", p.Network},
+				{"action", "reboot"},
+				{"status", "success"},
+				{"node_id", node.pk[:8]},
+				{"genesis_url", genesis},
+			},
+		}
+		if err := ev.Sign(node.sk); err != nil {
+			t.Fatal(err)
+		}
+		return ev
+	}
+
+	// A report for a reboot of the same version to another genesis is not this proposal's
+	if line, ok := tracker.handle(report(nodeA, "https://example.com/genesis-a.json"), now); ok {
+		t.Errorf("report for another genesis counted: %s", line)
+	}
+	if _, ok := tracker.handle(report(nodeB, p.Genesis), now); !ok {
+		t.Error("report for the proposed genesis ignored")
+	}
+	if len(tracker.nodes) != 1 {
+		t.Errorf("tracked %d node(s), want 1", len(tracker.nodes))
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// NIP-42 authentication modes for relay_auth
+const (
+	relayAuthOff      = "off"       // Never authenticate
+	relayAuthOnDemand = "on-demand" // Authenticate when a relay answers auth-required
+	relayAuthAlways   = "always"    // Authenticate as soon as connected
+)
+
+// relayAuthChallengeWait is how long "always" mode retries after connecting, since a
+// relay's AUTH challenge may arrive shortly after the connection is established
+const relayAuthChallengeWait = 5 * time.Second
+
+// relayAuthRetryDelay is how long authentication waits before trying again while no
+// challenge has arrived yet
+const relayAuthRetryDelay = 500 * time.Millisecond
+
+// validRelayAuthMode reports whether mode is a known relay_auth mode
+func validRelayAuthMode(mode string) bool {
+	switch mode {
+	case relayAuthOff, relayAuthOnDemand, relayAuthAlways:
+		return true
+	}
+	return false
+}
+
+// setAuth enables answering AUTH challenges with signer, using the per-relay modes
+// from relay_auth ("*" is the default for relays not listed)
+func (m *relayManager) setAuth(signer nostr.Signer, modes map[string]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.signer = signer
+	m.auth = make(map[string]string, len(modes))
+	for url, mode := range modes {
+		if url != "*" {
+			url = nostr.NormalizeURL(url)
+		}
+		m.auth[url] = mode
+	}
+}
+
+// authModeLocked returns the authentication mode for url; m.mu must be held
+func (m *relayManager) authModeLocked(url string) string {
+	if m.signer == nil {
+		return relayAuthOff
+	}
+	if mode, ok := m.auth[url]; ok {
+		return mode
+	}
+	if mode, ok := m.auth["*"]; ok {
+		return mode
+	}
+	return relayAuthOnDemand
+}
+
+// authenticate answers r's AUTH challenge with the node key, retrying for up to wait
+// while no challenge has arrived yet. Each connection is authenticated at most once,
+// so a relay that keeps refusing after auth is not retried in a loop.
+func (m *relayManager) authenticate(ctx context.Context, url string, r *nostr.Relay, wait time.Duration) error {
+	m.mu.Lock()
+	c := m.conns[url]
+	mode := m.authModeLocked(url)
+	authed := c.authed == r
+	m.mu.Unlock()
+
+	if mode == relayAuthOff {
+		return errors.New("relay requires authentication but relay_auth is off")
+	}
+	if authed {
+		return errors.New("already authenticated on this connection")
+	}
+
+	var pubkey string
+	deadline := m.clock.Now().Add(wait)
+	var err error
+	for {
+		authCtx, cancel := context.WithTimeout(ctx, relayPublishTimeout)
+		err = r.Auth(authCtx, func(ev *nostr.Event) error {
+			err := signEvent(authCtx, m.signer, ev)
+			pubkey = ev.PubKey
+			return err
+		})
+		cancel()
+		if err == nil || ctx.Err() != nil || !m.clock.Now().Before(deadline) {
+			break
+		}
+		retry := m.clock.NewTimer(relayAuthRetryDelay)
+		select {
+		case <-retry.C():
+		case <-ctx.Done():
+			retry.Stop()
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		c.failLocked(fmt.Errorf("auth: %w", err), m.clock.Now())
+		log.Printf("[WARN] NIP-42 authentication to relay %s failed: %v", url, err)
+		return err
+	}
+	c.authed = r
+	c.status.AuthedAs = pubkey
+	log.Printf("[INFO] Authenticated to relay %s as %s", url, shortKey(pubkey))
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestRelayAuthMode(t *testing.T) {
+	m := newRelayManager(context.Background(), []string{"wss://a.example.com", "wss://b.example.com"},
+		time.Minute, defaultMaxClockSkew, realClock{})
+	mode := func(url string) string {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return m.authModeLocked(url)
+	}
+
+	// Without a signer nothing is authenticated, whatever relay_auth says
+	m.setAuth(nil, map[string]string{"*": relayAuthAlways})
+	if got := mode("wss://a.example.com"); got != relayAuthOff {
+		t.Errorf("mode without a signer = %s, want %s", got, relayAuthOff)
+	}
+
+	signer, err := newAuthSigner(nostr.GeneratePrivateKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.setAuth(signer, nil)
+	if got := mode("wss://a.example.com"); got != relayAuthOnDemand {
+		t.Errorf("default mode = %s, want %s", got, relayAuthOnDemand)
+	}
+
+	// Relay URLs are normalized; "*" applies to relays not listed
+	m.setAuth(signer, map[string]string{"*": relayAuthAlways, "wss://A.example.com/": relayAuthOff})
+	if got := mode("wss://a.example.com"); got != relayAuthOff {
+		t.Errorf("mode of a listed relay = %s, want %s", got, relayAuthOff)
+	}
+	if got := mode("wss://b.example.com"); got != relayAuthAlways {
+		t.Errorf("mode of an unlisted relay = %s, want %s", got, relayAuthAlways)
+	}
+
+	// A relay set to off is never answered, and its health is not affected
+	if err := m.authenticate(context.Background(), "wss://a.example.com", nil, 0); err == nil {
+		t.Error("authenticated to a relay with relay_auth off")
+	}
+	if s := m.statuses()[0]; s.Errors != 0 {
+		t.Errorf("refusing to authenticate counted %d error(s)", s.Errors)
+	}
+}
+
+func TestAuthSignerIsNodeKey(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	signer, err := newAuthSigner(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := signer.GetPublicKey(context.Background()); got != pk {
+		t.Errorf("auth signer pubkey = %s, want the node key %s", got, pk)
+	}
+	if _, err := newAuthSigner("not a key"); err == nil {
+		t.Error("invalid node key accepted")
+	}
+}
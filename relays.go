@@ -0,0 +1,643 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Relay connection backoff bounds and timeouts
+const (
+	relayBackoffMin     = 2 * time.Second
+	relayBackoffMax     = 5 * time.Minute
+	relayConnectTimeout = 15 * time.Second
+	relayPublishTimeout = 10 * time.Second
+)
+
+// defaultStreamTimeout is how long a subscription may stay silent before it is renewed
+const defaultStreamTimeout = 30 * time.Minute
+
+// resubscribeOverlap is subtracted from the newest seen created_at when resubscribing,
+// so events published slightly out of order are not missed (duplicates are dropped)
+const resubscribeOverlap = 10 * time.Minute
+
+// relaySeenMax bounds the event IDs a subscription remembers to drop duplicates
+// delivered by several relays
+const relaySeenMax = 10000
+
+// relayHealthWindow is the number of recent operations the health score is computed from
+const relayHealthWindow = 20
+
+// relayStatusFile is written to the config directory with the latest per-relay state
+const relayStatusFile = "relay-status.json"
+
+// Relay connection states
+const (
+	relayConnecting   = "connecting"
+	relayConnected    = "connected"
+	relayBackingOff   = "backoff"
+	relayDisconnected = "disconnected"
+)
+
+// relayStatus is the per-relay state reported in logs and relay-status.json
+type relayStatus struct {
+	URL         string        `json:"url"`
+	Discovered  bool          `json:"discovered,omitempty"` // Added by NIP-65 relay discovery
+	State       string        `json:"state"`
+	Health      int           `json:"health"` // 0-100, share of recent operations that succeeded
+	ConnectedAt time.Time     `json:"connected_at,omitzero"`
+	LastEventAt time.Time     `json:"last_event_at,omitzero"`
+	LastError   string        `json:"last_error,omitempty"`
+	LastErrorAt time.Time     `json:"last_error_at,omitzero"`
+	NextAttempt time.Time     `json:"next_attempt,omitzero"`
+	Latency     time.Duration `json:"latency_ms"` // Last connect or publish round-trip
+	Events      int           `json:"events"`
+	Published   int           `json:"published"`
+	Errors      int           `json:"errors"`
+	Reconnects  int           `json:"reconnects"`
+	AuthedAs    string        `json:"authed_as,omitempty"` // Pubkey authenticated with NIP-42 on this connection
+}
+
+// MarshalJSON reports the latency in milliseconds
+func (s relayStatus) MarshalJSON() ([]byte, error) {
+	type plain relayStatus
+	p := plain(s)
+	p.Latency = s.Latency / time.Millisecond
+	return json.Marshal(p)
+}
+
+// relayConn is a relay's connection and bookkeeping; guarded by relayManager.mu.
+// Entries are kept after a discovered relay is removed, so goroutines still
+// winding down never see a missing entry.
+type relayConn struct {
+	status  relayStatus
+	relay   *nostr.Relay
+	backoff time.Duration
+	recent  []bool          // Outcomes of the last relayHealthWindow operations
+	authed  *nostr.Relay    // Connection that completed NIP-42 auth, so it is not repeated
+	dialing chan struct{}   // Closed when the dial in progress ends; nil when none is
+	ctx     context.Context // Cancelled when the relay leaves the managed set
+	cancel  context.CancelFunc
+}
+
+// relaySub is an active merged subscription, started on relays as they are added
+type relaySub struct {
+	ctx     context.Context
+	filters nostr.Filters
+	emit    func(nostr.RelayEvent) bool
+	wg      *sync.WaitGroup
+}
+
+// relayManager owns one connection per relay, reconnecting with exponential backoff
+// and recording per-relay latency, errors and health. Configured relays are fixed;
+// discovered relays can be added and removed at runtime.
+type relayManager struct {
+	ctx     context.Context
+	mu      sync.Mutex
+	urls    []string // Managed relays: configured first, then discovered
+	conns   map[string]*relayConn
+	subs    map[*relaySub]bool
+	silence time.Duration // Watchdog period after which a silent subscription is renewed
+	maxSkew time.Duration // Events dated further ahead are rejected and do not move the resubscribe cursor
+	signer  nostr.Signer  // Answers NIP-42 AUTH challenges; nil disables authentication
+	auth    map[string]string
+	clock   clock // Times backoff, the watchdog and the status timestamps
+}
+
+// newRelayManager creates a manager for urls; connections close when ctx is cancelled.
+// Subscriptions without any activity for silence of clk are renewed.
+func newRelayManager(ctx context.Context, urls []string, silence, maxSkew time.Duration, clk clock) *relayManager {
+	m := &relayManager{
+		ctx:     ctx,
+		conns:   make(map[string]*relayConn),
+		subs:    make(map[*relaySub]bool),
+		silence: silence,
+		maxSkew: maxSkew,
+		clock:   clk,
+	}
+	for _, u := range urls {
+		if u = nostr.NormalizeURL(u); !slices.Contains(m.urls, u) {
+			m.addLocked(u, false)
+		}
+	}
+	return m
+}
+
+// has reports whether url is one of the managed relays
+func (m *relayManager) has(url string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Contains(m.urls, url)
+}
+
+// addLocked adds url to the managed set and starts it on active subscriptions; m.mu must be held
+func (m *relayManager) addLocked(url string, discovered bool) {
+	c, ok := m.conns[url]
+	if !ok {
+		c = &relayConn{status: relayStatus{URL: url, State: relayDisconnected}}
+		m.conns[url] = c
+	}
+	c.status.Discovered = discovered
+	c.ctx, c.cancel = context.WithCancel(m.ctx)
+	m.urls = append(m.urls, url)
+
+	for sub := range m.subs {
+		m.startLocked(sub, url, c, func() {})
+	}
+}
+
+// startLocked runs sub on one relay until either is done, calling replayed once the
+// relay has sent its stored events or failed to; m.mu must be held
+func (m *relayManager) startLocked(sub *relaySub, url string, c *relayConn, replayed func()) {
+	ctx, cancel := context.WithCancel(sub.ctx)
+	stop := context.AfterFunc(c.ctx, cancel)
+
+	sub.wg.Add(1)
+	go func() {
+		defer sub.wg.Done()
+		defer stop()
+		defer cancel()
+		m.subscribeRelay(ctx, url, sub.filters, sub.emit, sync.OnceFunc(replayed))
+	}()
+}
+
+// setDiscovered replaces the discovered relays with urls, leaving configured relays alone
+func (m *relayManager) setDiscovered(urls []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := m.urls[:0]
+	for _, url := range m.urls {
+		c := m.conns[url]
+		if c.status.Discovered && !slices.Contains(urls, url) {
+			log.Printf("[INFO] Removing discovered relay %s", url)
+			c.cancel()
+			c.relay = nil
+			c.status.State = relayDisconnected
+			continue
+		}
+		kept = append(kept, url)
+	}
+	m.urls = kept
+
+	for _, url := range urls {
+		if !slices.Contains(m.urls, url) {
+			log.Printf("[INFO] Adding discovered relay %s", url)
+			m.addLocked(url, true)
+		}
+	}
+}
+
+// recordLocked adds an operation outcome to c's health window; m.mu must be held
+func (c *relayConn) recordLocked(ok bool) {
+	c.recent = append(c.recent, ok)
+	if len(c.recent) > relayHealthWindow {
+		c.recent = c.recent[1:]
+	}
+	good := 0
+	for _, r := range c.recent {
+		if r {
+			good++
+		}
+	}
+	c.status.Health = good * 100 / len(c.recent)
+}
+
+// failLocked records an error at now and schedules the next attempt with exponential
+// backoff; m.mu must be held
+func (c *relayConn) failLocked(err error, now time.Time) {
+	c.status.Errors++
+	c.status.LastError = err.Error()
+	c.status.LastErrorAt = now
+	c.recordLocked(false)
+
+	if c.relay != nil && !c.relay.IsConnected() {
+		c.relay = nil
+	}
+	if c.relay == nil {
+		c.backoff = min(max(c.backoff*2, relayBackoffMin), relayBackoffMax)
+		c.status.State = relayBackingOff
+		c.status.NextAttempt = now.Add(c.backoff)
+	}
+}
+
+// fail records an error for url
+func (m *relayManager) fail(url string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.conns[url].failLocked(err, m.clock.Now())
+}
+
+// connect returns a live connection to url, dialing if needed. While the relay is
+// backing off it fails immediately. Concurrent callers share one dial.
+func (m *relayManager) connect(url string) (*nostr.Relay, error) {
+	m.mu.Lock()
+	c := m.conns[url]
+	for c.dialing != nil {
+		done := c.dialing
+		m.mu.Unlock()
+		<-done
+		m.mu.Lock()
+	}
+	if c.relay != nil && c.relay.IsConnected() {
+		r := c.relay
+		m.mu.Unlock()
+		return r, nil
+	}
+	if wait := c.status.NextAttempt.Sub(m.clock.Now()); wait > 0 {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("backing off for %s", wait.Round(time.Second))
+	}
+	if c.relay != nil {
+		c.status.Reconnects++
+	}
+	c.relay = nil
+	c.status.State = relayConnecting
+	done := make(chan struct{})
+	c.dialing = done
+	relayCtx := c.ctx
+	m.mu.Unlock()
+
+	// Callers waiting for this dial see its outcome, including authentication
+	defer func() {
+		m.mu.Lock()
+		c.dialing = nil
+		m.mu.Unlock()
+		close(done)
+	}()
+
+	ctx, cancel := context.WithTimeout(relayCtx, relayConnectTimeout)
+	defer cancel()
+
+	start := m.clock.Now()
+	r := nostr.NewRelay(relayCtx, url)
+	err := r.Connect(ctx)
+
+	m.mu.Lock()
+	now := m.clock.Now()
+	if err != nil {
+		c.failLocked(err, now)
+		m.mu.Unlock()
+		return nil, err
+	}
+	c.relay = r
+	c.backoff = 0
+	c.status.State = relayConnected
+	c.status.ConnectedAt = now
+	c.status.NextAttempt = time.Time{}
+	c.status.Latency = now.Sub(start)
+	c.status.AuthedAs = ""
+	c.recordLocked(true)
+	always := m.authModeLocked(url) == relayAuthAlways
+	m.mu.Unlock()
+
+	if always {
+		// Failures are recorded; the relay can still serve what it allows without auth
+		m.authenticate(relayCtx, url, r, relayAuthChallengeWait)
+	}
+	return r, nil
+}
+
+// waitRetry blocks until url may be dialed again or ctx is done
+func (m *relayManager) waitRetry(ctx context.Context, url string) {
+	m.mu.Lock()
+	wait := m.conns[url].status.NextAttempt.Sub(m.clock.Now())
+	m.mu.Unlock()
+
+	if wait <= 0 {
+		wait = relayBackoffMin
+	}
+	t := m.clock.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C():
+	case <-ctx.Done():
+	}
+}
+
+// subscribe opens filters on every relay and merges their events, dropping duplicates
+// seen on several relays. Each relay is resubscribed after a disconnect. replayed is
+// called once every relay has delivered its stored events (EOSE) or failed to, so the
+// caller can tell the initial backlog from live events. The returned channel is closed
+// once ctx is cancelled.
+func (m *relayManager) subscribe(ctx context.Context, filters nostr.Filters, replayed func()) <-chan nostr.RelayEvent {
+	out := make(chan nostr.RelayEvent)
+	var seenMu sync.Mutex
+	seen := make(map[string]nostr.Timestamp) // event ID -> created_at
+
+	emit := func(ev nostr.RelayEvent) bool {
+		seenMu.Lock()
+		_, dup := seen[ev.ID]
+		if !dup {
+			seen[ev.ID] = ev.CreatedAt
+			if len(seen) > relaySeenMax {
+				pruneSeen(seen, relaySeenMax/2)
+			}
+		}
+		seenMu.Unlock()
+		if dup {
+			return true
+		}
+		select {
+		case out <- ev:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	// The subscription holds one count on wg until ctx is done, so relays added
+	// later can still join it
+	var wg sync.WaitGroup
+	sub := &relaySub{ctx: ctx, filters: filters, emit: emit, wg: &wg}
+	wg.Add(1)
+	var replaying sync.WaitGroup
+	m.mu.Lock()
+	m.subs[sub] = true
+	for _, url := range m.urls {
+		replaying.Add(1)
+		m.startLocked(sub, url, m.conns[url], replaying.Done)
+	}
+	m.mu.Unlock()
+	go func() {
+		replaying.Wait()
+		if ctx.Err() == nil {
+			replayed()
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+		m.mu.Lock()
+		delete(m.subs, sub)
+		m.mu.Unlock()
+		wg.Done()
+	}()
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// pruneSeen keeps the keep most recently created events of seen. Resubscribes only ask
+// for events after the newest seen, so older ones rarely come again; if one does, the
+// consumer still recognizes it as already counted.
+func pruneSeen(seen map[string]nostr.Timestamp, keep int) {
+	if len(seen) <= keep {
+		return
+	}
+	created := make([]nostr.Timestamp, 0, len(seen))
+	for _, ts := range seen {
+		created = append(created, ts)
+	}
+	slices.Sort(created)
+	cutoff := created[len(created)-keep]
+	for id, ts := range seen {
+		if ts < cutoff {
+			delete(seen, id)
+		}
+	}
+	// Events created in the same second as the cutoff may still exceed keep
+	for id, ts := range seen {
+		if len(seen) <= keep {
+			break
+		}
+		if ts == cutoff {
+			delete(seen, id)
+		}
+	}
+}
+
+// subscribeRelay keeps a subscription open on one relay until ctx is cancelled,
+// passing events to emit. After the first subscription, the filters are narrowed with
+// a since just before the newest acceptable event seen, so a resubscribe catches up without gaps.
+// replayed is called at the first EOSE, or when the first attempt fails.
+func (m *relayManager) subscribeRelay(ctx context.Context, url string, filters nostr.Filters, emit func(nostr.RelayEvent) bool, replayed func()) {
+	defer replayed()
+	var newest nostr.Timestamp
+	for ctx.Err() == nil {
+		r, err := m.connect(url)
+		if err != nil {
+			log.Printf("[DEBUG] Relay %s unavailable: %v", url, err)
+			replayed()
+			m.waitRetry(ctx, url)
+			continue
+		}
+
+		sub, err := r.Subscribe(ctx, filtersSince(filters, newest))
+		if err != nil {
+			m.fail(url, fmt.Errorf("subscribe: %w", err))
+			replayed()
+			m.waitRetry(ctx, url)
+			continue
+		}
+
+		reason, healthy := m.readSubscription(url, sub, &newest, emit, replayed)
+		replayed()
+		if ctx.Err() != nil {
+			return
+		}
+		if healthy {
+			// Quiet but working: resubscribe right away on the same connection
+			log.Printf("[INFO] Resubscribing to relay %s: %s", url, reason)
+			continue
+		}
+		log.Printf("[WARN] Subscription on relay %s ended: %s", url, reason)
+		m.fail(url, fmt.Errorf("subscription ended: %s", reason))
+		m.waitRetry(ctx, url)
+	}
+}
+
+// filtersSince returns filters limited to events created after newest, less
+// resubscribeOverlap; newest 0 leaves them unchanged
+func filtersSince(filters nostr.Filters, newest nostr.Timestamp) nostr.Filters {
+	if newest == 0 {
+		return filters
+	}
+	since := newest - nostr.Timestamp(resubscribeOverlap/time.Second)
+	out := make(nostr.Filters, len(filters))
+	for i, f := range filters {
+		f.Since = &since
+		out[i] = f
+	}
+	return out
+}
+
+// advanceCursor returns the resubscribe cursor after an accepted event created at
+// createdAt. Events dated more than maxSkew ahead of now are rejected by every consumer
+// and leave it unchanged, so one future-dated event cannot make resubscribes skip
+// the real ones.
+func advanceCursor(newest, createdAt nostr.Timestamp, now time.Time, maxSkew time.Duration) nostr.Timestamp {
+	if createdAt.Time().After(now.Add(maxSkew)) {
+		return newest
+	}
+	return max(newest, createdAt)
+}
+
+// readSubscription forwards events from sub until it ends or stays silent for the
+// watchdog period, returning why. healthy is true when the relay completed its stored
+// events (EOSE) and merely went quiet, so the subscription can be renewed at once.
+// onEOSE is called when the relay sends EOSE.
+func (m *relayManager) readSubscription(url string, sub *nostr.Subscription, newest *nostr.Timestamp, emit func(nostr.RelayEvent) bool, onEOSE func()) (reason string, healthy bool) {
+	defer sub.Unsub()
+
+	watchdog := m.clock.NewTimer(m.silence)
+	defer watchdog.Stop()
+	eose := false
+
+	for {
+		select {
+		case ev, ok := <-sub.Events:
+			if !ok {
+				return "events channel closed", false
+			}
+			now := m.clock.Now()
+			m.mu.Lock()
+			c := m.conns[url]
+			c.status.Events++
+			c.status.LastEventAt = now
+			m.mu.Unlock()
+			// The relay client already dropped events with a bad signature or outside the filters
+			*newest = advanceCursor(*newest, ev.CreatedAt, now, m.maxSkew)
+			watchdog.Reset(m.silence)
+			if !emit(nostr.RelayEvent{Event: ev, Relay: sub.Relay}) {
+				return "cancelled", false
+			}
+		case <-sub.EndOfStoredEvents:
+			eose = true
+			onEOSE()
+			watchdog.Reset(m.silence)
+		case <-watchdog.C():
+			if !eose {
+				// Never finished sending stored events: treat the connection as stuck
+				sub.Relay.Close()
+				return fmt.Sprintf("no EOSE within %s", m.silence), false
+			}
+			return fmt.Sprintf("no events for %s", m.silence), true
+		case reason := <-sub.ClosedReason:
+			if strings.HasPrefix(reason, "auth-required:") && m.authenticate(sub.Relay.Context(), url, sub.Relay, 0) == nil {
+				return "authenticated after " + reason, true
+			}
+			return "CLOSED by relay: " + reason, false
+		case <-sub.Context.Done():
+			return context.Cause(sub.Context).Error(), false
+		}
+	}
+}
+
+// publish sends ev to url and waits for the relay's OK
+func (m *relayManager) publish(ctx context.Context, url string, ev nostr.Event) error {
+	r, err := m.connect(url)
+	if err != nil {
+		return err
+	}
+
+	pubCtx, cancel := context.WithTimeout(ctx, relayPublishTimeout)
+	defer cancel()
+
+	start := m.clock.Now()
+	err = r.Publish(pubCtx, ev)
+	if err != nil && strings.HasPrefix(err.Error(), "msg: auth-required:") && m.authenticate(pubCtx, url, r, 0) == nil {
+		err = r.Publish(pubCtx, ev)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := m.conns[url]
+	if err != nil {
+		c.failLocked(fmt.Errorf("publish: %w", err), m.clock.Now())
+		return err
+	}
+	c.status.Published++
+	c.status.Latency = m.clock.Now().Sub(start)
+	c.recordLocked(true)
+	return nil
+}
+
+// statuses returns a snapshot of all relay states in configured order
+func (m *relayManager) statuses() []relayStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := make([]relayStatus, 0, len(m.urls))
+	for _, url := range m.urls {
+		c := m.conns[url]
+		s := c.status
+		if c.relay != nil && !c.relay.IsConnected() {
+			s.State = relayDisconnected
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// summary is a one-line overview for the periodic log
+func (m *relayManager) summary() string {
+	statuses := m.statuses()
+	parts := make([]string, 0, len(statuses))
+	up := 0
+	for _, s := range statuses {
+		if s.State == relayConnected {
+			up++
+		}
+		parts = append(parts, fmt.Sprintf("%s=%s(health %d, %dms, %d err)",
+			strings.TrimPrefix(s.URL, "wss://"), s.State, s.Health, s.Latency.Milliseconds(), s.Errors))
+	}
+	sort.Strings(parts)
+	return fmt.Sprintf("%d/%d up: %s", up, len(statuses), strings.Join(parts, ", "))
+}
+
+// writeStatus saves the relay states to relay-status.json in configDir
+func (m *relayManager) writeStatus(configDir string) error {
+	data, err := json.MarshalIndent(m.statuses(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(configDir, relayStatusFile), data, 0644)
+}
+
+// relaysCLI prints the relay states last written by the daemon
+func relaysCLI(configDir string) {
+	path := filepath.Join(configDir, relayStatusFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("[ERROR] No relay status at %s (is the daemon running?): %v", path, err)
+	}
+
+	var statuses []struct {
+		relayStatus
+		LatencyMs int64 `json:"latency_ms"`
+	}
+	if err := json.Unmarshal(data, &statuses); err != nil {
+		log.Fatalf("[ERROR] Failed to parse %s: %v", path, err)
+	}
+
+	info, _ := os.Stat(path)
+	fmt.Printf("Relay status as of %s\n\n", info.ModTime().Format(time.RFC3339))
+	fmt.Printf("%-40s %-12s %6s %8s %7s %6s %10s  %s\n", "RELAY", "STATE", "HEALTH", "LATENCY", "EVENTS", "ERRORS", "RECONNECTS", "LAST ERROR")
+	discovered := false
+	for _, s := range statuses {
+		url := s.URL
+		if s.Discovered {
+			url += " *"
+			discovered = true
+		}
+		fmt.Printf("%-40s %-12s %6d %6dms %7d %6d %10d  %s\n",
+			url, s.State, s.Health, s.LatencyMs, s.Events, s.Errors, s.Reconnects, s.LastError)
+	}
+	if discovered {
+		fmt.Println("\n* discovered from the follows' NIP-65 relay lists")
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestAdvanceCursorIgnoresFutureEvents(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	ts := func(d time.Duration) nostr.Timestamp { return nostr.Timestamp(now.Add(d).Unix()) }
+
+	newest := advanceCursor(0, ts(-time.Hour), now, defaultMaxClockSkew)
+	if newest != ts(-time.Hour) {
+		t.Fatalf("cursor = %d, want %d", newest, ts(-time.Hour))
+	}
+
+	// A signal dated a day ahead is rejected by ingest; resubscribing from it would skip
+	// every real signal until then
+	if got := advanceCursor(newest, ts(24*time.Hour), now, defaultMaxClockSkew); got != newest {
+		t.Errorf("future event moved cursor to %d", got)
+	}
+
+	// Within the skew the cursor moves, and an older event never moves it back
+	newest = advanceCursor(newest, ts(defaultMaxClockSkew), now, defaultMaxClockSkew)
+	if newest != ts(defaultMaxClockSkew) {
+		t.Errorf("cursor = %d, want %d", newest, ts(defaultMaxClockSkew))
+	}
+	if got := advanceCursor(newest, ts(0), now, defaultMaxClockSkew); got != newest {
+		t.Errorf("older event moved cursor back to %d", got)
+	}
+
+	since := *filtersSince(nostr.Filters{{Kinds: []int{33321}}}, newest)[0].Since
+	if since.Time().After(now) {
+		t.Errorf("resubscribe since %s is after now %s", since.Time(), now)
+	}
+}
+
+func TestRelayWatchdogUsesClock(t *testing.T) {
+	relay := newTestRelay(t, false)
+	clk := newFakeClock(time.Now())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := newRelayManager(ctx, []string{relay.URL}, time.Minute, defaultMaxClockSkew, clk)
+	replayed := make(chan struct{})
+	events := m.subscribe(ctx, nostr.Filters{{Kinds: []int{33321}}}, func() { close(replayed) })
+	go func() {
+		for range events {
+		}
+	}()
+	<-replayed
+	time.Sleep(testSettle) // The watchdog is re-armed just after EOSE is reported
+
+	// A quiet subscription is renewed when the clock, not the wall, passes the watchdog period
+	clk.Advance(59 * time.Second)
+	time.Sleep(testSettle)
+	if n := relay.subscriptions(); n != 1 {
+		t.Fatalf("%d REQs before the watchdog period, want 1", n)
+	}
+	clk.Advance(time.Second)
+	waitFor(t, 5*time.Second, "resubscribe", func() bool { return relay.subscriptions() == 2 })
+}
+
+func TestPruneSeen(t *testing.T) {
+	seen := make(map[string]nostr.Timestamp)
+	for i := range 10 {
+		seen[fmt.Sprint("old", i)] = nostr.Timestamp(100 + i)
+	}
+	for i := range 5 {
+		seen[fmt.Sprint("new", i)] = 200
+	}
+
+	pruneSeen(seen, 7)
+	if len(seen) != 7 {
+		t.Fatalf("%d entries kept, want 7", len(seen))
+	}
+	for i := range 5 {
+		if _, ok := seen[fmt.Sprint("new", i)]; !ok {
+			t.Errorf("newest event new%d pruned", i)
+		}
+	}
+	if _, ok := seen["old9"]; !ok {
+		t.Error("second newest event pruned")
+	}
+
+	// Ties at the cutoff are pruned down to the limit too
+	pruneSeen(seen, 3)
+	if len(seen) != 3 {
+		t.Errorf("%d entries kept, want 3", len(seen))
+	}
+}
+
+func TestRelayReconnectBackoff(t *testing.T) {
+	// go-nostr dials here without a test relay
+	skipUnderRace(t)
+
+	// A listener that is closed again refuses connections
+	srv := httptest.NewServer(http.NotFoundHandler())
+	down := nostr.NormalizeURL("ws" + strings.TrimPrefix(srv.URL, "http"))
+	srv.Close()
+
+	clk := newFakeClock(time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := newRelayManager(ctx, []string{down}, time.Minute, defaultMaxClockSkew, clk)
+
+	// Each failed dial doubles the wait from relayBackoffMin up to relayBackoffMax; until
+	// it elapses, connect fails without dialing
+	want := relayBackoffMin
+	for i := range 10 {
+		if _, err := m.connect(down); err == nil {
+			t.Fatal("connected to a closed listener")
+		}
+		s := m.statuses()[0]
+		if s.State != relayBackingOff || !s.NextAttempt.Equal(clk.Now().Add(want)) || s.Errors != i+1 {
+			t.Fatalf("dial %d: status = %+v, want backoff of %s", i+1, s, want)
+		}
+		clk.Advance(want - time.Second)
+		if _, err := m.connect(down); err == nil || !strings.Contains(err.Error(), "backing off") {
+			t.Fatalf("dial %d: connect during backoff = %v", i+1, err)
+		}
+		if s := m.statuses()[0]; s.Errors != i+1 {
+			t.Fatalf("dial %d: dialed during backoff", i+1)
+		}
+		clk.Advance(time.Second)
+		want = min(want*2, relayBackoffMax)
+	}
+	if s := m.statuses()[0]; s.Health != 0 || !strings.Contains(s.LastError, "connection refused") {
+		t.Errorf("status after failures = %+v", s)
+	}
+}
+
+func TestRelayStatus(t *testing.T) {
+	relay := newTestRelay(t, false)
+	clk := newFakeClock(time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := newRelayManager(ctx, []string{relay.URL}, time.Minute, defaultMaxClockSkew, clk)
+
+	// Concurrent callers share a single connection
+	conns := make([]*nostr.Relay, 8)
+	var wg sync.WaitGroup
+	for i := range conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r, err := m.connect(relay.URL)
+			if err != nil {
+				t.Errorf("connect: %v", err)
+			}
+			conns[i] = r
+		}()
+	}
+	wg.Wait()
+	for _, r := range conns[1:] {
+		if r != conns[0] {
+			t.Fatal("concurrent connects dialed more than one connection")
+		}
+	}
+
+	// One failure after one success halves the health score; the relay stays connected
+	m.fail(relay.URL, errors.New("publish: timeout"))
+	s := m.statuses()[0]
+	if s.State != relayConnected || s.Health != 50 || s.Errors != 1 || s.LastError != "publish: timeout" ||
+		!s.LastErrorAt.Equal(clk.Now()) || !s.ConnectedAt.Equal(clk.Now()) {
+		t.Errorf("status = %+v, want connected with health 50 and the error", s)
+	}
+	if got := m.summary(); !strings.HasPrefix(got, "1/1 up: ") || !strings.Contains(got, "=connected(health 50, 0ms, 1 err)") {
+		t.Errorf("summary = %q", got)
+	}
+
+	dir := t.TempDir()
+	if err := m.writeStatus(dir); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, relayStatusFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written []map[string]any
+	if err := json.Unmarshal(data, &written); err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != 1 || written[0]["url"] != relay.URL || written[0]["state"] != relayConnected ||
+		written[0]["health"] != 50.0 || written[0]["latency_ms"] != 0.0 {
+		t.Errorf("%s = %s", relayStatusFile, data)
+	}
+}
+
+func TestRelayAuthWaitUsesClock(t *testing.T) {
+	relay := newTestRelay(t, false) // Never sends a challenge
+	clk := newFakeClock(time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := newRelayManager(ctx, []string{relay.URL}, time.Minute, defaultMaxClockSkew, clk)
+	signer, err := newAuthSigner(nostr.GeneratePrivateKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.setAuth(signer, map[string]string{"*": relayAuthAlways})
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := m.connect(relay.URL)
+		done <- err
+	}()
+
+	// "always" keeps waiting for a challenge until relayAuthChallengeWait has passed on
+	// the manager's clock, however long that takes in real time
+	for waited := time.Duration(0); waited < relayAuthChallengeWait; waited += relayAuthRetryDelay {
+		waitFor(t, 5*time.Second, "auth retry timer", func() bool { return clk.Timers() > 0 })
+		select {
+		case err := <-done:
+			t.Fatalf("connect returned after %s of the %s challenge wait: %v", waited, relayAuthChallengeWait, err)
+		default:
+		}
+		clk.Advance(relayAuthRetryDelay)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("connect: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("connect still waiting for a challenge after the wait elapsed")
+	}
+	if s := m.statuses()[0]; s.AuthedAs != "" || !strings.HasPrefix(s.LastError, "auth: ") || !s.LastErrorAt.Equal(clk.Now()) {
+		t.Errorf("status = %+v, want an auth failure at the clock's time", s)
+	}
+}
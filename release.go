@@ -0,0 +1,250 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// releaseSigTag domain-separates release signatures, so no signature a release key makes
+// for another purpose (such as a nostr event id) can pass as one
+const releaseSigTag = "qube-release"
+
+// ReleaseSignature is a detached BIP-340 Schnorr signature over a binary's SHA256 hash,
+// carried in a HyperSignal as ["release_sig", <sig hex>, <release pubkey hex>]
+type ReleaseSignature struct {
+	Sig    string // 64-byte Schnorr signature, hex encoded
+	Pubkey string // Release key that produced the signature, hex encoded
+}
+
+// getReleaseSignatures returns all release_sig tags found on the event
+func getReleaseSignatures(event *nostr.Event) []ReleaseSignature {
+	var sigs []ReleaseSignature
+	for _, tag := range event.Tags {
+		if len(tag) >= 3 && tag[0] == "release_sig" {
+			sigs = append(sigs, ReleaseSignature{Sig: tag[1], Pubkey: tag[2]})
+		}
+	}
+	return sigs
+}
+
+// addReleaseSignatures appends signatures not already attached to the action
+func (a *CandidateAction) addReleaseSignatures(sigs []ReleaseSignature) {
+	for _, s := range sigs {
+		known := false
+		for _, existing := range a.ReleaseSigs {
+			if existing == s {
+				known = true
+				break
+			}
+		}
+		if !known {
+			a.ReleaseSigs = append(a.ReleaseSigs, s)
+		}
+	}
+}
+
+// schnorrSign produces a BIP-340 signature over a 32-byte digest with a hex secret key.
+// Returns the hex signature and the hex x-only public key.
+func schnorrSign(digest []byte, secretKey string) (sig, pubkey string, err error) {
+	skBytes, err := hex.DecodeString(secretKey)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid secret key: %w", err)
+	}
+
+	sk, pk := btcec.PrivKeyFromBytes(skBytes)
+	s, err := schnorr.Sign(sk, digest)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to sign: %w", err)
+	}
+	return hex.EncodeToString(s.Serialize()), hex.EncodeToString(schnorr.SerializePubKey(pk)), nil
+}
+
+// schnorrVerify checks a hex BIP-340 signature over digest by a hex x-only public key
+func schnorrVerify(digest []byte, sigHex, pubkeyHex string) bool {
+	pkBytes, err := hex.DecodeString(pubkeyHex)
+	if err != nil {
+		return false
+	}
+	pubkey, err := schnorr.ParsePubKey(pkBytes)
+	if err != nil {
+		return false
+	}
+	sigBytes, err := hex.DecodeString(sigHex)
+	if err != nil {
+		return false
+	}
+	sig, err := schnorr.ParseSignature(sigBytes)
+	if err != nil {
+		return false
+	}
+	return sig.Verify(digest, pubkey)
+}
+
+// releaseDigest returns the BIP-340 tagged hash (tag "qube-release") of the raw
+// 32-byte SHA256 hash, which is what release keys sign
+func releaseDigest(hash string) ([]byte, error) {
+	if err := validateHash(hash); err != nil {
+		return nil, err
+	}
+	raw, _ := hex.DecodeString(hash)
+
+	tag := sha256.Sum256([]byte(releaseSigTag))
+	h := sha256.New()
+	h.Write(tag[:])
+	h.Write(tag[:])
+	h.Write(raw)
+	return h.Sum(nil), nil
+}
+
+// signReleaseHash signs the release digest of hash with the given hex secret key
+func signReleaseHash(hash, secretKey string) (ReleaseSignature, error) {
+	digest, err := releaseDigest(hash)
+	if err != nil {
+		return ReleaseSignature{}, err
+	}
+
+	sig, pubkey, err := schnorrSign(digest, secretKey)
+	if err != nil {
+		return ReleaseSignature{}, err
+	}
+	return ReleaseSignature{Sig: sig, Pubkey: pubkey}, nil
+}
+
+// verifyReleaseSignature checks that at least one signature over hash was made by a trusted release key.
+// trusted holds hex pubkeys. Returns nil on the first valid signature, or an error describing why none matched.
+func verifyReleaseSignature(hash string, sigs []ReleaseSignature, trusted []string) error {
+	digest, err := releaseDigest(hash)
+	if err != nil {
+		return fmt.Errorf("hash %q: %w", hash, err)
+	}
+	if len(sigs) == 0 {
+		return fmt.Errorf("no release signature attached")
+	}
+
+	trustedSet := make(map[string]bool, len(trusted))
+	for _, pk := range trusted {
+		trustedSet[pk] = true
+	}
+
+	for _, s := range sigs {
+		if !trustedSet[s.Pubkey] {
+			log.Printf("[DEBUG] Ignoring release signature from untrusted key %s", s.Pubkey)
+			continue
+		}
+		if schnorrVerify(digest, s.Sig, s.Pubkey) {
+			return nil
+		}
+		log.Printf("[WARN] Release signature from %s does not match hash %s", s.Pubkey, hash)
+	}
+
+	return fmt.Errorf("no valid signature from a trusted release key")
+}
+
+// decodeNpubs converts a list of npubs to hex pubkeys
+func decodeNpubs(npubs []string) ([]string, error) {
+	hexKeys := make([]string, 0, len(npubs))
+	for _, npub := range npubs {
+		kind, pubkeyAny, err := nip19.Decode(npub)
+		if err != nil {
+			return nil, fmt.Errorf("invalid npub %s: %w", npub, err)
+		}
+		if kind != "npub" {
+			return nil, fmt.Errorf("expected npub but got %s: %s", kind, npub)
+		}
+		hexKeys = append(hexKeys, pubkeyAny.(string))
+	}
+	return hexKeys, nil
+}
+
+// trustedReleaseKeys returns the configured release keys as hex, leaving out any key that
+// is currently a follow: a key that can vote must not also vouch for the binary
+func trustedReleaseKeys(cfg *Config, follows *followSet) []string {
+	keys, _ := decodeNpubs(cfg.ReleaseKeys)
+	trusted := keys[:0]
+	for _, pk := range keys {
+		if follows != nil && follows.Contains(pk) {
+			log.Printf("[WARN] Ignoring release key %s, which is also a follow", pk)
+			continue
+		}
+		trusted = append(trusted, pk)
+	}
+	return trusted
+}
+
+// readReleaseKey reads a keys.json-format file holding a release key. Unlike the node
+// identity, a missing release key is an error: one is never generated on the fly.
+func readReleaseKey(path string) (Keypair, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Keypair{}, err
+	}
+	var kp Keypair
+	if err := json.Unmarshal(data, &kp); err != nil {
+		return Keypair{}, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if kp.Npub == "" || (kp.Nsec == "" && kp.Ncryptsec == "") {
+		return Keypair{}, fmt.Errorf("%s is missing its npub or secret key", path)
+	}
+	return kp, nil
+}
+
+// signReleaseCLI signs a binary hash with a dedicated release key file and prints the
+// tag value to pass to send-message via -release-sig
+func signReleaseCLI(configDir, passphraseFile string, args []string) {
+	var hash, keyFile string
+
+	flagSet := flag.NewFlagSet("sign-release", flag.ExitOnError)
+	flagSet.StringVar(&hash, "hash", "", "SHA256 hash of the release binary (required)")
+	flagSet.StringVar(&keyFile, "key", "", "Release key file in keys.json format (required)")
+	flagSet.Parse(args)
+
+	if hash == "" {
+		log.Fatal("[ERROR] Hash is required (use --hash flag)")
+	}
+	if keyFile == "" {
+		log.Fatal("[ERROR] A release key file is required (use --key flag); the voting key is never used to sign releases")
+	}
+
+	kp, err := readReleaseKey(keyFile)
+	if err != nil {
+		log.Fatalf("[ERROR] Cannot read release key: %v", err)
+	}
+	if voting, ok := readKeypair(configDir); ok && voting.Npub == kp.Npub {
+		log.Fatalf("[ERROR] %s holds the voting key from %s; sign releases with a separate key", keyFile, configDir)
+	}
+	sk := unlockKeypair(kp, passphraseFile, true)
+
+	rs, err := signReleaseHash(hash, sk)
+	if err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+
+	log.Printf("[INFO] Signed hash %s with release key %s", hash, kp.Npub)
+	fmt.Fprintf(os.Stdout, "%s:%s\n", rs.Pubkey, rs.Sig)
+}
+
+// parseReleaseSigFlag parses a "<pubkey hex>:<sig hex>" value as printed by sign-release
+func parseReleaseSigFlag(value string) (ReleaseSignature, error) {
+	pubkey, sig, ok := strings.Cut(value, ":")
+	if !ok {
+		return ReleaseSignature{}, fmt.Errorf("expected <pubkey>:<signature>, got %q", value)
+	}
+	if !nostr.IsValidPublicKey(pubkey) {
+		return ReleaseSignature{}, fmt.Errorf("invalid release pubkey %q", pubkey)
+	}
+	if b, err := hex.DecodeString(sig); err != nil || len(b) != 64 {
+		return ReleaseSignature{}, fmt.Errorf("release signature must be 128 hex characters")
+	}
+	return ReleaseSignature{Sig: sig, Pubkey: pubkey}, nil
+}
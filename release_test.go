@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReleaseSignature(t *testing.T) {
+	devs := newTestDevs(2)
+	release, voter := devs[0], devs[1]
+	hash := strings.Repeat("ab", 32)
+
+	rs, err := signReleaseHash(hash, release.sk)
+	if err != nil {
+		t.Fatalf("signReleaseHash: %v", err)
+	}
+	if err := verifyReleaseSignature(hash, []ReleaseSignature{rs}, []string{release.pk}); err != nil {
+		t.Errorf("valid signature rejected: %v", err)
+	}
+	if verifyReleaseSignature(strings.Repeat("cd", 32), []ReleaseSignature{rs}, []string{release.pk}) == nil {
+		t.Error("signature accepted for another hash")
+	}
+	if verifyReleaseSignature(hash, []ReleaseSignature{rs}, []string{voter.pk}) == nil {
+		t.Error("signature accepted from an untrusted key")
+	}
+
+	// A signature over the raw hash, e.g. a nostr event id, is not a release signature
+	raw, _ := hex.DecodeString(hash)
+	sig, pk, err := schnorrSign(raw, release.sk)
+	if err != nil {
+		t.Fatalf("schnorrSign: %v", err)
+	}
+	if verifyReleaseSignature(hash, []ReleaseSignature{{Sig: sig, Pubkey: pk}}, []string{release.pk}) == nil {
+		t.Error("signature over the raw hash accepted")
+	}
+
+	// A release key that is also a follow cannot vouch for a binary
+	cfg := &Config{ReleaseKeys: []string{release.npub}}
+	if got := trustedReleaseKeys(cfg, newFollowSet([]string{voter.pk})); len(got) != 1 || got[0] != release.pk {
+		t.Errorf("trustedReleaseKeys = %v, want [%s]", got, release.pk)
+	}
+	if got := trustedReleaseKeys(cfg, newFollowSet([]string{release.pk})); len(got) != 0 {
+		t.Errorf("trustedReleaseKeys kept a follow: %v", got)
+	}
+}
+
+func TestReadReleaseKey(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := readReleaseKey(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("missing release key file accepted")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "missing.json")); !os.IsNotExist(err) {
+		t.Error("readReleaseKey created a key file")
+	}
+
+	kp := generateKeypair()
+	data, _ := json.Marshal(kp)
+	path := filepath.Join(dir, "release.json")
+	os.WriteFile(path, data, 0600)
+	got, err := readReleaseKey(path)
+	if err != nil || got.Npub != kp.Npub {
+		t.Errorf("readReleaseKey = %v, %v", got.Npub, err)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// replayCLI runs an event archive through the ingestion and quorum logic with the current
+// config, without executing anything, and prints the decisions and the actions that would
+// have been executed. Quorum is checked where the daemon checks it: on each live vote, once
+// the relays' stored events were replayed, and on the ticks after the last event.
+func replayCLI(configDir string, verbose bool, args []string) {
+	flagSet := flag.NewFlagSet("replay", flag.ExitOnError)
+	flagSet.Parse(args)
+
+	path := filepath.Join(configDir, archiveFile)
+	switch flagSet.NArg() {
+	case 0:
+	case 1:
+		path = flagSet.Arg(0)
+	default:
+		log.Fatal("[ERROR] Usage: qube-manager replay [archive.jsonl|archive.jsonl.gz]")
+	}
+
+	records, err := readArchive(path)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to read archive %s: %v", path, err)
+	}
+
+	cfg := loadConfig(configDir)
+	follows := offlineFollows(context.Background(), configDir, &cfg)
+	history, err := readHistory(configDir)
+	if err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+
+	fmt.Printf("Replaying %d event(s) from %s (network=%s, quorum=%d, %d follows)\n",
+		len(records), path, cfg.Network, cfg.Quorum, len(follows.Pubkeys()))
+
+	// Ingestion logs every vote; the replay prints its own timeline
+	if !verbose {
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(os.Stderr)
+	}
+
+	executed := replayEvents(os.Stdout, records, &cfg, follows, history)
+	if len(executed) == 0 {
+		fmt.Println("No action would have been executed")
+		return
+	}
+	fmt.Printf("Would have executed: %s\n", strings.Join(executed, ", "))
+}
+
+// replayEvents ingests archive records into a fresh vote tally, writing the timeline of
+// decisions and quorum actions to w, and returns the keys of the actions that would have
+// been executed. history is only used to show when an action was really executed.
+func replayEvents(w io.Writer, records []archiveRecord, cfg *Config, follows *followSet, history *History) []string {
+	var (
+		state    = newSignalState()
+		replayed = &History{Entries: make(map[string]*HistoryEntry)} // In memory only
+		refused  = make(map[string]bool)
+		accepted int
+		differ   int
+		executed []string
+	)
+
+	// check runs one quorum check at time at, reporting whether it executed an action
+	check := func(at time.Time) bool {
+		state.mu.Lock()
+		latest, votes := quorumActionLocked(state, cfg, replayed, follows)
+		state.mu.Unlock()
+		if latest == nil {
+			return false
+		}
+
+		if len(cfg.ReleaseKeys) > 0 {
+			trusted := trustedReleaseKeys(cfg, follows)
+			if err := verifyReleaseSignature(latest.Hash, latest.ReleaseSigs, trusted); err != nil {
+				if !refused[latest.Key] {
+					fmt.Fprintf(w, "%s  REFUSE  %s: release signature verification failed: %v\n",
+						formatReplayTime(at), latest.Key, err)
+					refused[latest.Key] = true
+				}
+				return false
+			}
+		}
+
+		voters := voterNpubs(votes[latest.Key])
+		replayed.Add(latest.Key, HistoryEntry{ExecutedAt: at, Type: latest.Type, Version: latest.Version.Original()})
+		executed = append(executed, latest.Key)
+
+		note := "not in history.yaml"
+		if e := history.Entries[latest.Key]; e != nil {
+			note = "history.yaml: executed " + formatReplayTime(e.ExecutedAt)
+		}
+		fmt.Fprintf(w, "%s  EXECUTE %s with %d/%d votes (%s); %s\n",
+			formatReplayTime(at), latest.Key, len(voters), cfg.Quorum, strings.Join(voters, ", "), note)
+		return true
+	}
+
+	// Each check executes only the highest action at quorum that is not in history yet; the
+	// daemon's next ticks execute the others, so checks are repeated until none is left
+	checkAll := func(at time.Time) {
+		for check(at) {
+		}
+	}
+
+	for i := range records {
+		rec := &records[i]
+		ev := &rec.Event
+		r := state.ingest(ev, cfg, follows.Snapshot(), rec.ReceivedAt)
+
+		var line string
+		if r == nil {
+			accepted++
+			state.mu.RLock()
+			key := state.signalActionMap[ev.PubKey]
+			votes := len(state.votes[key])
+			state.mu.RUnlock()
+			line = fmt.Sprintf("accepted %s %s from %s via %s (%s: %d/%d)",
+				getTagValue(ev, "action"), getTagValue(ev, "version"), shortKey(ev.PubKey), rec.Source,
+				key, votes, cfg.Quorum)
+		} else {
+			line = fmt.Sprintf("rejected %s from %s via %s: %s", ev.ID, shortKey(ev.PubKey), rec.Source, r)
+		}
+
+		// A signal re-read from signals.jsonl after a restart is a duplicate in one continuous replay
+		decision := archiveAccepted
+		if r != nil {
+			decision = archiveRejected
+		}
+		if decision != rec.Decision && (r == nil || r.Reason != rejectDuplicate) {
+			differ++
+			archived := rec.Decision
+			if rec.Reason != "" {
+				archived += " " + rec.Reason
+			}
+			line += fmt.Sprintf(" [archived: %s]", archived)
+		}
+		fmt.Fprintf(w, "%s  %s\n", formatReplayTime(rec.ReceivedAt), line)
+
+		// The daemon checks on each live vote, and once after replaying stored events
+		backlogDone := !rec.Live && (i+1 == len(records) || records[i+1].Live)
+		if (r == nil && rec.Live) || backlogDone {
+			checkAll(rec.ReceivedAt)
+		}
+	}
+	if len(records) > 0 {
+		checkAll(records[len(records)-1].ReceivedAt)
+	}
+
+	fmt.Fprintf(w, "%d event(s): %d accepted, %d rejected, %d decision(s) differ from the archive\n",
+		len(records), accepted, len(records)-accepted, differ)
+	return executed
+}
+
+// formatReplayTime formats timestamps in the replay output
+func formatReplayTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestReplayTwoActionsAtQuorum(t *testing.T) {
+	start := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	devs := newTestDevs(4)
+	cfg := Config{Quorum: 2, Network: "hqz", MaxClockSkew: defaultMaxClockSkew}
+	follows := newFollowSet([]string{devs[0].pk, devs[1].pk, devs[2].pk, devs[3].pk})
+
+	record := func(i int, d testDev, version string, live bool) archiveRecord {
+		at := start.Add(time.Duration(i) * time.Minute)
+		return archiveRecord{ReceivedAt: at, Source: "wss://relay.example.com", Live: live,
+			Decision: archiveAccepted, Event: d.signal(t, version, "hqz", nostr.Timestamp(at.Unix()))}
+	}
+
+	tests := []struct {
+		name    string
+		records []archiveRecord
+		want    []string
+	}{
+		// Both reach quorum in the relays' stored events, checked once they are replayed:
+		// the first check executes 1.2.0 and the next tick 1.1.0
+		{"stored events", []archiveRecord{
+			record(0, devs[0], "1.1.0", false), record(1, devs[1], "1.1.0", false),
+			record(2, devs[2], "1.2.0", false), record(3, devs[3], "1.2.0", false),
+		}, []string{"upgrade:1.2.0", "upgrade:1.1.0"}},
+		// Live votes are checked as they arrive
+		{"live votes", []archiveRecord{
+			record(0, devs[2], "1.2.0", true), record(1, devs[3], "1.2.0", true),
+			record(2, devs[0], "1.1.0", true), record(3, devs[1], "1.1.0", true),
+		}, []string{"upgrade:1.2.0", "upgrade:1.1.0"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var out strings.Builder
+			history := &History{Entries: make(map[string]*HistoryEntry)}
+			executed := replayEvents(&out, tc.records, &cfg, follows, history)
+			if !slices.Equal(executed, tc.want) {
+				t.Errorf("executed %v, want %v\n%s", executed, tc.want, out.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"gopkg.in/yaml.v3"
+)
+
+// kindKeyRotation is the addressable event kind used to announce and endorse key rotations
+const kindKeyRotation = 33322
+
+// d tags distinguishing a rotation announcement (by the old key) from an endorsement
+// (by another follow; one per rotated key, suffixed with the old hex pubkey)
+const (
+	rotationDTag          = "hyperqube-rotation"
+	rotationEndorseDTagPx = "hyperqube-rotation-endorse:"
+)
+
+// Rejection reasons specific to key rotation events
+const (
+	rejectInvalidRotation = "invalid_rotation"
+	rejectBadCountersig   = "bad_countersig"
+)
+
+// rotationDigest is the message the new key countersigns, binding it to the old key
+func rotationDigest(oldPk, newPk string) []byte {
+	h := sha256.Sum256([]byte("qube-manager key rotation:" + oldPk + ":" + newPk))
+	return h[:]
+}
+
+// RotationRecord is an applied key rotation persisted in rotations.yaml
+type RotationRecord struct {
+	New         string    `yaml:"new"`                 // New hex pubkey
+	Endorsers   []string  `yaml:"endorsers,omitempty"` // npubs of follows that endorsed the rotation
+	AnnouncedAt time.Time `yaml:"announced_at"`        // created_at of the announcement event
+	AppliedAt   time.Time `yaml:"applied_at"`          // When this node applied the rotation
+	EventID     string    `yaml:"event"`               // ID of the announcement event
+}
+
+// rotationStore persists applied rotations so restarts keep the effective follow set
+type rotationStore struct {
+	Rotations map[string]*RotationRecord `yaml:"rotations"` // key: old hex pubkey
+	path      string                     // rotations file path (not in YAML)
+}
+
+// loadRotations reads rotations.yaml, returning an empty store if it does not exist
+func loadRotations(configDir string) *rotationStore {
+	path := filepath.Join(configDir, "rotations.yaml")
+	s := &rotationStore{Rotations: make(map[string]*RotationRecord), path: path}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s
+	}
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to read rotations file %s: %v", path, err)
+	}
+	if err := yaml.Unmarshal(data, s); err != nil {
+		log.Fatalf("[ERROR] Failed to parse rotations file %s: %v", path, err)
+	}
+	if s.Rotations == nil {
+		s.Rotations = make(map[string]*RotationRecord)
+	}
+	log.Printf("[INFO] Loaded %d applied key rotation(s) from %s", len(s.Rotations), path)
+	return s
+}
+
+// Save writes the applied rotations atomically
+func (s *rotationStore) Save() error {
+	data, err := yaml.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, data, 0644)
+}
+
+// pendingRotation is a valid, countersigned announcement awaiting endorsements
+type pendingRotation struct {
+	New       string
+	EventID   string
+	CreatedAt nostr.Timestamp
+}
+
+// endorsement is one follow's latest endorsement of a rotation
+type endorsement struct {
+	New       string
+	CreatedAt nostr.Timestamp
+}
+
+// rotationTracker validates rotation announcements and endorsements from current
+// follows and applies a rotation to the follow set once enough endorsements arrive
+type rotationTracker struct {
+	mu           sync.Mutex
+	store        *rotationStore
+	follows      *followSet
+	quorum       int                               // Endorsements required from other follows
+	pending      map[string]pendingRotation        // old hex pubkey -> announcement
+	endorsements map[string]map[string]endorsement // old hex pubkey -> endorser -> endorsement
+}
+
+// newRotationTracker creates a tracker and applies previously persisted rotations to follows
+func newRotationTracker(store *rotationStore, follows *followSet, quorum int) *rotationTracker {
+	for oldPk, rec := range store.Rotations {
+		follows.ApplyRotation(oldPk, rec.New)
+	}
+	return &rotationTracker{
+		store:        store,
+		follows:      follows,
+		quorum:       quorum,
+		pending:      make(map[string]pendingRotation),
+		endorsements: make(map[string]map[string]endorsement),
+	}
+}
+
+// handle processes a kind=33322 event. Returns nil if it was recorded, or a rejection.
+func (t *rotationTracker) handle(ev *nostr.Event, config *Config, now time.Time) *rejection {
+	if ev.Kind != kindKeyRotation {
+		return &rejection{rejectWrongKind, fmt.Sprintf("kind %d", ev.Kind), false}
+	}
+	if r := validateFollowEvent(ev, t.follows.Snapshot(), now, config.MaxClockSkew); r != nil {
+		return r
+	}
+
+	oldPk := ev.PubKey
+	newPk := getTagValue(ev, "new_pubkey")
+	dTag := getTagValue(ev, "d")
+	if dTag != rotationDTag {
+		if !strings.HasPrefix(dTag, rotationEndorseDTagPx) {
+			return &rejection{rejectWrongDTag, fmt.Sprintf("d tag %q", dTag), false}
+		}
+		oldPk = getTagValue(ev, "old_pubkey")
+		if dTag != rotationEndorseDTagPx+oldPk {
+			return &rejection{rejectInvalidRotation, "endorsement d tag does not match old_pubkey", true}
+		}
+	}
+
+	if !nostr.IsValidPublicKey(newPk) || !nostr.IsValidPublicKey(oldPk) || newPk == oldPk {
+		return &rejection{rejectInvalidRotation, fmt.Sprintf("invalid rotation %s -> %s", oldPk, newPk), true}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, applied := t.store.Rotations[oldPk]; applied {
+		return &rejection{rejectDuplicate, fmt.Sprintf("rotation of %s already applied", shortKey(oldPk)), false}
+	}
+
+	if dTag == rotationDTag {
+		// Announcement by the old key, countersigned by the new key
+		if !schnorrVerify(rotationDigest(oldPk, newPk), getTagValue(ev, "countersig"), newPk) {
+			return &rejection{rejectBadCountersig, fmt.Sprintf("new key %s did not countersign rotation", shortKey(newPk)), true}
+		}
+		if prev, ok := t.pending[oldPk]; ok && prev.CreatedAt >= ev.CreatedAt {
+			return &rejection{rejectStaleSignal, "older rotation announcement", false}
+		}
+		t.pending[oldPk] = pendingRotation{New: newPk, EventID: ev.ID, CreatedAt: ev.CreatedAt}
+		log.Printf("[INFO] Key rotation announced: %s -> %s", shortKey(oldPk), shortKey(newPk))
+	} else {
+		// Endorsement by another follow
+		if ev.PubKey == newPk {
+			return &rejection{rejectInvalidRotation, "rotated keys cannot endorse their own rotation", true}
+		}
+		if t.endorsements[oldPk] == nil {
+			t.endorsements[oldPk] = make(map[string]endorsement)
+		}
+		if prev, ok := t.endorsements[oldPk][ev.PubKey]; ok && prev.CreatedAt >= ev.CreatedAt {
+			return &rejection{rejectStaleSignal, "older rotation endorsement", false}
+		}
+		t.endorsements[oldPk][ev.PubKey] = endorsement{New: newPk, CreatedAt: ev.CreatedAt}
+		log.Printf("[INFO] Key rotation %s -> %s endorsed by %s", shortKey(oldPk), shortKey(newPk), shortKey(ev.PubKey))
+	}
+
+	t.tryApplyLocked(oldPk, now)
+	return nil
+}
+
+// tryApplyLocked applies the pending rotation of oldPk at now if it has enough endorsements;
+// t.mu must be held
+func (t *rotationTracker) tryApplyLocked(oldPk string, now time.Time) {
+	p, ok := t.pending[oldPk]
+	if !ok {
+		return
+	}
+
+	follows := t.follows.Snapshot()
+	var endorsers []string
+	for endorser, e := range t.endorsements[oldPk] {
+		if e.New == p.New && endorser != oldPk && endorser != p.New && follows[endorser] {
+			endorsers = append(endorsers, endorser)
+		}
+	}
+	if len(endorsers) < t.quorum {
+		log.Printf("[INFO] Key rotation %s -> %s has %d/%d endorsement(s)",
+			shortKey(oldPk), shortKey(p.New), len(endorsers), t.quorum)
+		return
+	}
+
+	t.store.Rotations[oldPk] = &RotationRecord{
+		New:         p.New,
+		Endorsers:   voterNpubs(toSet(endorsers)),
+		AnnouncedAt: p.CreatedAt.Time().UTC(),
+		AppliedAt:   now.UTC().Truncate(time.Second),
+		EventID:     p.EventID,
+	}
+	delete(t.pending, oldPk)
+	delete(t.endorsements, oldPk)
+
+	if err := t.store.Save(); err != nil {
+		log.Printf("[ERROR] Failed to save rotations file %s: %v", t.store.path, err)
+	}
+	t.follows.ApplyRotation(oldPk, p.New)
+}
+
+// toSet converts a list of keys to a set
+func toSet(keys []string) map[string]bool {
+	set := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		set[k] = true
+	}
+	return set
+}
+
+// buildRotationAnnouncement creates the unsigned announcement event for rotating the
+// current key (oldPk) to newSecretKey, including the new key's countersignature
+func buildRotationAnnouncement(oldPk, newSecretKey string) (nostr.Event, string, error) {
+	newPk, err := nostr.GetPublicKey(newSecretKey)
+	if err != nil {
+		return nostr.Event{}, "", fmt.Errorf("invalid new secret key: %w", err)
+	}
+	countersig, _, err := schnorrSign(rotationDigest(oldPk, newPk), newSecretKey)
+	if err != nil {
+		return nostr.Event{}, "", err
+	}
+
+	newNpub, _ := nip19.EncodePublicKey(newPk)
+	return nostr.Event{
+		CreatedAt: nostr.Now(),
+		Kind:      kindKeyRotation,
+		Tags: nostr.Tags{
+			{"d", rotationDTag},
+			{"new_pubkey", newPk},
+			{"countersig", countersig},
+		},
+		Content: fmt.Sprintf("[qube-manager] This key is rotating to %s.", newNpub),
+	}, newPk, nil
+}
+
+// buildRotationEndorsement creates the unsigned endorsement event for a rotation oldPk -> newPk
+func buildRotationEndorsement(oldPk, newPk string) nostr.Event {
+	oldNpub, _ := nip19.EncodePublicKey(oldPk)
+	newNpub, _ := nip19.EncodePublicKey(newPk)
+	return nostr.Event{
+		CreatedAt: nostr.Now(),
+		Kind:      kindKeyRotation,
+		Tags: nostr.Tags{
+			{"d", rotationEndorseDTagPx + oldPk},
+			{"old_pubkey", oldPk},
+			{"new_pubkey", newPk},
+		},
+		Content: fmt.Sprintf("[qube-manager] I endorse the key rotation %s -> %s.", oldNpub, newNpub),
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// rotationAnnouncement returns d's signed announcement of a rotation to newPk, countersigned
+// with counterSk, which only matches newPk for a valid announcement
+func (d testDev) rotationAnnouncement(t *testing.T, newPk, counterSk string, createdAt nostr.Timestamp) *nostr.Event {
+	t.Helper()
+	ev, _, err := buildRotationAnnouncement(d.pk, counterSk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev.CreatedAt = createdAt
+	for _, tag := range ev.Tags {
+		if tag[0] == "new_pubkey" {
+			tag[1] = newPk
+		}
+	}
+	if err := ev.Sign(d.sk); err != nil {
+		t.Fatalf("sign rotation announcement: %v", err)
+	}
+	return &ev
+}
+
+// rotationEndorsement returns d's signed endorsement of the rotation oldPk -> newPk
+func (d testDev) rotationEndorsement(t *testing.T, oldPk, newPk string, createdAt nostr.Timestamp) *nostr.Event {
+	t.Helper()
+	ev := buildRotationEndorsement(oldPk, newPk)
+	ev.CreatedAt = createdAt
+	if err := ev.Sign(d.sk); err != nil {
+		t.Fatalf("sign rotation endorsement: %v", err)
+	}
+	return &ev
+}
+
+func TestRotationCountersignature(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 30, 0, time.UTC)
+	ts := nostr.Timestamp(now.Unix())
+	config := Config{MaxClockSkew: defaultMaxClockSkew}
+	devs := newTestDevs(4)
+	old, next, other, outsider := devs[0], devs[1], devs[2], devs[3]
+	follows := newFollowSet([]string{old.pk, other.pk})
+	tracker := newRotationTracker(loadRotations(t.TempDir()), follows, 0)
+
+	// The new key must countersign: a signature by any other key is refused
+	if r := tracker.handle(old.rotationAnnouncement(t, next.pk, outsider.sk, ts), &config, now); r == nil || r.Reason != rejectBadCountersig {
+		t.Fatalf("announcement countersigned by another key: %v, want %s", r, rejectBadCountersig)
+	}
+	// Only a follow can announce its own rotation
+	if r := tracker.handle(outsider.rotationAnnouncement(t, next.pk, next.sk, ts), &config, now); r == nil || r.Reason != rejectUnknownAuthor {
+		t.Fatalf("announcement by a non-follow: %v, want %s", r, rejectUnknownAuthor)
+	}
+	if follows.Contains(next.pk) {
+		t.Fatal("rotation applied from a refused announcement")
+	}
+
+	// Without a rotation quorum, a countersigned announcement applies at once
+	if r := tracker.handle(old.rotationAnnouncement(t, next.pk, next.sk, ts), &config, now); r != nil {
+		t.Fatalf("valid announcement rejected: %v", r)
+	}
+	if !follows.Contains(next.pk) || follows.Contains(old.pk) {
+		t.Errorf("follows = %v, want the old key replaced by the new one", follows.Pubkeys())
+	}
+	if rec := tracker.store.Rotations[old.pk]; rec == nil || !rec.AppliedAt.Equal(now.Truncate(time.Second)) {
+		t.Errorf("rotation record = %+v, want applied at %s", rec, now)
+	}
+}
+
+func TestRotationQuorum(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	ts := nostr.Timestamp(now.Unix())
+	config := Config{MaxClockSkew: defaultMaxClockSkew}
+	devs := newTestDevs(6)
+	old, next, b, c, d, wrong := devs[0], devs[1], devs[2], devs[3], devs[4], devs[5]
+	dir := t.TempDir()
+	follows := newFollowSet([]string{old.pk, b.pk, c.pk, d.pk})
+	tracker := newRotationTracker(loadRotations(dir), follows, 2)
+
+	if r := tracker.handle(old.rotationAnnouncement(t, next.pk, next.sk, ts), &config, now); r != nil {
+		t.Fatalf("valid announcement rejected: %v", r)
+	}
+	// An endorsement of a different new key does not count
+	if r := tracker.handle(b.rotationEndorsement(t, old.pk, wrong.pk, ts), &config, now); r != nil {
+		t.Fatalf("endorsement rejected: %v", r)
+	}
+	if r := tracker.handle(c.rotationEndorsement(t, old.pk, next.pk, ts), &config, now); r != nil {
+		t.Fatalf("endorsement rejected: %v", r)
+	}
+	if follows.Contains(next.pk) {
+		t.Fatal("rotation applied with one matching endorsement of two")
+	}
+
+	// The old key cannot endorse itself, and an older endorsement does not replace a newer one
+	if r := tracker.handle(old.rotationEndorsement(t, old.pk, next.pk, ts), &config, now); r != nil {
+		t.Fatalf("endorsement rejected: %v", r)
+	}
+	if r := tracker.handle(b.rotationEndorsement(t, old.pk, next.pk, ts-1), &config, now); r == nil || r.Reason != rejectStaleSignal {
+		t.Fatalf("older endorsement: %v, want %s", r, rejectStaleSignal)
+	}
+	if follows.Contains(next.pk) {
+		t.Fatal("rotation applied without a second endorsement from another follow")
+	}
+
+	// b changes its endorsement to the announced key: quorum reached
+	if r := tracker.handle(b.rotationEndorsement(t, old.pk, next.pk, ts+1), &config, now); r != nil {
+		t.Fatalf("endorsement rejected: %v", r)
+	}
+	if !follows.Contains(next.pk) || follows.Contains(old.pk) {
+		t.Fatalf("follows = %v, want the rotation applied", follows.Pubkeys())
+	}
+
+	// The rotation is persisted and reapplied after a restart
+	rec := loadRotations(dir).Rotations[old.pk]
+	if rec == nil || rec.New != next.pk || len(rec.Endorsers) != 2 {
+		t.Fatalf("persisted rotation = %+v, want %s endorsed by 2", rec, next.pk)
+	}
+	restarted := newFollowSet([]string{old.pk, b.pk, c.pk, d.pk})
+	newRotationTracker(loadRotations(dir), restarted, 2)
+	if !restarted.Contains(next.pk) || restarted.Contains(old.pk) {
+		t.Errorf("follows after restart = %v, want the rotation applied", restarted.Pubkeys())
+	}
+	if r := tracker.handle(d.rotationEndorsement(t, old.pk, next.pk, ts), &config, now); r == nil || r.Reason != rejectDuplicate {
+		t.Errorf("endorsement of an applied rotation: %v, want %s", r, rejectDuplicate)
+	}
+}
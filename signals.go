@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// signalsFile holds the HyperSignals the daemon currently counts, one signed event per line
+const signalsFile = "signals.jsonl"
+
+// readEventsJSONL reads signed events, one JSON object per line; blank lines are skipped
+func readEventsJSONL(r io.Reader) ([]nostr.Event, error) {
+	var events []nostr.Event
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		var ev nostr.Event
+		if err := json.Unmarshal(data, &ev); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		events = append(events, ev)
+	}
+	return events, scanner.Err()
+}
+
+// writeEventsJSONL writes events one JSON object per line
+func writeEventsJSONL(w io.Writer, events []nostr.Event) error {
+	enc := json.NewEncoder(w)
+	for _, ev := range events {
+		if err := enc.Encode(ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sortEvents orders events oldest first, so the newest signal of each developer wins
+// when they are ingested
+func sortEvents(events []nostr.Event) {
+	sort.SliceStable(events, func(i, j int) bool { return events[i].CreatedAt < events[j].CreatedAt })
+}
+
+// signalsInbox is the directory import-signals queues signals in. Only the daemon
+// rewrites signals.jsonl; it ingests the queued files and removes them once saved.
+const signalsInbox = "signals-inbox"
+
+// signalStore persists the counted HyperSignals in signals.jsonl, so votes survive
+// restarts, and queues signals imported on a machine without relay access for the daemon
+type signalStore struct {
+	mu    sync.Mutex
+	path  string
+	inbox string
+}
+
+// newSignalStore returns the store in configDir
+func newSignalStore(configDir string) *signalStore {
+	return &signalStore{
+		path:  filepath.Join(configDir, signalsFile),
+		inbox: filepath.Join(configDir, signalsInbox),
+	}
+}
+
+// load returns the stored signals; a missing file holds none
+func (s *signalStore) load() ([]nostr.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return readEventsPath(s.path)
+}
+
+// readEventsPath reads a JSONL events file; a missing file holds none
+func readEventsPath(path string) ([]nostr.Event, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	events, err := readEventsJSONL(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return events, nil
+}
+
+// save replaces the stored signals with those held in state, atomically. The
+// snapshot is taken under the store lock, so concurrent saves never write an older one last.
+func (s *signalStore) save(state *signalState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var buf bytes.Buffer
+	if err := writeEventsJSONL(&buf, state.held()); err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, buf.Bytes(), 0644)
+}
+
+// queue adds events to the inbox as a new file. The file appears complete or not at
+// all, so the daemon never reads a partial import.
+func (s *signalStore) queue(events []nostr.Event) error {
+	if err := os.MkdirAll(s.inbox, 0755); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := writeEventsJSONL(&buf, events); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%d.jsonl", time.Now().UnixNano(), os.Getpid())
+	return writeFileAtomic(filepath.Join(s.inbox, name), buf.Bytes(), 0644)
+}
+
+// loadInbox returns the queued signals and the files holding them, oldest file first.
+// Temp files of imports still being written are skipped. A file that does not parse is
+// moved aside to <name>.invalid, so it never blocks the imports queued after it.
+func (s *signalStore) loadInbox() ([]nostr.Event, []string, error) {
+	entries, err := os.ReadDir(s.inbox)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var events []nostr.Event
+	var files []string
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") || filepath.Ext(e.Name()) != ".jsonl" {
+			continue
+		}
+		path := filepath.Join(s.inbox, e.Name())
+		queued, err := readEventsPath(path)
+		if err != nil {
+			invalid := path + ".invalid"
+			if renameErr := os.Rename(path, invalid); renameErr != nil {
+				log.Printf("[WARN] Skipping unreadable imported signals %s: %v (failed to move it aside: %v)", path, err, renameErr)
+				continue
+			}
+			log.Printf("[WARN] Moved unreadable imported signals to %s: %v", invalid, err)
+			continue
+		}
+		events = append(events, queued...)
+		files = append(files, path)
+	}
+	return events, files, nil
+}
+
+// clearInbox removes queued files once their signals are saved in signals.jsonl
+func (s *signalStore) clearInbox(files []string) {
+	for _, path := range files {
+		if err := os.Remove(path); err != nil {
+			log.Printf("[WARN] Failed to remove imported signals %s: %v", path, err)
+		}
+	}
+}
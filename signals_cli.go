@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// signalFetchTimeout bounds fetching the current signals from the relays for export
+const signalFetchTimeout = 15 * time.Second
+
+// offlineFollows returns the follows for commands run outside the daemon: the configured
+// follows, NIP-05 identifiers resolved when reachable, with applied key rotations.
+// Follows added by signed follow lists are only known to the daemon.
+func offlineFollows(ctx context.Context, configDir string, cfg *Config) *followSet {
+	follows := newFollowSet(newNIP05Verifier(cfg.Follows, cfg.NIP05Strict).resolve(ctx))
+	newRotationTracker(loadRotations(configDir), follows, cfg.RotationQuorum)
+	return follows
+}
+
+// readEventsFile reads a JSONL event file, or stdin for "-"
+func readEventsFile(path string) ([]nostr.Event, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	return readEventsJSONL(r)
+}
+
+// writeEventsFile writes events as JSONL to path, or to stdout when path is empty
+func writeEventsFile(path string, events []nostr.Event) error {
+	if path == "" {
+		return writeEventsJSONL(os.Stdout, events)
+	}
+	var buf bytes.Buffer
+	if err := writeEventsJSONL(&buf, events); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, buf.Bytes(), 0644)
+}
+
+// exportSignalsCLI writes the HyperSignals currently counted by this node as JSONL, for
+// import-signals on a pillar without relay access
+func exportSignalsCLI(configDir string, args []string) {
+	var (
+		output string
+		fetch  bool
+	)
+
+	flagSet := flag.NewFlagSet("export-signals", flag.ExitOnError)
+	flagSet.StringVar(&output, "o", "", "Write to this file instead of stdout")
+	flagSet.BoolVar(&fetch, "fetch", false, "Also fetch the follows' current signals from the configured relays")
+	flagSet.Parse(args)
+
+	cfg := loadConfig(configDir)
+	ctx := context.Background()
+	follows := offlineFollows(ctx, configDir, &cfg)
+
+	// Signals queued by import-signals count too, even if no daemon has ingested them yet
+	store := newSignalStore(configDir)
+	events, err := store.load()
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to read stored signals: %v", err)
+	}
+	queued, _, err := store.loadInbox()
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to read imported signals: %v", err)
+	}
+	events = append(events, queued...)
+
+	if fetch {
+		fetchCtx, cancel := context.WithTimeout(ctx, signalFetchTimeout)
+		pool := nostr.NewSimplePool(fetchCtx)
+		filter := nostr.Filter{
+			Authors: follows.Pubkeys(),
+			Kinds:   []int{33321},
+			Tags:    nostr.TagMap{"d": []string{"hyperqube"}},
+		}
+		fetched := 0
+		for re := range pool.FetchMany(fetchCtx, cfg.Relays, filter) {
+			events = append(events, *re.Event)
+			fetched++
+		}
+		cancel()
+		log.Printf("[INFO] Fetched %d signal(s) from %d relay(s)", fetched, len(cfg.Relays))
+	}
+
+	// Pass everything through ingestion, so only the newest valid signal per follow is exported
+	state := newSignalState()
+	sortEvents(events)
+	for i := range events {
+		state.ingest(&events[i], &cfg, follows.Snapshot(), time.Now())
+	}
+	held := state.held()
+
+	if err := writeEventsFile(output, held); err != nil {
+		log.Fatalf("[ERROR] Failed to write signals: %v", err)
+	}
+	log.Printf("[INFO] Exported %d signal(s)", len(held))
+}
+
+// importSignalsCLI checks signed HyperSignals from a JSONL file with the same signature,
+// follow and network checks as events from relays, and queues the accepted ones in the
+// signals inbox. The daemon ingests them at start or at its next quorum check; only the
+// daemon rewrites signals.jsonl, so an import is never overwritten by a concurrent save.
+func importSignalsCLI(configDir string, args []string) {
+	flagSet := flag.NewFlagSet("import-signals", flag.ExitOnError)
+	flagSet.Parse(args)
+	if flagSet.NArg() != 1 {
+		log.Fatal("[ERROR] Usage: qube-manager import-signals <file.jsonl|->")
+	}
+
+	imported, err := readEventsFile(flagSet.Arg(0))
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to read %s: %v", flagSet.Arg(0), err)
+	}
+
+	cfg := loadConfig(configDir)
+	follows := offlineFollows(context.Background(), configDir, &cfg)
+	store := newSignalStore(configDir)
+	stored, err := store.load()
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to read stored signals: %v", err)
+	}
+	queued, _, err := store.loadInbox()
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to read imported signals: %v", err)
+	}
+
+	// Signals already stored or queued only decide which imported ones are newer
+	state := newSignalState()
+	now := time.Now()
+	known := append(stored, queued...)
+	sortEvents(known)
+	for i := range known {
+		state.ingest(&known[i], &cfg, follows.Snapshot(), now)
+	}
+
+	sortEvents(imported)
+	var accepted []nostr.Event
+	for i := range imported {
+		ev := &imported[i]
+		if r := state.ingest(ev, &cfg, follows.Snapshot(), now); r != nil {
+			fmt.Printf("rejected %s: %s\n", ev.ID, r)
+			continue
+		}
+		fmt.Printf("accepted %s: %s %s from %s\n", ev.ID, getTagValue(ev, "action"), getTagValue(ev, "version"), shortKey(ev.PubKey))
+		accepted = append(accepted, *ev)
+	}
+
+	if len(accepted) == 0 {
+		fmt.Printf("No new signals imported (%d rejected)\n", len(imported))
+		return
+	}
+	if err := store.queue(accepted); err != nil {
+		log.Fatalf("[ERROR] Failed to queue signals: %v", err)
+	}
+	fmt.Printf("Imported %d signal(s), %d rejected; the daemon picks them up at start or at its next quorum check\n",
+		len(accepted), len(imported)-len(accepted))
+}
+
+// exportStatusCLI writes the kind=3333 status events still awaiting relay acknowledgement
+// as JSONL, for publish-status on a connected machine
+func exportStatusCLI(configDir string, args []string) {
+	var output string
+
+	flagSet := flag.NewFlagSet("export-status", flag.ExitOnError)
+	flagSet.StringVar(&output, "o", "", "Write to this file instead of stdout")
+	flagSet.Parse(args)
+
+	outbox := loadOutbox(configDir, nil, nil, realClock{})
+	events := make([]nostr.Event, 0, len(outbox.Entries))
+	for _, e := range outbox.Entries {
+		events = append(events, e.Event)
+	}
+
+	if err := writeEventsFile(output, events); err != nil {
+		log.Fatalf("[ERROR] Failed to write status events: %v", err)
+	}
+	log.Printf("[INFO] Exported %d pending status event(s)", len(events))
+}
+
+// publishStatusCLI publishes pre-signed kind=3333 status events from a JSONL file, as
+// written by export-status on a pillar without relay access, to the configured relays
+func publishStatusCLI(configDir, passphraseFile string, args []string) {
+	flagSet := flag.NewFlagSet("publish-status", flag.ExitOnError)
+	flagSet.Parse(args)
+	if flagSet.NArg() != 1 {
+		log.Fatal("[ERROR] Usage: qube-manager publish-status <file.jsonl|->")
+	}
+
+	events, err := readEventsFile(flagSet.Arg(0))
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to read %s: %v", flagSet.Arg(0), err)
+	}
+
+	cfg := loadConfig(configDir)
+	if len(cfg.Relays) == 0 {
+		log.Fatal("[ERROR] No relays configured")
+	}
+
+	// The events keep the pillar's signature; this machine's key only answers AUTH challenges
+	_, privKey := loadSecretKey(configDir, passphraseFile, true)
+	signer, err := newSigner(context.Background(), privKey, cfg.Bunker)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to set up signer: %v", err)
+	}
+
+	published := 0
+	for _, ev := range events {
+		if ok, _ := ev.CheckSignature(); !ok || ev.GetID() != ev.ID || ev.Kind != 3333 {
+			log.Printf("[WARN] Skipping event %s: not a validly signed kind=3333 status event", ev.ID)
+			continue
+		}
+		if publishEvent(cfg.Relays, signer, ev) > 0 {
+			published++
+		}
+	}
+	log.Printf("[INFO] Published %d of %d status event(s)", published, len(events))
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestLoadInboxSkipsInvalidFile(t *testing.T) {
+	dir := t.TempDir()
+	store := newSignalStore(dir)
+	dev := newTestDevs(1)[0]
+	ev := dev.signal(t, "1.1.0", "hqz", nostr.Timestamp(time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC).Unix()))
+	if err := store.queue([]nostr.Event{ev}); err != nil {
+		t.Fatal(err)
+	}
+	corrupt := filepath.Join(dir, signalsInbox, "0-1.jsonl")
+	if err := os.WriteFile(corrupt, []byte("{\"id\": \"truncated\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The corrupt file, queued first, does not keep the good one from being ingested
+	events, files, err := store.loadInbox()
+	if err != nil {
+		t.Fatalf("loadInbox: %v", err)
+	}
+	if len(events) != 1 || events[0].ID != ev.ID {
+		t.Errorf("events = %v, want the queued signal", events)
+	}
+	if len(files) != 1 || files[0] == corrupt {
+		t.Errorf("files = %v, want only the good file", files)
+	}
+	if _, err := os.Stat(corrupt + ".invalid"); err != nil {
+		t.Errorf("corrupt file not moved aside: %v", err)
+	}
+	if _, err := os.Stat(corrupt); !os.IsNotExist(err) {
+		t.Errorf("corrupt file still queued: %v", err)
+	}
+
+	// Once the good file is cleared the inbox is empty; the moved file is not read again
+	store.clearInbox(files)
+	if events, files, err := store.loadInbox(); err != nil || len(events) != 0 || len(files) != 0 {
+		t.Errorf("loadInbox after clearing = %v, %v, %v", events, files, err)
+	}
+}
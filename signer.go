@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/keyer"
+	"github.com/nbd-wtf/go-nostr/nip46"
+)
+
+// signTimeout bounds how long we wait for a signature, which for a remote bunker
+// includes the time the developer takes to approve the request
+const signTimeout = 2 * time.Minute
+
+// newSigner returns the signer used for events published by this process.
+// When bunkerURL is set, signing is delegated over NIP-46 to a remote bunker and the
+// local secret key only identifies this client session; otherwise the local key signs.
+func newSigner(ctx context.Context, secretKey, bunkerURL string) (nostr.Signer, error) {
+	if bunkerURL == "" {
+		return keyer.NewPlainKeySigner(secretKey)
+	}
+
+	log.Printf("[INFO] Connecting to remote signer (NIP-46) %s", bunkerURL)
+	parsed, err := url.Parse(bunkerURL)
+	if err != nil || parsed.Scheme != "bunker" || !nostr.IsValidPublicKey(parsed.Host) {
+		return nil, fmt.Errorf("invalid bunker URL (must be bunker://<pubkey hex>?relay=...): %s", bunkerURL)
+	}
+
+	// The client listens for responses for as long as ctx lives; only the handshake is
+	// bounded, or every later signing request would wait for a reply nobody receives
+	pool := nostr.NewSimplePool(ctx)
+	bunker := nip46.NewBunker(ctx, secretKey, parsed.Host, parsed.Query()["relay"], pool, func(authURL string) {
+		log.Printf("[INFO] Remote signer requires approval, open: %s", authURL)
+	})
+
+	connectCtx, cancel := context.WithTimeout(ctx, signTimeout)
+	defer cancel()
+	if _, err := bunker.RPC(connectCtx, "connect", []string{parsed.Host, parsed.Query().Get("secret")}); err != nil {
+		return nil, fmt.Errorf("failed to connect to bunker: %w", err)
+	}
+
+	signer := keyer.NewBunkerSignerFromBunkerClient(bunker)
+	pubkey, err := signer.GetPublicKey(connectCtx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get public key from bunker: %w", err)
+	}
+	log.Printf("[INFO] Remote signer connected, signing as %s", pubkey)
+
+	return signer, nil
+}
+
+// newAuthSigner returns the signer for the daemon's NIP-42 AUTH answers: always the local
+// node key, even when a bunker signs status events, so relay access does not wait on the
+// remote signer approving every challenge. Relays that only admit known keys must list
+// the node's npub.
+func newAuthSigner(secretKey string) (nostr.Signer, error) {
+	return keyer.NewPlainKeySigner(secretKey)
+}
+
+// signEvent signs ev with signer, waiting at most signTimeout for remote approval
+func signEvent(ctx context.Context, signer nostr.Signer, ev *nostr.Event) error {
+	signCtx, cancel := context.WithTimeout(ctx, signTimeout)
+	defer cancel()
+	return signer.SignEvent(signCtx, ev)
+}
+
+// signerAuthHandler answers NIP-42 AUTH challenges from relays with signer. The CLI
+// commands pass their event signer, so with a bunker a developer authenticates as the key
+// that authored the event; the daemon authenticates with newAuthSigner instead.
+func signerAuthHandler(signer nostr.Signer) nostr.WithAuthHandler {
+	return nostr.WithAuthHandler(func(authCtx context.Context, authEvent nostr.RelayEvent) error {
+		evt := authEvent.Event
+		log.Printf("[DEBUG] Relay %s requested auth, signing challenge", authEvent.Relay.URL)
+		log.Printf("[DEBUG] AUTH challenge tags: %v", evt.Tags)
+		if err := signEvent(authCtx, signer, evt); err != nil {
+			log.Printf("[ERROR] Failed to sign AUTH event: %v", err)
+			return err
+		}
+		log.Printf("[INFO] Successfully signed AUTH event for %s", authEvent.Relay.URL)
+		return nil
+	})
+}
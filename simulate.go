@@ -0,0 +1,290 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/nbd-wtf/go-nostr"
+	"gopkg.in/yaml.v3"
+)
+
+// simScenario is a set of hypothetical HyperSignals, read from YAML by simulate
+type simScenario struct {
+	Signals []simSignal `yaml:"signals"`
+}
+
+// simSignal is one hypothetical HyperSignal
+type simSignal struct {
+	Dev       string `yaml:"dev"`               // npub or NIP-05 identifier of a follow in config.yaml
+	Action    string `yaml:"action"`            // upgrade or reboot
+	Version   string `yaml:"version"`           // Semantic version
+	Genesis   string `yaml:"genesis,omitempty"` // Genesis URL for reboot
+	Hash      string `yaml:"hash,omitempty"`    // Binary hash; derived from the version if empty
+	Network   string `yaml:"network,omitempty"` // Defaults to the config network
+	CreatedAt string `yaml:"created_at"`        // Offset from the start (e.g. 10m) or RFC3339 time
+}
+
+// simStep is a signed scenario signal in publishing order
+type simStep struct {
+	dev   string
+	event nostr.Event
+}
+
+// loadScenario reads a simulation scenario from a YAML file
+func loadScenario(path string) (*simScenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var sc simScenario
+	if err := yaml.Unmarshal(data, &sc); err != nil {
+		return nil, err
+	}
+	if len(sc.Signals) == 0 {
+		return nil, fmt.Errorf("no signals")
+	}
+	return &sc, nil
+}
+
+// simFollowName is how a follow is named in simulation output: its NIP-05 identifier if
+// it has one, otherwise its npub
+func simFollowName(f FollowEntry) string {
+	if f.NIP05 != "" {
+		return f.NIP05
+	}
+	return f.Npub
+}
+
+// signScenario turns the scenario into signed events, ordered by created_at (signals at the
+// same time keep their order in the file). Every follow in cfg stands in with a throwaway
+// key, so nothing is signed with a real developer key; it returns those keys by follow
+// name. A dev that is not a follow signs with a throwaway key nobody follows.
+func signScenario(sc *simScenario, cfg *Config, start time.Time) ([]simStep, map[string]string, error) {
+	keys := make(map[string]string)    // dev -> secret key
+	pubkeys := make(map[string]string) // follow name -> public key
+	for _, f := range cfg.Follows {
+		name := simFollowName(f)
+		sk := nostr.GeneratePrivateKey()
+		pubkeys[name], _ = nostr.GetPublicKey(sk)
+		for _, id := range []string{f.Npub, f.NIP05} {
+			if id != "" {
+				keys[id] = sk
+			}
+		}
+	}
+	steps := make([]simStep, 0, len(sc.Signals))
+
+	for i, s := range sc.Signals {
+		if s.Dev == "" || s.Action == "" || s.Version == "" {
+			return nil, nil, fmt.Errorf("signal %d: dev, action and version are required", i+1)
+		}
+		createdAt, err := parseSimTime(s.CreatedAt, start)
+		if err != nil {
+			return nil, nil, fmt.Errorf("signal %d: %w", i+1, err)
+		}
+		if keys[s.Dev] == "" {
+			keys[s.Dev] = nostr.GeneratePrivateKey()
+		}
+
+		hash := s.Hash
+		if hash == "" {
+			sum := sha256.Sum256([]byte(s.Action + ":" + s.Version))
+			hash = hex.EncodeToString(sum[:])
+		}
+		if s.Network == "" {
+			s.Network = cfg.Network
+		}
+		tags := nostr.Tags{
+			{"d", "hyperqube"},
+			{"version", s.Version},
+			{"hash", hash},
+			{"network", s.Network},
+			{"action", s.Action},
+		}
+		if s.Genesis != "" {
+			tags = append(tags, nostr.Tag{"genesis_url", s.Genesis})
+		}
+
+		ev := nostr.Event{Kind: 33321, CreatedAt: nostr.Timestamp(createdAt.Unix()), Tags: tags}
+		if err := ev.Sign(keys[s.Dev]); err != nil {
+			return nil, nil, fmt.Errorf("signal %d: %w", i+1, err)
+		}
+		steps = append(steps, simStep{dev: s.Dev, event: ev})
+	}
+
+	sort.SliceStable(steps, func(i, j int) bool { return steps[i].event.CreatedAt < steps[j].event.CreatedAt })
+	return steps, pubkeys, nil
+}
+
+// parseSimTime parses a created_at offset from start, or an absolute RFC3339 time
+func parseSimTime(value string, start time.Time) (time.Time, error) {
+	if value == "" {
+		return start, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return start.Add(d), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("created_at %q is neither a duration nor an RFC3339 time", value)
+	}
+	return t, nil
+}
+
+// simulate publishes the scenario signals one at a time and, after each, prints the vote
+// tallies, superseded votes and the actions the quorum check would select. The daemon
+// checks quorum whenever a vote arrives and again on every tick, each check executing the
+// highest action at quorum that is not in history yet, so after each signal the check is
+// repeated until nothing is left to execute. pubkeys are the stand-in keys of the follows
+// by name. It returns the keys of the actions executed and of those that were downgrades.
+func simulate(w io.Writer, steps []simStep, pubkeys map[string]string, cfg *Config, history *History) (executed, downgrades []string) {
+	devs := make(map[string]string, len(pubkeys)) // pubkey -> dev
+	static := make([]string, 0, len(pubkeys))
+	for dev, pk := range pubkeys {
+		devs[pk] = dev
+		static = append(static, pk)
+	}
+	follows := newFollowSet(static)
+	state := newSignalState()
+
+	// The highest version executed per action type, starting from this node's history
+	highest := make(map[string]*CandidateAction)
+	for key, e := range history.Entries {
+		v, err := semver.NewVersion(e.Version)
+		if err == nil && (highest[e.Type] == nil || v.GreaterThan(highest[e.Type].Version)) {
+			highest[e.Type] = &CandidateAction{Key: key, Type: e.Type, Version: v}
+		}
+	}
+
+	for i, step := range steps {
+		ev := &step.event
+		at := ev.CreatedAt.Time()
+		fmt.Fprintf(w, "Step %d at +%s: %s signals %s %s\n", i+1, at.Sub(steps[0].event.CreatedAt.Time()),
+			step.dev, getTagValue(ev, "action"), getTagValue(ev, "version"))
+
+		state.mu.RLock()
+		previous := state.signalActionMap[ev.PubKey]
+		state.mu.RUnlock()
+
+		if r := state.ingest(ev, cfg, follows.Snapshot(), at); r != nil {
+			fmt.Fprintf(w, "  rejected: %s\n", r)
+		} else {
+			state.mu.RLock()
+			current := state.signalActionMap[ev.PubKey]
+			state.mu.RUnlock()
+			if previous != "" && previous != current {
+				fmt.Fprintf(w, "  supersedes %s's vote for %s\n", step.dev, previous)
+			}
+		}
+
+		state.mu.Lock()
+		latest, votes := quorumActionLocked(state, cfg, history, follows)
+		actions := make([]*CandidateAction, 0, len(state.actions))
+		for _, a := range state.actions {
+			actions = append(actions, a)
+		}
+		state.mu.Unlock()
+
+		// Tally, highest version first
+		sort.Slice(actions, func(i, j int) bool {
+			if !actions[i].Version.Equal(actions[j].Version) {
+				return actions[i].Version.GreaterThan(actions[j].Version)
+			}
+			return actions[i].Key < actions[j].Key
+		})
+		for _, a := range actions {
+			var voters []string
+			for pk := range votes[a.Key] {
+				voters = append(voters, devs[pk])
+			}
+			sort.Strings(voters)
+			note := ""
+			if history.Has(a.Key) {
+				note = " (executed)"
+			}
+			fmt.Fprintf(w, "  %-40s %d/%d %s%s\n", a.Key, len(voters), cfg.Quorum, strings.Join(voters, ", "), note)
+		}
+
+		for latest != nil {
+			history.Add(latest.Key, HistoryEntry{ExecutedAt: at, Type: latest.Type, Version: latest.Version.Original(), Status: "success"})
+			executed = append(executed, latest.Key)
+			if prev := highest[latest.Type]; prev != nil && latest.Version.LessThan(prev.Version) {
+				downgrades = append(downgrades, latest.Key)
+				fmt.Fprintf(w, "  => would execute %s: DOWNGRADE after %s\n", latest.Key, prev.Key)
+			} else {
+				highest[latest.Type] = latest
+				fmt.Fprintf(w, "  => would execute %s\n", latest.Key)
+			}
+
+			state.mu.Lock()
+			latest, _ = quorumActionLocked(state, cfg, history, follows)
+			state.mu.Unlock()
+		}
+	}
+	return executed, downgrades
+}
+
+// simulateCLI runs a scenario of hypothetical signals against the config, so proposers can
+// check that a publishing order never activates an intermediate version. Nothing is
+// published or written.
+func simulateCLI(configDir string, verbose bool, args []string) {
+	flagSet := flag.NewFlagSet("simulate", flag.ExitOnError)
+	flagSet.Parse(args)
+	if flagSet.NArg() != 1 {
+		log.Fatal("[ERROR] Usage: qube-manager simulate <scenario.yaml>")
+	}
+
+	sc, err := loadScenario(flagSet.Arg(0))
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to read scenario %s: %v", flagSet.Arg(0), err)
+	}
+
+	cfg := loadConfig(configDir)
+
+	// Times are in whole seconds, like event timestamps
+	start := time.Now().Truncate(time.Second)
+	steps, pubkeys, err := signScenario(sc, &cfg, start)
+	if err != nil {
+		log.Fatalf("[ERROR] Invalid scenario %s: %v", flagSet.Arg(0), err)
+	}
+
+	// Actions already in this node's history are skipped
+	history, err := readHistory(configDir)
+	if err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+
+	fmt.Printf("Simulating %d signal(s) against %d follow(s) (network=%s, quorum=%d)\n",
+		len(steps), len(pubkeys), cfg.Network, cfg.Quorum)
+	if len(cfg.ReleaseKeys) > 0 {
+		fmt.Println("Note: release signatures are not simulated")
+	}
+
+	// Ingestion logs every vote; the simulation prints its own steps
+	if !verbose {
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(os.Stderr)
+	}
+
+	executed, downgrades := simulate(os.Stdout, steps, pubkeys, &cfg, history)
+	if len(downgrades) > 0 {
+		fmt.Printf("WARNING: %d downgrade(s) would be executed: %s\n", len(downgrades), strings.Join(downgrades, ", "))
+	}
+	switch len(executed) {
+	case 0:
+		fmt.Println("No action would be executed")
+	case 1:
+		fmt.Printf("Would execute: %s\n", executed[0])
+	default:
+		fmt.Printf("WARNING: %d actions would be executed in turn: %s\n", len(executed), strings.Join(executed, ", "))
+	}
+}
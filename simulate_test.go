@@ -0,0 +1,154 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// simTestConfig follows alice, bob, carol and dave by NIP-05 identifier with a quorum of 3
+func simTestConfig() Config {
+	cfg := Config{Quorum: 3, Network: "hqz", MaxClockSkew: defaultMaxClockSkew}
+	for _, name := range []string{"alice", "bob", "carol", "dave"} {
+		cfg.Follows = append(cfg.Follows, FollowEntry{NIP05: name + "@example.com"})
+	}
+	return cfg
+}
+
+func runScenario(t *testing.T, cfg Config, scenario string) (executed, downgrades []string, out string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "scenario.yaml")
+	if err := os.WriteFile(path, []byte(scenario), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sc, err := loadScenario(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	steps, pubkeys, err := signScenario(sc, &cfg, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	history := &History{Entries: make(map[string]*HistoryEntry)}
+	executed, downgrades = simulate(&b, steps, pubkeys, &cfg, history)
+	return executed, downgrades, b.String()
+}
+
+func TestSimulateIntermediateVersion(t *testing.T) {
+	// The devs first agree on 1.1.0 and only then move on to 1.2.0, so 1.1.0 activates
+	executed, _, out := runScenario(t, simTestConfig(), `
+signals:
+  - {dev: alice@example.com, action: upgrade, version: 1.1.0, created_at: 0s}
+  - {dev: bob@example.com,   action: upgrade, version: 1.1.0, created_at: 1m}
+  - {dev: carol@example.com, action: upgrade, version: 1.1.0, created_at: 2m}
+  - {dev: alice@example.com, action: upgrade, version: 1.2.0, created_at: 1h}
+  - {dev: bob@example.com,   action: upgrade, version: 1.2.0, created_at: 1h}
+  - {dev: carol@example.com, action: upgrade, version: 1.2.0, created_at: 1h}
+`)
+	if want := []string{"upgrade:1.1.0", "upgrade:1.2.0"}; !slices.Equal(executed, want) {
+		t.Fatalf("executed %v, want %v\n%s", executed, want, out)
+	}
+	if !strings.Contains(out, "supersedes alice@example.com's vote for upgrade:1.1.0") {
+		t.Errorf("superseded vote not reported:\n%s", out)
+	}
+}
+
+func TestSimulateSwitchBeforeQuorum(t *testing.T) {
+	// carol switches to 1.2.0 before voting for 1.1.0 would complete quorum
+	executed, _, out := runScenario(t, simTestConfig(), `
+signals:
+  - {dev: alice@example.com, action: upgrade, version: 1.1.0, created_at: 0s}
+  - {dev: bob@example.com,   action: upgrade, version: 1.1.0, created_at: 1m}
+  - {dev: alice@example.com, action: upgrade, version: 1.2.0, created_at: 10m}
+  - {dev: bob@example.com,   action: upgrade, version: 1.2.0, created_at: 11m}
+  - {dev: carol@example.com, action: upgrade, version: 1.2.0, created_at: 12m}
+  - {dev: dave@example.com,  action: upgrade, version: 1.3.0, network: testnet, created_at: 13m}
+`)
+	if want := []string{"upgrade:1.2.0"}; !slices.Equal(executed, want) {
+		t.Fatalf("executed %v, want %v\n%s", executed, want, out)
+	}
+	if !strings.Contains(out, "rejected: wrong_network") {
+		t.Errorf("wrong network signal not rejected:\n%s", out)
+	}
+}
+
+func TestSimulateUsesConfigFollowsAndQuorum(t *testing.T) {
+	real := newTestDevs(1)[0]
+	npub, _ := nip19.EncodePublicKey(real.pk)
+	cfg := simTestConfig()
+	cfg.Quorum = 2
+	cfg.Follows = append(cfg.Follows, FollowEntry{Npub: npub})
+
+	// mallory is not a follow, so that vote never count towards the quorum of 2
+	scenario := `
+signals:
+  - {dev: mallory, action: upgrade, version: 1.1.0, created_at: 0s}
+  - {dev: alice@example.com, action: upgrade, version: 1.1.0, created_at: 1m}
+  - {dev: ` + npub + `, action: upgrade, version: 1.1.0, created_at: 2m}
+`
+	executed, _, out := runScenario(t, cfg, scenario)
+	if want := []string{"upgrade:1.1.0"}; !slices.Equal(executed, want) {
+		t.Fatalf("executed %v, want %v\n%s", executed, want, out)
+	}
+	if !strings.Contains(out, "rejected: unknown_author") {
+		t.Errorf("non-follow signal not rejected:\n%s", out)
+	}
+	if !strings.Contains(out, "2/2 alice@example.com, "+npub) {
+		t.Errorf("tally does not show the two follows at quorum 2:\n%s", out)
+	}
+
+	// Every follow stands in with a throwaway key, never the developer's own
+	sc := &simScenario{Signals: []simSignal{{Dev: npub, Action: "upgrade", Version: "1.1.0"}}}
+	steps, pubkeys, err := signScenario(sc, &cfg, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pubkeys) != len(cfg.Follows) {
+		t.Errorf("%d stand-in keys, want one per follow (%d)", len(pubkeys), len(cfg.Follows))
+	}
+	if pk := steps[0].event.PubKey; pk == real.pk || pk != pubkeys[npub] {
+		t.Errorf("signal signed by %s, want the stand-in key %s", pk, pubkeys[npub])
+	}
+}
+
+func TestSimulateTwoVersionsAtQuorum(t *testing.T) {
+	// Both versions reach quorum: 1.2.0 executes first, and the next check still finds
+	// 1.1.0 at quorum and not in history, so the node downgrades to it
+	cfg := simTestConfig()
+	cfg.Quorum = 2
+	executed, downgrades, out := runScenario(t, cfg, `
+signals:
+  - {dev: alice@example.com, action: upgrade, version: 1.1.0, created_at: 0s}
+  - {dev: carol@example.com, action: upgrade, version: 1.2.0, created_at: 0s}
+  - {dev: dave@example.com,  action: upgrade, version: 1.2.0, created_at: 0s}
+  - {dev: bob@example.com,   action: upgrade, version: 1.1.0, created_at: 1m}
+`)
+	if want := []string{"upgrade:1.2.0", "upgrade:1.1.0"}; !slices.Equal(executed, want) {
+		t.Fatalf("executed %v, want %v\n%s", executed, want, out)
+	}
+	if want := []string{"upgrade:1.1.0"}; !slices.Equal(downgrades, want) {
+		t.Errorf("downgrades %v, want %v\n%s", downgrades, want, out)
+	}
+	if !strings.Contains(out, "would execute upgrade:1.1.0: DOWNGRADE after upgrade:1.2.0") {
+		t.Errorf("downgrade not flagged:\n%s", out)
+	}
+
+	// Agreeing on 1.1.0 before moving on to 1.2.0 is no downgrade
+	executed, downgrades, out = runScenario(t, cfg, `
+signals:
+  - {dev: alice@example.com, action: upgrade, version: 1.1.0, created_at: 0s}
+  - {dev: bob@example.com,   action: upgrade, version: 1.1.0, created_at: 0s}
+  - {dev: carol@example.com, action: upgrade, version: 1.2.0, created_at: 1m}
+  - {dev: dave@example.com,  action: upgrade, version: 1.2.0, created_at: 1m}
+`)
+	if want := []string{"upgrade:1.1.0", "upgrade:1.2.0"}; !slices.Equal(executed, want) || len(downgrades) != 0 {
+		t.Errorf("executed %v (downgrades %v), want %v in order\n%s", executed, downgrades, want, out)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/coder/websocket"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// testRelay is an in-memory stand-in for Qubestr: it stores every event it accepts,
+// answers REQ with the matching stored events and EOSE, forwards new events to open
+// subscriptions and replies OK to EVENT. Replaceable events are not collapsed, so tests
+// can deliver superseded signals. With requireAuth, clients get a NIP-42 challenge and
+// are refused with auth-required until they answer it.
+type testRelay struct {
+	URL         string
+	requireAuth bool
+
+	mu      sync.Mutex
+	events  []nostr.Event
+	clients map[*testRelayClient]bool
+	reqs    int             // REQs answered, to tell when a daemon has subscribed
+	authed  map[string]bool // Pubkeys that completed AUTH
+}
+
+// testRelayClient is one websocket connection to the test relay
+type testRelayClient struct {
+	conn      *websocket.Conn
+	writeMu   sync.Mutex
+	challenge string
+	authed    string                   // Authenticated pubkey, empty until AUTH
+	subs      map[string]nostr.Filters // Guarded by testRelay.mu
+}
+
+// newTestRelay starts a test relay that is shut down when the test ends
+func newTestRelay(t *testing.T, requireAuth bool) *testRelay {
+	t.Helper()
+	skipUnderRace(t)
+	tr := &testRelay{
+		requireAuth: requireAuth,
+		clients:     make(map[*testRelayClient]bool),
+		authed:      make(map[string]bool),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(tr.serve))
+	t.Cleanup(func() {
+		tr.mu.Lock()
+		for c := range tr.clients {
+			c.conn.CloseNow()
+		}
+		tr.mu.Unlock()
+		srv.Close()
+	})
+	tr.URL = nostr.NormalizeURL("ws" + strings.TrimPrefix(srv.URL, "http"))
+	return tr
+}
+
+// serve handles one client connection until it closes
+func (tr *testRelay) serve(w http.ResponseWriter, req *http.Request) {
+	conn, err := websocket.Accept(w, req, nil)
+	if err != nil {
+		return
+	}
+	conn.SetReadLimit(1 << 20)
+	c := &testRelayClient{conn: conn, challenge: nostr.GeneratePrivateKey()[:16], subs: make(map[string]nostr.Filters)}
+
+	tr.mu.Lock()
+	tr.clients[c] = true
+	tr.mu.Unlock()
+	defer func() {
+		tr.mu.Lock()
+		delete(tr.clients, c)
+		tr.mu.Unlock()
+		conn.CloseNow()
+	}()
+
+	ctx := req.Context()
+	if tr.requireAuth {
+		c.send(ctx, nostr.AuthEnvelope{Challenge: &c.challenge})
+	}
+
+	for {
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			return
+		}
+		switch env := nostr.ParseMessage(string(data)).(type) {
+		case *nostr.ReqEnvelope:
+			tr.handleReq(ctx, c, env)
+		case *nostr.CloseEnvelope:
+			tr.mu.Lock()
+			delete(c.subs, string(*env))
+			tr.mu.Unlock()
+		case *nostr.EventEnvelope:
+			tr.handleEvent(ctx, c, env.Event)
+		case *nostr.AuthEnvelope:
+			tr.handleAuth(ctx, c, env.Event)
+		}
+	}
+}
+
+// send writes one message to the client
+func (c *testRelayClient) send(ctx context.Context, env json.Marshaler) {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.Write(ctx, websocket.MessageText, data)
+}
+
+// handleReq replays the stored events matching the filters, then keeps the subscription open
+func (tr *testRelay) handleReq(ctx context.Context, c *testRelayClient, env *nostr.ReqEnvelope) {
+	if tr.requireAuth && c.authed == "" {
+		c.send(ctx, nostr.ClosedEnvelope{SubscriptionID: env.SubscriptionID, Reason: "auth-required: authenticate to read"})
+		return
+	}
+
+	tr.mu.Lock()
+	var stored []nostr.Event
+	for _, ev := range tr.events {
+		if env.Filters.Match(&ev) {
+			stored = append(stored, ev)
+		}
+	}
+	c.subs[env.SubscriptionID] = env.Filters
+	tr.reqs++
+	tr.mu.Unlock()
+
+	for _, ev := range stored {
+		c.send(ctx, nostr.EventEnvelope{SubscriptionID: &env.SubscriptionID, Event: ev})
+	}
+	c.send(ctx, nostr.EOSEEnvelope(env.SubscriptionID))
+}
+
+// handleEvent verifies and stores an event published by a client, answering OK
+func (tr *testRelay) handleEvent(ctx context.Context, c *testRelayClient, ev nostr.Event) {
+	ok, reason := true, ""
+	switch {
+	case tr.requireAuth && c.authed == "":
+		ok, reason = false, "auth-required: authenticate to publish"
+	default:
+		if valid, _ := ev.CheckSignature(); !valid || ev.GetID() != ev.ID {
+			ok, reason = false, "invalid: bad signature"
+		}
+	}
+	if ok && !tr.publish(ev) {
+		reason = "duplicate: already have this event"
+	}
+	c.send(ctx, nostr.OKEnvelope{EventID: ev.ID, OK: ok, Reason: reason})
+}
+
+// handleAuth accepts a kind 22242 event answering this connection's challenge
+func (tr *testRelay) handleAuth(ctx context.Context, c *testRelayClient, ev nostr.Event) {
+	valid, _ := ev.CheckSignature()
+	tag := ev.Tags.Find("challenge")
+	ok := valid && ev.Kind == nostr.KindClientAuthentication && tag != nil && tag[1] == c.challenge
+	reason := ""
+	if ok {
+		c.authed = ev.PubKey
+		tr.mu.Lock()
+		tr.authed[ev.PubKey] = true
+		tr.mu.Unlock()
+	} else {
+		reason = "auth-required: invalid AUTH event"
+	}
+	c.send(ctx, nostr.OKEnvelope{EventID: ev.ID, OK: ok, Reason: reason})
+}
+
+// publish stores ev and forwards it to every open subscription it matches. Like a real
+// relay it keeps one copy per event ID, returning false for a duplicate.
+func (tr *testRelay) publish(ev nostr.Event) bool {
+	type delivery struct {
+		c     *testRelayClient
+		subID string
+	}
+
+	tr.mu.Lock()
+	if slices.ContainsFunc(tr.events, func(e nostr.Event) bool { return e.ID == ev.ID }) {
+		tr.mu.Unlock()
+		return false
+	}
+	tr.events = append(tr.events, ev)
+	var out []delivery
+	for c := range tr.clients {
+		for id, filters := range c.subs {
+			if filters.Match(&ev) {
+				out = append(out, delivery{c, id})
+			}
+		}
+	}
+	tr.mu.Unlock()
+
+	for _, d := range out {
+		d.c.send(context.Background(), nostr.EventEnvelope{SubscriptionID: &d.subID, Event: ev})
+	}
+	return true
+}
+
+// query returns the stored events matching f
+func (tr *testRelay) query(f nostr.Filter) []nostr.Event {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	var out []nostr.Event
+	for _, ev := range tr.events {
+		if f.Matches(&ev) {
+			out = append(out, ev)
+		}
+	}
+	return out
+}
+
+// subscriptions returns the number of REQs answered so far
+func (tr *testRelay) subscriptions() int {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	return tr.reqs
+}
+
+// authenticated reports whether pubkey completed AUTH on any connection
+func (tr *testRelay) authenticated(pubkey string) bool {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	return tr.authed[pubkey]
+}
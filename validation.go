@@ -8,24 +8,32 @@ import (
 	"os"
 )
 
-// verifyBinaryHash computes the SHA256 hash of a file and compares it to the expected hash.
-// Returns nil if the hash matches, or an error describing the mismatch.
-func verifyBinaryHash(binaryPath, expectedHash string) error {
+// fileSHA256 returns the hex-encoded SHA256 hash of a file, as published in HyperSignals
+func fileSHA256(path string) (string, error) {
 	// Open the binary file
-	f, err := os.Open(binaryPath)
+	f, err := os.Open(path)
 	if err != nil {
-		return fmt.Errorf("failed to open binary: %w", err)
+		return "", fmt.Errorf("failed to open binary: %w", err)
 	}
 	defer f.Close()
 
 	// Compute SHA256 hash
 	h := sha256.New()
 	if _, err := io.Copy(h, f); err != nil {
-		return fmt.Errorf("failed to read binary: %w", err)
+		return "", fmt.Errorf("failed to read binary: %w", err)
 	}
 
 	// Get hex-encoded hash
-	actualHash := hex.EncodeToString(h.Sum(nil))
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// verifyBinaryHash computes the SHA256 hash of a file and compares it to the expected hash.
+// Returns nil if the hash matches, or an error describing the mismatch.
+func verifyBinaryHash(binaryPath, expectedHash string) error {
+	actualHash, err := fileSHA256(binaryPath)
+	if err != nil {
+		return err
+	}
 
 	// Compare hashes
 	if actualHash != expectedHash {
@@ -34,3 +42,17 @@ func verifyBinaryHash(binaryPath, expectedHash string) error {
 
 	return nil
 }
+
+// validateHash checks that hash has the form fileSHA256 produces: 64 lowercase hex
+// characters. Nodes compare hashes as strings, so any other form never matches.
+func validateHash(hash string) error {
+	if len(hash) != 64 {
+		return fmt.Errorf("hash must be 64 hex characters, got %d", len(hash))
+	}
+	for _, c := range hash {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return fmt.Errorf("hash must be lowercase hex, found %q", c)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateHash(t *testing.T) {
+	good := strings.Repeat("0123456789abcdef", 4)
+	if err := validateHash(good); err != nil {
+		t.Errorf("validateHash(%s) = %v", good, err)
+	}
+	for _, bad := range []string{"", good[:63], good + "0", strings.ToUpper(good), "0x" + good[2:], good[:63] + "g"} {
+		if validateHash(bad) == nil {
+			t.Errorf("validateHash(%q) accepted", bad)
+		}
+	}
+}
+
+func TestHashBinaries(t *testing.T) {
+	dir := t.TempDir()
+	amd64 := filepath.Join(dir, "qube-amd64")
+	arm64 := filepath.Join(dir, "qube-arm64")
+	os.WriteFile(amd64, []byte("amd64 build"), 0755)
+	os.WriteFile(arm64, []byte("arm64 build"), 0755)
+
+	armHash, err := fileSHA256(arm64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	amdHash, _ := fileSHA256(amd64)
+	if err := verifyBinaryHash(arm64, armHash); err != nil {
+		t.Errorf("verifyBinaryHash: %v", err)
+	}
+
+	// Two platforms give two labelled hashes
+	specs := []string{"linux-amd64=" + amd64, "linux-arm64=" + arm64}
+	hashes, err := hashBinaries(specs, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hashes) != 2 || hashes[0].Hash != amdHash || hashes[0].Platform != "linux-amd64" ||
+		hashes[1].Hash != armHash || hashes[1].Platform != "linux-arm64" || hashes[1].Path != arm64 {
+		t.Errorf("hashes = %+v, want linux-amd64 then linux-arm64", hashes)
+	}
+
+	// A -hash labelled with a platform is checked against that binary and listed first
+	hashes, err = hashBinaries(specs, "linux-arm64="+armHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hashes[0].Hash != armHash || hashes[0].Platform != "linux-arm64" {
+		t.Errorf("hashes = %+v, want linux-arm64 first", hashes)
+	}
+
+	// A path without a label is not split on '=' in a directory name
+	odd := filepath.Join(dir, "a=b")
+	os.Mkdir(odd, 0755)
+	os.WriteFile(filepath.Join(odd, "qube"), []byte("odd"), 0755)
+	if hashes, err := hashBinaries([]string{filepath.Join(odd, "qube")}, ""); err != nil || hashes[0].Platform != "" {
+		t.Errorf("hashBinaries(a=b/qube) = %+v, %v", hashes, err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		specs []string
+		hash  string
+	}{
+		{"unlabelled -hash disagrees with one platform", specs, armHash},
+		{"labelled -hash disagrees with its platform", specs, "linux-amd64=" + armHash},
+		{"labelled -hash for a platform not given", specs, "darwin-arm64=" + armHash},
+		{"mismatching -hash", []string{amd64}, armHash},
+		{"missing binary", []string{filepath.Join(dir, "missing")}, ""},
+	} {
+		if _, err := hashBinaries(tc.specs, tc.hash); err == nil {
+			t.Errorf("%s: accepted", tc.name)
+		}
+	}
+	if hashes, err := hashBinaries([]string{arm64}, armHash); err != nil || hashes[0].Hash != armHash {
+		t.Errorf("hashBinaries with matching -hash = %+v, %v", hashes, err)
+	}
+}